@@ -0,0 +1,98 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/jbvmio/lfm"
+	"github.com/jbvmio/lfm/metrics"
+)
+
+// Server is an embedded HTTP server exposing the health, readiness and status of Pipelines.
+//
+// Endpoints:
+//
+//	/healthz         200 while all Pipelines are running.
+//	/readyz          200 while all Pipelines are ready, otherwise 503 with the unready Pipelines.
+//	/pipelines       Status of all Pipelines.
+//	/pipelines/NAME  Status of the named Pipeline.
+//	/metrics         Prometheus metrics.
+type Server struct {
+	pipelines *lfm.Pipelines
+	srv       *http.Server
+}
+
+// NewServer returns a new Server listening on the given address.
+func NewServer(addr string, pipelines *lfm.Pipelines) *Server {
+	s := &Server{
+		pipelines: pipelines,
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(`/healthz`, s.healthz)
+	mux.HandleFunc(`/readyz`, s.readyz)
+	mux.HandleFunc(`/pipelines`, s.list)
+	mux.HandleFunc(`/pipelines/`, s.get)
+	mux.Handle(`/metrics`, metrics.Handler())
+	s.srv = &http.Server{
+		Addr:    addr,
+		Handler: mux,
+	}
+	return s
+}
+
+// Start starts serving requests, returning an error if the Server could not be started.
+func (s *Server) Start() error {
+	return s.srv.ListenAndServe()
+}
+
+// Stop gracefully stops the Server.
+func (s *Server) Stop(ctx context.Context) error {
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) healthz(w http.ResponseWriter, r *http.Request) {
+	if !s.pipelines.Running() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{`status`: `stopped`})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{`status`: `ok`})
+}
+
+func (s *Server) readyz(w http.ResponseWriter, r *http.Request) {
+	var unready []lfm.PipelineStatus
+	for _, ps := range s.pipelines.Status() {
+		if !ps.Ready {
+			unready = append(unready, ps)
+		}
+	}
+	if len(unready) > 0 || !s.pipelines.Running() {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]interface{}{`status`: `unready`, `pipelines`: unready})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{`status`: `ready`})
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.pipelines.Status())
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, `/pipelines/`)
+	for _, ps := range s.pipelines.Status() {
+		if ps.Name == name {
+			writeJSON(w, http.StatusOK, ps)
+			return
+		}
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{`error`: `no pipeline named ` + name})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set(`Content-Type`, `application/json`)
+	w.WriteHeader(code)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
@@ -0,0 +1,277 @@
+package lfm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/jbvmio/lfm/driver"
+	"github.com/jbvmio/lfm/internal/drivers"
+	"github.com/jbvmio/lfm/metrics"
+	"github.com/jbvmio/lfm/pipeline"
+	"github.com/jbvmio/lfm/plugin"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+// Option configures how Pipelines are built.
+type Option func(*options)
+
+type options struct {
+	ctx     context.Context
+	L       *zap.Logger
+	reg     prometheus.Registerer
+	inputs  []plugin.Input
+	outputs []plugin.Output
+}
+
+func newOptions(opts []Option) (options, error) {
+	o := options{
+		ctx: context.Background(),
+		L:   zap.NewNop(),
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.reg != nil {
+		if err := metrics.Register(o.reg); err != nil {
+			return o, fmt.Errorf("error registering metrics: %w", err)
+		}
+	}
+	return o, nil
+}
+
+// WithContext sets the Context used by the Stages of each Pipeline, defaults to context.Background.
+func WithContext(ctx context.Context) Option {
+	return func(o *options) {
+		o.ctx = ctx
+	}
+}
+
+// WithLogger sets the Logger used by the Pipelines, defaults to a no-op Logger.
+func WithLogger(L *zap.Logger) Option {
+	return func(o *options) {
+		o.L = L
+	}
+}
+
+// WithMetrics registers the lfm metrics with the given Registerer, in addition to the default registry.
+func WithMetrics(reg prometheus.Registerer) Option {
+	return func(o *options) {
+		o.reg = reg
+	}
+}
+
+// WithInputs uses the given Inputs in place of the sources of the Config.
+// It only applies to NewPipeline, as Inputs cannot be shared between Pipelines.
+// The Inputs are stopped if the Pipeline cannot be built.
+func WithInputs(inputs ...plugin.Input) Option {
+	return func(o *options) {
+		o.inputs = inputs
+	}
+}
+
+// WithOutputs uses the given Outputs with the default Delivery options in place of the destinations of the Config.
+// It only applies to NewPipeline, as Outputs cannot be shared between Pipelines.
+// The Outputs are stopped if the Pipeline cannot be built.
+func WithOutputs(outputs ...plugin.Output) Option {
+	return func(o *options) {
+		o.outputs = outputs
+	}
+}
+
+// stopGiven stops any Inputs and Outputs given using WithInputs and WithOutputs.
+func (o options) stopGiven() {
+	stopPlugins(o.inputs, o.outputs, nil)
+}
+
+// New builds a Pipeline for each of the Configs, returning the collection ready to Run.
+func New(cfgs Configs, opts ...Option) (*Pipelines, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+	if o.inputs != nil || o.outputs != nil {
+		return nil, fmt.Errorf("inputs and outputs can only be given when using NewPipeline")
+	}
+	names := make([]string, 0, len(cfgs))
+	for name := range cfgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	P := &Pipelines{}
+	P.UseLogger(o.L.Sugar())
+	for _, name := range names {
+		p, err := o.build(name, cfgs[name])
+		if err != nil {
+			for _, built := range P.pls {
+				built.close()
+			}
+			return nil, fmt.Errorf("error loading pipeline %s: %w", name, err)
+		}
+		P.pls = append(P.pls, &p)
+	}
+	return P, nil
+}
+
+// NewPipeline builds the named Pipeline from the Config, ready to be added to a collection of Pipelines.
+func NewPipeline(name string, c Config, opts ...Option) (Pipeline, error) {
+	o, err := newOptions(opts)
+	if err != nil {
+		o.stopGiven()
+		return Pipeline{}, err
+	}
+	return o.build(name, c)
+}
+
+// NewProcessors builds the Drivers for the named Config, returning them with the processing func for each Stage.
+// The results may be used to replace the processors of a running Pipeline using SwapProcessors.
+func NewProcessors(name string, c Config) ([][][]driver.Driver, []pipeline.DataFunc, error) {
+	c, err := copyConfig(c)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newProcessors(name, c)
+}
+
+// build loads all plugins and processors for the named Config and returns the resulting Pipeline.
+// The Config is copied before loading as the loaders modify plugin details.
+// If the Pipeline cannot be built, any plugins, queues and Drivers already created are stopped or closed.
+func (o options) build(name string, c Config) (pl Pipeline, err error) {
+	orig := c
+	c, err = copyConfig(c)
+	if err != nil {
+		o.stopGiven()
+		return pl, err
+	}
+	cfg := Configs{name: c}
+	inputs := map[string][]plugin.Input{name: o.inputs}
+	outputs := map[string][]plugin.Output{name: o.outputs}
+	deadLetters := map[string]plugin.Output{}
+	var stages [][][]driver.Driver
+	defer func() {
+		if err != nil {
+			stopPlugins(inputs[name], outputs[name], deadLetters[name])
+			CloseDrivers(stages)
+		}
+	}()
+	if o.inputs == nil {
+		if inputs, err = loadInputs(cfg); err != nil {
+			return pl, fmt.Errorf("error loading inputs: %w", err)
+		}
+	}
+	if o.outputs == nil {
+		if outputs, err = loadOutputs(cfg); err != nil {
+			return pl, fmt.Errorf("error loading outputs: %w", err)
+		}
+	}
+	if len(outputs[name]) < 1 {
+		return pl, fmt.Errorf("no output for %s", name)
+	}
+	if err := loadQueues(cfg, inputs, outputs); err != nil {
+		return pl, fmt.Errorf("error loading queues: %w", err)
+	}
+	if deadLetters, err = loadDeadLetters(cfg); err != nil {
+		return pl, fmt.Errorf("error loading dead letter outputs: %w", err)
+	}
+	var deliveries []Delivery
+	if o.outputs == nil {
+		if deliveries, err = c.Deliveries(); err != nil {
+			return pl, fmt.Errorf("error configuring delivery: %w", err)
+		}
+	}
+	for n, d := range deliveries {
+		if d.Policy == PolicyDeadLetter && deadLetters[name] == nil {
+			return pl, fmt.Errorf("deadLetter delivery policy for output %d requires a deadLetter output", n)
+		}
+	}
+	stages, funcs, err := newProcessors(name, c)
+	if err != nil {
+		return pl, err
+	}
+	for a, x := range stages {
+		for b, y := range x {
+			for d := range y {
+				o.L.Info("discovered drivers", zap.String("PIPELINE", name), zap.Int("STAGE", a), zap.Int("STEP", b), zap.Int("DRIVER", d))
+			}
+		}
+	}
+	S := o.L.With(zap.String(`pipeline`, name)).Sugar()
+	stageCfgs := c.StageOrder()
+	p := pipeline.NewPipeline(o.ctx, S)
+	p.Name = name
+	for n := range stages {
+		SL := S.With(zap.Int(`stage`, n))
+		s := pipeline.NewStage(o.ctx, SL)
+		if err := stageCfgs[n].ConfigureStage(&s); err != nil {
+			return pl, fmt.Errorf("error configuring stage: %w", err)
+		}
+		s.Processors = []pipeline.DataFunc{funcs[n]}
+		p.AddStages(&s)
+	}
+	return Pipeline{
+		Name:       name,
+		Inputs:     inputs[name],
+		Outputs:    outputs[name],
+		DeadLetter: deadLetters[name],
+		Deliveries: deliveries,
+		Stages:     stages,
+		Config:     orig,
+		P:          p,
+		L:          S,
+	}, nil
+}
+
+func newProcessors(name string, c Config) ([][][]driver.Driver, []pipeline.DataFunc, error) {
+	processors, err := loadProcessors(Configs{name: c})
+	if err != nil {
+		return nil, nil, fmt.Errorf("error loading processors: %w", err)
+	}
+	stages := processors[name]
+	funcs := make([]pipeline.DataFunc, len(stages))
+	for n, steps := range stages {
+		funcs[n] = drivers.MakeDriversFunc(name, n, steps)
+	}
+	return stages, funcs, nil
+}
+
+// PluginDetails returns the details for the named Input or Output Plugin from a Go struct,
+// such as its Config, for use as a source, destination or deadLetter in a programmatic Config.
+func PluginDetails(name string, cfg interface{}) (map[string]interface{}, error) {
+	return details(`plugin`, name, cfg)
+}
+
+// DriverDetails returns the details for the named Driver from a Go struct, such as its Config,
+// for use as a Step Workflow in a programmatic Config.
+func DriverDetails(name string, cfg interface{}) (map[string]interface{}, error) {
+	return details(`driver`, name, cfg)
+}
+
+func details(key, name string, cfg interface{}) (map[string]interface{}, error) {
+	d := make(map[string]interface{})
+	if cfg != nil {
+		b, err := yaml.Marshal(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s details for %s: %w", key, name, err)
+		}
+		if err := yaml.Unmarshal(b, &d); err != nil {
+			return nil, fmt.Errorf("invalid %s details for %s: %w", key, name, err)
+		}
+	}
+	d[key] = name
+	return d, nil
+}
+
+// copyConfig returns a deep copy of the Config.
+func copyConfig(c Config) (Config, error) {
+	var cp Config
+	b, err := yaml.Marshal(c)
+	if err != nil {
+		return cp, fmt.Errorf("could not copy config: %w", err)
+	}
+	if err := yaml.Unmarshal(b, &cp); err != nil {
+		return cp, fmt.Errorf("could not copy config: %w", err)
+	}
+	return cp, nil
+}
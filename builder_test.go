@@ -0,0 +1,62 @@
+package lfm
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/jbvmio/lfm/plugin"
+	"github.com/jbvmio/lfm/queue"
+)
+
+// stopInput records whether it was stopped.
+type stopInput struct {
+	stopped bool
+	data    chan plugin.Event
+	errs    chan error
+}
+
+func (in *stopInput) Start() error                { return nil }
+func (in *stopInput) Stop() error                 { in.stopped = true; return nil }
+func (in *stopInput) Source() <-chan plugin.Event { return in.data }
+func (in *stopInput) Errors() <-chan error        { return in.errs }
+
+// stopOutput records whether it was stopped.
+type stopOutput struct {
+	stopped bool
+	data    chan plugin.Event
+	errs    chan error
+}
+
+func (out *stopOutput) Start() error                     { return nil }
+func (out *stopOutput) Stop() error                      { out.stopped = true; return nil }
+func (out *stopOutput) Destination() chan<- plugin.Event { return out.data }
+func (out *stopOutput) Errors() <-chan error             { return out.errs }
+
+func TestNewPipelineStopsPluginsOnError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lfm-builder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ordered := Stage{Ordering: `sideways`, Steps: []Step{{Workflow: Workflows{{`driver`: `json`, `method`: `filter`}}}}}
+	for _, x := range []struct {
+		name string
+		want string
+		cfg  Config
+	}{
+		{name: `unknown driver`, want: `unknown`, cfg: Config{Processors: []Stage{{Steps: []Step{{Workflow: Workflows{{`driver`: `unknown`}}}}}}}},
+		{name: `invalid ordering`, want: `sideways`, cfg: Config{Processors: []Stage{ordered}}},
+		{name: `queued`, want: `sideways`, cfg: Config{Queue: &queue.Config{Path: dir, Input: true}, Processors: []Stage{ordered}}},
+	} {
+		in, out := &stopInput{}, &stopOutput{}
+		_, err := NewPipeline(`test`, x.cfg, WithInputs(in), WithOutputs(out))
+		if err == nil || !strings.Contains(err.Error(), x.want) {
+			t.Fatalf("%s: expected an error building the pipeline, got %v", x.name, err)
+		}
+		if !in.stopped || !out.stopped {
+			t.Fatalf("%s: expected the input and output to be stopped, got input %v, output %v", x.name, in.stopped, out.stopped)
+		}
+	}
+}
@@ -3,14 +3,15 @@ package main
 import (
 	"context"
 	"fmt"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 
 	"github.com/jbvmio/lfm"
-	"github.com/jbvmio/lfm/internal/drivers"
-	"github.com/jbvmio/lfm/internal/plugins"
-	"github.com/jbvmio/lfm/pipeline"
+	"github.com/jbvmio/lfm/admin"
+	"github.com/jbvmio/lfm/metrics"
 	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 )
@@ -20,81 +21,65 @@ var (
 )
 
 func main() {
+	if len(os.Args) > 1 {
+		if cmd, ok := commands[os.Args[1]]; ok {
+			os.Exit(cmd(os.Args[2:]))
+		}
+	}
 	pf := pflag.NewFlagSet(`lfm`, pflag.ExitOnError)
 	cfgFile := pf.StringP("config", "c", "./config.yaml", "Path to config Yaml file.")
+	adminAddr := pf.StringP("admin", "a", "", "Address for the admin server exposing health, readiness and pipeline status, disabled if empty.")
+	watch := pf.DurationP("watch", "w", 5*time.Second, "Interval to check the config and all included and stage files for changes, disabled if 0. Send SIGHUP to reload at any time.")
+	drain := pf.DurationP("drain", "d", lfm.DefaultDrainTimeout, "Time allowed for in-flight events to complete when stopping.")
+	metricsAddr := pf.StringP("metrics", "m", "", "Address to expose Prometheus metrics at /metrics, disabled if empty.")
 	pf.Parse(os.Args[1:])
 
 	L := lfm.ConfigureLogger(`info`, os.Stdout)
 	defer L.Sync()
 	L.Info("Starting LFM ...", zap.String(`version`, buildTime), zap.String(`commit`, commitHash))
 
-	cfg, err := lfm.ConfigFromFile(*cfgFile)
+	lfm.DefaultDrainTimeout = *drain
+	cfg, files, err := lfm.LoadConfig(*cfgFile)
 	if err != nil {
 		L.Fatal("error parsing config", zap.Error(err))
 	}
-	inputs, err := plugins.LoadInputs(cfg)
-	if err != nil {
-		L.Fatal("error loading inputs", zap.Error(err))
-	}
-	outputs, err := plugins.LoadOutputs(cfg)
-	if err != nil {
-		L.Fatal("error loading outputs", zap.Error(err))
-	}
-	processors, err := drivers.LoadProcessors(cfg)
-	if err != nil {
-		L.Fatal("error loading processors", zap.Error(err))
-	}
-
-	// DEBUG:
-	for k := range processors {
-		//fmt.Println(k)
-		for a, x := range processors[k] {
-			//fmt.Println("STAGE:", a)
-			for b, y := range x {
-				//fmt.Println(" STEP:", b)
-				for c := range y {
-					//fmt.Println("  DRIVER:", c, ">", z)
-					L.Info("discovered drivers", zap.String("PIPELINE", k), zap.Int("STAGE", a), zap.Int("STEP", b), zap.Int("DRIVER", c))
-				}
-			}
-		}
-	}
 	ctx, cancel := context.WithCancel(context.Background())
-	var pipelines lfm.Pipelines
-	pipelines.UseLogger(L.Sugar())
-	for name, input := range inputs {
-		output, there := outputs[name]
-		if !there {
-			panic(`no output for ` + name)
-		}
-		S := L.With(zap.String(`pipeline`, name)).Sugar()
-		stages := processors[name]
-		p := pipeline.NewPipeline(ctx, S)
-		for n, steps := range stages {
-			//sl := l.With(zap.Int(`stage`, n))
-			SL := S.With(zap.Int(`stage`, n))
-			s := pipeline.NewStage(ctx, SL)
-			//s.InputFn = drivers.MakeDriversInitFunc(steps)
-			s.Processors = []pipeline.DataFunc{drivers.MakeDriversFunc(steps)}
-			//s.OutputFn = drivers.MakeDriversInitFunc(steps)
-			p.AddStages(&s)
-		}
-
-		pipelines.AddPipeline(lfm.Pipeline{
-			Name:    name,
-			Inputs:  input,
-			Outputs: output,
-			P:       p,
-			L:       S,
-		})
+	pipelines, err := lfm.New(cfg, lfm.WithContext(ctx), lfm.WithLogger(L))
+	if err != nil {
+		L.Fatal("error loading pipelines", zap.Error(err))
 	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
+	if *metricsAddr != "" {
+		L.Info("Starting Metrics Endpoint ...", zap.String(`address`, *metricsAddr))
+		go func() {
+			if err := metrics.Serve(*metricsAddr); err != nil {
+				L.Error("metrics endpoint stopped", zap.Error(err))
+			}
+		}()
+	}
+
 	L.Info("Starting Pipelines ...")
 	pipelines.Run()
 
+	var adminSrv *admin.Server
+	if *adminAddr != "" {
+		L.Info("Starting Admin Server ...", zap.String(`address`, *adminAddr))
+		adminSrv = admin.NewServer(*adminAddr, pipelines)
+		go func() {
+			if err := adminSrv.Start(); err != nil && err != http.ErrServerClosed {
+				L.Error("admin server stopped", zap.Error(err))
+			}
+		}()
+	}
+
+	hupChan := make(chan os.Signal, 1)
+	signal.Notify(hupChan, syscall.SIGHUP)
+	reloader := newReloader(ctx, L, *cfgFile, cfg, files, pipelines)
+	go reloader.run(*watch, hupChan)
+
 	go func(errs <-chan error) {
 		for e := range errs {
 			fmt.Printf("ERR: %v\n", e)
@@ -103,9 +88,16 @@ func main() {
 
 	<-sigChan
 
+	if adminSrv != nil {
+		adminSrv.Stop(context.Background())
+	}
+	// stop reloading before stopping, so no Pipelines are added once stopped.
+	reloader.stop()
 	L.Info("Stopping Pipelines ...")
 
-	pipelines.Stop()
+	if lost := pipelines.Stop(); lost > 0 {
+		L.Warn("Events lost while stopping", zap.Int(`lost`, lost), zap.Duration(`drain`, *drain))
+	}
 	cancel()
 
 	L.Info("Finished Syncing Loggers")
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"os"
+	"time"
+
+	"github.com/jbvmio/lfm"
+	"go.uber.org/zap"
+	"gopkg.in/yaml.v2"
+)
+
+// fingerprint identifies the parts of a Config which determine how a changed Pipeline is reloaded.
+type fingerprint struct {
+	plugins    string
+	stages     string
+	processors string
+}
+
+func newFingerprint(c lfm.Config) fingerprint {
+	type stageOptions struct {
+		Workers  int
+		Queue    int
+		Ordering string
+		OrderKey string
+	}
+	var stages []stageOptions
+	for _, s := range c.StageOrder() {
+		stages = append(stages, stageOptions{Workers: s.Workers, Queue: s.Queue, Ordering: s.Ordering, OrderKey: s.OrderKey})
+	}
+	return fingerprint{
+		plugins:    mustYAML(c.Sources, c.Destinations, c.DeadLetter, c.Queue),
+		stages:     mustYAML(stages),
+		processors: mustYAML(c.Processors),
+	}
+}
+
+func mustYAML(x ...interface{}) string {
+	b, err := yaml.Marshal(x)
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+type fileStamp struct {
+	modTime time.Time
+	size    int64
+}
+
+// reloader watches the config file and every file it includes or references, reloading the Pipelines which changed.
+// Pipelines with only processor changes have their Drivers swapped in place, other changes restart the Pipeline.
+type reloader struct {
+	path      string
+	ctx       context.Context
+	pipelines *lfm.Pipelines
+	configs   lfm.Configs
+	files     []string
+	stamps    map[string]fileStamp
+	stopChan  chan struct{}
+	done      chan struct{}
+	L         *zap.Logger
+}
+
+func newReloader(ctx context.Context, L *zap.Logger, path string, cfg lfm.Configs, files []string, pipelines *lfm.Pipelines) *reloader {
+	r := &reloader{
+		path:      path,
+		ctx:       ctx,
+		pipelines: pipelines,
+		configs:   cfg,
+		files:     files,
+		stopChan:  make(chan struct{}),
+		done:      make(chan struct{}),
+		L:         L,
+	}
+	r.stamps = stampFiles(files)
+	return r
+}
+
+// run reloads whenever a watched file changes, checking every interval, or a value is received on reload.
+// A zero interval disables watching files.
+func (r *reloader) run(interval time.Duration, reload <-chan os.Signal) {
+	defer close(r.done)
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-r.stopChan:
+			return
+		case <-reload:
+			r.L.Info("Received Reload Signal ...")
+			r.reload()
+		case <-tick:
+			if r.changed() {
+				r.L.Info("Detected Config Change ...")
+				r.reload()
+			}
+		}
+	}
+}
+
+// stop stops the reloader, waiting for any reload in progress to complete.
+func (r *reloader) stop() {
+	close(r.stopChan)
+	<-r.done
+}
+
+// stampFiles returns the current stamps for the given files.
+func stampFiles(paths []string) map[string]fileStamp {
+	stamps := make(map[string]fileStamp)
+	for _, path := range paths {
+		var stamp fileStamp
+		if fi, err := os.Stat(path); err == nil {
+			stamp = fileStamp{modTime: fi.ModTime(), size: fi.Size()}
+		}
+		stamps[path] = stamp
+	}
+	return stamps
+}
+
+func (r *reloader) changed() bool {
+	for path, stamp := range stampFiles(r.files) {
+		if r.stamps[path] != stamp {
+			return true
+		}
+	}
+	return false
+}
+
+// reload parses the config file and applies any changes to the running Pipelines.
+func (r *reloader) reload() {
+	cfg, files, err := lfm.LoadConfig(r.path)
+	r.files, r.stamps = files, stampFiles(files)
+	if err != nil {
+		r.L.Error("error reloading config, keeping current config", zap.Error(err))
+		return
+	}
+	for name := range r.configs {
+		if _, there := cfg[name]; !there {
+			r.L.Info("Removing Pipeline ...", zap.String(`pipeline`, name))
+			r.pipelines.RemovePipeline(name)
+			delete(r.configs, name)
+		}
+	}
+	for name, c := range cfg {
+		old, there := r.configs[name]
+		if !there {
+			r.L.Info("Adding Pipeline ...", zap.String(`pipeline`, name))
+			if r.start(name, c) {
+				r.configs[name] = c
+			}
+			continue
+		}
+		was, now := newFingerprint(old), newFingerprint(c)
+		switch {
+		case was == now:
+			continue
+		case was.plugins == now.plugins && was.stages == now.stages:
+			r.L.Info("Swapping Pipeline Processors ...", zap.String(`pipeline`, name))
+			if r.swap(name, c) {
+				r.configs[name] = c
+			}
+		default:
+			r.L.Info("Restarting Pipeline ...", zap.String(`pipeline`, name))
+			if r.restart(name, old, c) {
+				r.configs[name] = c
+			}
+		}
+	}
+}
+
+// start builds and starts the named Pipeline.
+func (r *reloader) start(name string, c lfm.Config) bool {
+	p, err := lfm.NewPipeline(name, c, lfm.WithContext(r.ctx), lfm.WithLogger(r.L))
+	if err != nil {
+		r.L.Error("error building pipeline", zap.String(`pipeline`, name), zap.Error(err))
+		return false
+	}
+	r.pipelines.AddPipeline(p)
+	return true
+}
+
+// swap replaces the processors of the named Pipeline without restarting its Inputs or Outputs.
+func (r *reloader) swap(name string, c lfm.Config) bool {
+	stages, funcs, err := lfm.NewProcessors(name, c)
+	if err == nil {
+		if err = r.pipelines.SwapProcessors(name, c, stages, funcs); err != nil {
+			lfm.CloseDrivers(stages)
+		}
+	}
+	if err != nil {
+		r.L.Error("error swapping processors, keeping current processors", zap.String(`pipeline`, name), zap.Error(err))
+		return false
+	}
+	return true
+}
+
+// restart stops the named Pipeline and starts it using the new Config.
+// If the new Config cannot be started, the Pipeline is started again using the old Config.
+func (r *reloader) restart(name string, old, c lfm.Config) bool {
+	stages, _, err := lfm.NewProcessors(name, c)
+	lfm.CloseDrivers(stages)
+	if err != nil {
+		r.L.Error("error reloading pipeline, keeping current pipeline", zap.String(`pipeline`, name), zap.Error(err))
+		return false
+	}
+	r.pipelines.RemovePipeline(name)
+	if r.start(name, c) {
+		return true
+	}
+	r.L.Warn("Restoring Previous Pipeline ...", zap.String(`pipeline`, name))
+	if !r.start(name, old) {
+		delete(r.configs, name)
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/jbvmio/lfm"
+	"github.com/jbvmio/lfm/driver"
+	jsondriver "github.com/jbvmio/lfm/driver/json"
+	"github.com/jbvmio/lfm/internal/drivers"
+	"github.com/spf13/pflag"
+)
+
+func init() {
+	commands[`test`] = testCmd
+}
+
+// testCmd runs sample lines through the stages of a pipeline without its inputs or outputs,
+// printing the payload, tags, vars and fields after every driver.
+func testCmd(args []string) int {
+	pf := pflag.NewFlagSet(`lfm test`, pflag.ExitOnError)
+	pf.Usage = func() {
+		fmt.Fprintf(os.Stderr, "Usage: lfm test -p PIPELINE [-c CONFIG] [-f FILE] [-m KEY=VALUE ...] [LINE ...]\n\n")
+		pf.PrintDefaults()
+	}
+	cfgFile := pf.StringP("config", "c", "./config.yaml", "Path to config Yaml file.")
+	name := pf.StringP("pipeline", "p", "", "Name of the pipeline to test.")
+	file := pf.StringP("file", "f", "", "File containing sample lines, use - for stdin. Lines may also be given as arguments.")
+	meta := pf.StringToStringP("meta", "m", nil, "Event metadata provided to drivers as tmpVars, ie: kafka.topic=logs.")
+	pf.Parse(args)
+
+	cfg, err := lfm.ConfigFromFile(*cfgFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error parsing config: %v\n", err)
+		return 1
+	}
+	c, ok := cfg[*name]
+	if !ok {
+		var names []string
+		for n := range cfg {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		fmt.Fprintf(os.Stderr, "no pipeline named %q, available: %s\n", *name, strings.Join(names, `, `))
+		return 1
+	}
+	lines := pf.Args()
+	if *file != "" {
+		more, err := readLines(*file)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error reading %s: %v\n", *file, err)
+			return 1
+		}
+		lines = append(lines, more...)
+	}
+	if len(lines) < 1 {
+		fmt.Fprintln(os.Stderr, "no sample lines given")
+		return 1
+	}
+	stages, _, err := lfm.NewProcessors(*name, c)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "error loading processors: %v\n", err)
+		return 1
+	}
+	defer lfm.CloseDrivers(stages)
+	names := driverNames(c)
+	for n, line := range lines {
+		fmt.Printf("event %d: %s\n", n+1, line)
+		traceEvent([]byte(line), *meta, stages, names)
+		fmt.Println()
+	}
+	return 0
+}
+
+// traceEvent passes the data through each Stage as the pipeline would, printing the result of each Driver.
+func traceEvent(data []byte, meta map[string]string, stages [][][]driver.Driver, names [][][]string) {
+	var traced []string
+	tracer := func(format string, args ...interface{}) {
+		traced = append(traced, fmt.Sprintf(format, args...))
+	}
+	for stage, steps := range stages {
+		if len(steps) < 1 {
+			continue
+		}
+		P := driver.NewTracedPayload(tracer)
+		drivers.AddMetadata(P, meta)
+		var last string
+		out, err := drivers.ProcessSteps(P, data, steps, func(step, n int, result driver.Result) {
+			last = fmt.Sprintf("stage %d step %d driver %d", stage, step, n)
+			fmt.Printf("    stage %d step %d driver %d (%s):\n", stage, step, n, names[stage][step][n])
+			for _, t := range traced {
+				fmt.Printf("      %s\n", t)
+			}
+			traced = traced[:0]
+			fmt.Printf("      result: %s\n", result.Bytes())
+			fmt.Printf("      tags:   %s\n", kvJSON(P.KV(driver.TagsLabel)))
+			fmt.Printf("      vars:   %s\n", kvJSON(P.KV(driver.VarsLabel)))
+			fmt.Printf("      fields: %s\n", kvJSON(P.KV(jsondriver.FieldsLabel)))
+		})
+		P.Discard()
+		switch {
+		case err != nil:
+			fmt.Printf("  failed at stage %d: %v\n", stage, err)
+			return
+		case out == nil:
+			fmt.Printf("  dropped at %s: the driver returned no data\n", last)
+			return
+		}
+		fmt.Printf("  stage %d output: %s\n", stage, out)
+		data = out
+	}
+	fmt.Printf("  output: %s\n", data)
+}
+
+// driverNames returns the driver name of each workflow by stage and step, in processing order.
+func driverNames(c lfm.Config) [][][]string {
+	var names [][][]string
+	for _, s := range c.StageOrder() {
+		steps := make([]lfm.Step, len(s.Steps))
+		copy(steps, s.Steps)
+		sort.SliceStable(steps, func(i, j int) bool {
+			return steps[i].Step < steps[j].Step
+		})
+		var stage [][]string
+		for _, st := range steps {
+			var step []string
+			for _, wf := range st.Workflow {
+				step = append(step, fmt.Sprint(wf[`driver`]))
+			}
+			stage = append(stage, step)
+		}
+		names = append(names, stage)
+	}
+	return names
+}
+
+func kvJSON(kv driver.KVStore) string {
+	b, err := json.Marshal(kv.All())
+	if err != nil {
+		return err.Error()
+	}
+	return string(b)
+}
+
+func readLines(path string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if path != `-` {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+	var lines []string
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jbvmio/lfm/validate"
+	"github.com/spf13/pflag"
+)
+
+// commands are the available subcommands, run with the remaining arguments and returning the exit code.
+var commands = map[string]func(args []string) int{
+	`validate`: validateCmd,
+}
+
+// validateCmd checks a config file and every file it includes or references, printing all errors found.
+func validateCmd(args []string) int {
+	pf := pflag.NewFlagSet(`lfm validate`, pflag.ExitOnError)
+	cfgFile := pf.StringP("config", "c", "./config.yaml", "Path to config Yaml file.")
+	pf.Parse(args)
+	errs := validate.File(*cfgFile)
+	for _, err := range errs {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	if len(errs) > 0 {
+		fmt.Fprintf(os.Stderr, "%s: %d error(s) found\n", *cfgFile, len(errs))
+		return 1
+	}
+	fmt.Printf("%s: OK\n", *cfgFile)
+	return 0
+}
@@ -3,65 +3,141 @@ package lfm
 import (
 	"fmt"
 	"io/ioutil"
+	"sort"
 	"strings"
 
+	"github.com/jbvmio/lfm/internal/loader"
+	"github.com/jbvmio/lfm/pipeline"
+	"github.com/jbvmio/lfm/queue"
+	"github.com/tidwall/gjson"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
 	"gopkg.in/yaml.v2"
+	yaml3 "gopkg.in/yaml.v3"
 )
 
 // Configs contain multiple configurations.
 type Configs map[string]Config
 
 // Config details for lfm.
+// DeadLetter is an optional destination receiving Events which failed processing or delivery.
+// Queue optionally persists Events on disk in front of each destination.
 type Config struct {
 	Sources      []map[string]interface{} `yaml:"sources"`
 	Destinations []map[string]interface{} `yaml:"destinations"`
+	DeadLetter   map[string]interface{}   `yaml:"deadLetter"`
+	Queue        *queue.Config            `yaml:"queue"`
 	Processors   []Stage                  `yaml:"processors"`
 }
 
 // Stage holds the stage order and Step definitions.
+// Workers and Queue limit the number of concurrent workers and the depth of the stage input queue.
+// Ordering is one of none, ordered or key. The key ordering uses the Event metadata field named by OrderKey if present,
+// such as kafka.partition for the Kafka Input, otherwise the JSON path in OrderKey, ie: tags.app.
 type Stage struct {
 	Stage     int    `yaml:"stage"`
 	StageFile string `yaml:"stageFile"`
+	Workers   int    `yaml:"workers"`
+	Queue     int    `yaml:"queue"`
+	Ordering  string `yaml:"ordering"`
+	OrderKey  string `yaml:"orderKey"`
 	Steps     []Step `yaml:"steps"`
 }
 
 // Step holds the processing instructions.
+// Each Workflow configures a Driver, run in the order listed.
 type Step struct {
-	Step     int                    `yaml:"step"`
-	Workflow map[string]interface{} `yaml:"workflow"`
-	//Workflow []map[string]interface{} `yaml:"workflow"`
+	Step     int       `yaml:"step"`
+	Workflow Workflows `yaml:"workflow"`
 }
 
-// ConfigFromFile loads and returns Configs from a local file.
-func ConfigFromFile(path string) (cfgs Configs, err error) {
-	b, err := ioutil.ReadFile(path)
-	if err != nil {
-		return Configs{}, err
+// Workflows holds the Driver configurations for a Step.
+// It may be given in the configuration as a single map or as a list of maps.
+type Workflows []map[string]interface{}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface, accepting either a single map or a list of maps.
+func (w *Workflows) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list []map[string]interface{}
+	if err := unmarshal(&list); err == nil {
+		*w = list
+		return nil
 	}
-	err = yaml.Unmarshal(b, &cfgs)
-	for k, v := range cfgs {
-		for i := 0; i < len(v.Processors); i++ {
-			err = v.Processors[i].stepsFromFile()
-			if err != nil {
-				err = fmt.Errorf("invalid stagefile for pipeline %s stage %d: %w", k, v.Processors[i].Stage, err)
-				return
-			}
-		}
+	var single map[string]interface{}
+	if err := unmarshal(&single); err != nil {
+		return fmt.Errorf("workflow must be a map or list of maps: %w", err)
 	}
-	return
+	*w = Workflows{single}
+	return nil
 }
 
-func (s *Stage) stepsFromFile() error {
-	if s.StageFile == "" {
-		return nil
+// ConfigFromFile loads and returns Configs from a local file. See LoadConfig.
+func ConfigFromFile(path string) (Configs, error) {
+	cfgs, _, err := LoadConfig(path)
+	return cfgs, err
+}
+
+// LoadConfig loads Configs from a local file, returning them with the paths of every file read.
+// The file may include other config files and define stage and step templates for its pipelines.
+// References to ${ENV}, ${ENV:-default} and ${file:path} are expanded within all values, and relative
+// include and stageFile paths are resolved against the directory of the file containing them.
+// The paths are returned even when loading fails, allowing them to be watched for a fix.
+func LoadConfig(path string) (Configs, []string, error) {
+	doc, errs := loader.Load(path)
+	if len(errs) > 0 {
+		msgs := make([]string, len(errs))
+		for n, e := range errs {
+			msgs[n] = e.Error()
+		}
+		return Configs{}, doc.Files, fmt.Errorf("invalid config: %s", strings.Join(msgs, "; "))
+	}
+	cfgs := make(Configs, len(doc.Root.Content)/2)
+	for i := 0; i+1 < len(doc.Root.Content); i += 2 {
+		name := doc.Root.Content[i].Value
+		b, err := yaml3.Marshal(doc.Root.Content[i+1])
+		if err != nil {
+			return Configs{}, doc.Files, fmt.Errorf("invalid config for pipeline %s: %w", name, err)
+		}
+		var c Config
+		if err := yaml.Unmarshal(b, &c); err != nil {
+			return Configs{}, doc.Files, fmt.Errorf("invalid config for pipeline %s: %w", name, err)
+		}
+		cfgs[name] = c
 	}
-	b, err := ioutil.ReadFile(s.StageFile)
+	return cfgs, doc.Files, nil
+}
+
+// StageOrder returns the Processors sorted by their stage number.
+func (c Config) StageOrder() []Stage {
+	stages := make([]Stage, len(c.Processors))
+	copy(stages, c.Processors)
+	sort.SliceStable(stages, func(i, j int) bool {
+		return stages[i].Stage < stages[j].Stage
+	})
+	return stages
+}
+
+// ConfigureStage applies the Stage options to the given pipeline Stage.
+func (s Stage) ConfigureStage(ps *pipeline.Stage) error {
+	ordering, err := pipeline.OrderingFromString(s.Ordering)
 	if err != nil {
-		return err
+		return fmt.Errorf("stage %d: %w", s.Stage, err)
+	}
+	if ordering == pipeline.OrderKey {
+		if s.OrderKey == "" {
+			return fmt.Errorf("stage %d: missing orderKey for key ordering", s.Stage)
+		}
+		path := s.OrderKey
+		ps.OrderKey = func(d pipeline.Data) string {
+			if v, ok := pipeline.Metadata(d)[path]; ok {
+				return v
+			}
+			return gjson.GetBytes(d.Bytes(), path).String()
+		}
 	}
-	return yaml.Unmarshal(b, &s.Steps)
+	ps.Workers = s.Workers
+	ps.QueueSize = s.Queue
+	ps.Ordering = ordering
+	return nil
 }
 
 func loadFile(path string) ([]byte, error) {
@@ -0,0 +1,147 @@
+package lfm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/jbvmio/lfm/driver"
+	"github.com/jbvmio/lfm/metrics"
+	"github.com/jbvmio/lfm/pipeline"
+	"github.com/jbvmio/lfm/plugin"
+)
+
+// deadLetterTimeout limits how long sending a failed Event to the DeadLetter Output may block.
+const deadLetterTimeout = 10 * time.Second
+
+// Dead letter errors.
+var (
+	ErrDeadLetterTimeout = errors.New("timed out sending to dead letter output")
+	ErrDeadLetterStopped = errors.New("pipeline stopped before sending to dead letter output")
+)
+
+// DeadLetter is the record sent to the DeadLetter Output of a Pipeline when an Event fails processing or delivery.
+// Stage, Step and Driver are the positions of the failing Driver and are omitted for Output failures.
+type DeadLetter struct {
+	Pipeline  string    `json:"pipeline"`
+	Stage     *int      `json:"stage,omitempty"`
+	Step      *int      `json:"step,omitempty"`
+	Driver    *int      `json:"driver,omitempty"`
+	Output    *int      `json:"output,omitempty"`
+	Error     string    `json:"error"`
+	Timestamp time.Time `json:"timestamp"`
+	Payload   string    `json:"payload"`
+}
+
+// OutputError records an error returned by an Output when delivering an Event.
+type OutputError struct {
+	Output int
+	Err    error
+}
+
+func (e *OutputError) Error() string {
+	return fmt.Sprintf("output %d: %v", e.Output, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *OutputError) Unwrap() error {
+	return e.Err
+}
+
+// outputAck wraps any error acknowledged by the Output at position n as an OutputError.
+func outputAck(n int, ack func(error)) func(error) {
+	return func(err error) {
+		if err != nil {
+			err = &OutputError{Output: n, Err: err}
+		}
+		ack(err)
+	}
+}
+
+// newDeadLetter creates a DeadLetter for the original payload and the error that caused it to fail.
+func newDeadLetter(name string, payload []byte, err error) DeadLetter {
+	dl := DeadLetter{
+		Pipeline:  name,
+		Error:     err.Error(),
+		Timestamp: time.Now(),
+		Payload:   string(payload),
+	}
+	var stageErr *pipeline.StageError
+	if errors.As(err, &stageErr) {
+		dl.Stage = &stageErr.Stage
+	}
+	var driverErr *driver.Error
+	if errors.As(err, &driverErr) {
+		dl.Step = &driverErr.Step
+		dl.Driver = &driverErr.Driver
+	}
+	var outputErr *OutputError
+	if errors.As(err, &outputErr) {
+		dl.Output = &outputErr.Output
+	}
+	return dl
+}
+
+// ackFunc returns the func used to acknowledge an Event received from an Input.
+// Failed Events are sent to the DeadLetter Output, if defined, before acknowledging the Input. Events delivered
+// to the DeadLetter Output are acknowledged without error, otherwise the Input receives the original error
+// together with the reason the dead letter failed.
+// Acknowledging the Input completes the Event, removing it from the in-flight count.
+func (p *Pipeline) ackFunc(event plugin.Event) func(error) {
+	done := func(err error) {
+		event.Ack(err)
+		atomic.AddInt64(&p.inflight, -1)
+	}
+	return func(err error) {
+		if err == nil {
+			metrics.PipelineEvents.WithLabelValues(p.Name, metrics.ResultPassed).Inc()
+			done(nil)
+			return
+		}
+		metrics.PipelineEvents.WithLabelValues(p.Name, metrics.ResultFailed).Inc()
+		if p.DeadLetter == nil {
+			done(err)
+			return
+		}
+		p.sendDeadLetter(event.Data, err, func(errd error) {
+			if errd != nil {
+				done(fmt.Errorf("%w: %v", err, errd))
+				return
+			}
+			done(nil)
+		})
+	}
+}
+
+// sendDeadLetter sends the failed payload to the DeadLetter Output, calling done once acknowledged.
+// If the DeadLetter Output does not accept the payload before the deadLetterTimeout or the Pipeline is stopped,
+// done is called with the reason instead.
+func (p *Pipeline) sendDeadLetter(payload []byte, err error, done func(error)) {
+	b, errd := json.Marshal(newDeadLetter(p.Name, payload, err))
+	if errd != nil {
+		p.L.Errorf("LFM Pipeline could not create dead letter: %v", errd)
+		done(fmt.Errorf("could not create dead letter: %w", errd))
+		return
+	}
+	p.L.Debugf("LFM Pipeline Sending Data to Dead Letter Output")
+	event := plugin.NewEvent(b, func(errd error) {
+		if errd != nil {
+			p.L.Errorf("LFM Pipeline could not deliver dead letter: %v", errd)
+			errd = fmt.Errorf("could not deliver dead letter: %w", errd)
+		}
+		done(errd)
+	})
+	timer := time.NewTimer(deadLetterTimeout)
+	defer timer.Stop()
+	select {
+	case p.DeadLetter.Destination() <- event:
+		metrics.DeadLetters.WithLabelValues(p.Name).Inc()
+	case <-timer.C:
+		p.L.Errorf("LFM Pipeline %v", ErrDeadLetterTimeout)
+		done(ErrDeadLetterTimeout)
+	case <-p.ctx.Done():
+		done(ErrDeadLetterStopped)
+	}
+}
@@ -0,0 +1,355 @@
+package lfm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jbvmio/lfm/metrics"
+	"github.com/jbvmio/lfm/plugin"
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
+)
+
+// Delivery Policies:
+const (
+	PolicyBlock      = `block`
+	PolicyDrop       = `drop`
+	PolicyDeadLetter = `deadLetter`
+)
+
+// Delivery defaults.
+const (
+	defaultDeliveryTimeout  = 30 * time.Second
+	defaultDeliveryRetries  = 3
+	defaultMinBackoff       = time.Second
+	defaultMaxBackoff       = 30 * time.Second
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+	defaultDeliveryBuffer   = 1000
+	defaultDeliveryInFlight = 100
+)
+
+// Delivery errors.
+var (
+	ErrDeliveryTimeout = errors.New("timed out delivering to output")
+	ErrCircuitOpen     = errors.New("output circuit breaker is open")
+	ErrDeliveryFull    = errors.New("output delivery queue is full")
+)
+
+// Delivery contains the options used when delivering Events to an Output.
+// The Policy determines what happens to Events when the Output queue is full, the circuit breaker is open
+// or all retries have failed: block waits for the Output and keeps retrying the Event at MaxBackoff until
+// delivered, holding back the Input, drop discards the Event and deadLetter sends it to the Pipeline
+// DeadLetter Output.
+// Up to InFlight Events are sent before earlier Events are acknowledged, so Events which are retried may be
+// delivered after later Events. An InFlight of 1 preserves the order of Events delivered to the Output.
+// The Timeout bounds how long the Output may take to accept an Event. Once accepted, the Event is only retried
+// if the Output acknowledges it with an error, so Outputs must acknowledge each Event they accept in bounded time.
+type Delivery struct {
+	Timeout          time.Duration `yaml:"timeout"`
+	Retries          int           `yaml:"retries"`
+	MinBackoff       time.Duration `yaml:"minBackoff"`
+	MaxBackoff       time.Duration `yaml:"maxBackoff"`
+	BreakerThreshold int           `yaml:"breakerThreshold"`
+	BreakerCooldown  time.Duration `yaml:"breakerCooldown"`
+	Policy           string        `yaml:"policy"`
+	Buffer           int           `yaml:"buffer"`
+	InFlight         int           `yaml:"inFlight"`
+}
+
+// DeliveryFromConfig returns the Delivery options defined under the delivery key of a destination.
+func DeliveryFromConfig(details map[string]interface{}) (Delivery, error) {
+	var d Delivery
+	if x, there := details[`delivery`]; there {
+		b, err := yaml.Marshal(x)
+		if err != nil {
+			return d, fmt.Errorf("invalid delivery configuration: %w", err)
+		}
+		if err := yaml.Unmarshal(b, &d); err != nil {
+			return d, fmt.Errorf("invalid delivery configuration: %w", err)
+		}
+	}
+	return d, d.validate()
+}
+
+// Deliveries returns the Delivery options for each of the configured Destinations.
+func (c Config) Deliveries() ([]Delivery, error) {
+	deliveries := make([]Delivery, len(c.Destinations))
+	for i, dest := range c.Destinations {
+		d, err := DeliveryFromConfig(dest)
+		if err != nil {
+			return nil, fmt.Errorf("destination %d: %w", i, err)
+		}
+		deliveries[i] = d
+	}
+	return deliveries, nil
+}
+
+// validate assigns any defaults and returns an error if the Delivery is invalid.
+func (d *Delivery) validate() error {
+	if d.Timeout == 0 {
+		d.Timeout = defaultDeliveryTimeout
+	}
+	if d.Retries == 0 {
+		d.Retries = defaultDeliveryRetries
+	}
+	if d.MinBackoff == 0 {
+		d.MinBackoff = defaultMinBackoff
+	}
+	if d.MaxBackoff == 0 {
+		d.MaxBackoff = defaultMaxBackoff
+	}
+	if d.BreakerThreshold == 0 {
+		d.BreakerThreshold = defaultBreakerThreshold
+	}
+	if d.BreakerCooldown == 0 {
+		d.BreakerCooldown = defaultBreakerCooldown
+	}
+	if d.Buffer == 0 {
+		d.Buffer = defaultDeliveryBuffer
+	}
+	if d.InFlight == 0 {
+		d.InFlight = defaultDeliveryInFlight
+	}
+	switch d.Policy {
+	case "":
+		d.Policy = PolicyBlock
+	case PolicyBlock, PolicyDrop, PolicyDeadLetter:
+	default:
+		return fmt.Errorf("invalid delivery policy: %s", d.Policy)
+	}
+	return nil
+}
+
+type deliveryItem struct {
+	data []byte
+	ack  func(error)
+}
+
+// deliverer delivers Events to a single Output, isolating it from any other Outputs in the Pipeline.
+type deliverer struct {
+	id        int
+	out       plugin.Output
+	opts      Delivery
+	queue     chan deliveryItem
+	slots     chan struct{}
+	lock      sync.Mutex
+	failures  int
+	openUntil time.Time
+	p         *Pipeline
+	metrics   deliveryMetrics
+}
+
+// deliveryMetrics holds the metrics for a single Output.
+type deliveryMetrics struct {
+	delivered prometheus.Counter
+	failed    prometheus.Counter
+	dropped   prometheus.Counter
+	retries   prometheus.Counter
+	depth     prometheus.Gauge
+	breaker   prometheus.Gauge
+	latency   prometheus.Observer
+}
+
+func newDeliveryMetrics(pipeline string, id int) deliveryMetrics {
+	output := strconv.Itoa(id)
+	return deliveryMetrics{
+		delivered: metrics.OutputEvents.WithLabelValues(pipeline, output, metrics.ResultDelivered),
+		failed:    metrics.OutputEvents.WithLabelValues(pipeline, output, metrics.ResultFailed),
+		dropped:   metrics.OutputEvents.WithLabelValues(pipeline, output, metrics.ResultDropped),
+		retries:   metrics.OutputRetries.WithLabelValues(pipeline, output),
+		depth:     metrics.OutputQueueDepth.WithLabelValues(pipeline, output),
+		breaker:   metrics.OutputBreakerOpen.WithLabelValues(pipeline, output),
+		latency:   metrics.OutputLatency.WithLabelValues(pipeline, output),
+	}
+}
+
+func newDeliverer(p *Pipeline, id int, out plugin.Output, opts Delivery) *deliverer {
+	opts.validate()
+	return &deliverer{
+		id:      id,
+		out:     out,
+		opts:    opts,
+		queue:   make(chan deliveryItem, opts.Buffer),
+		slots:   make(chan struct{}, opts.InFlight),
+		p:       p,
+		metrics: newDeliveryMetrics(p.Name, id),
+	}
+}
+
+// enqueue adds the data to the Output queue, applying the Delivery Policy if the queue is full.
+func (d *deliverer) enqueue(ctx context.Context, item deliveryItem) {
+	if d.opts.Policy != PolicyBlock {
+		select {
+		case d.queue <- item:
+		default:
+			d.reject(item, ErrDeliveryFull)
+		}
+	} else {
+		select {
+		case d.queue <- item:
+		case <-ctx.Done():
+		}
+	}
+	d.metrics.depth.Set(float64(len(d.queue)))
+}
+
+// run sends queued Events to the Output in order, allowing up to InFlight Events awaiting acknowledgement.
+func (d *deliverer) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case item := <-d.queue:
+			d.metrics.depth.Set(float64(len(d.queue)))
+			select {
+			case d.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			d.attempt(ctx, item, 0)
+		}
+	}
+}
+
+// attempt sends the Event to the Output and waits for its acknowledgement in the background.
+// The Timeout only applies until the Output accepts the Event, so an accepted Event is never sent again
+// while the Output may still deliver it.
+func (d *deliverer) attempt(ctx context.Context, item deliveryItem, n int) {
+	if !d.allow(ctx) {
+		d.release(item, ErrCircuitOpen, true)
+		return
+	}
+	start := time.Now()
+	timer := time.NewTimer(d.opts.Timeout)
+	defer timer.Stop()
+	done := make(chan error, 1)
+	select {
+	case d.out.Destination() <- plugin.NewEvent(item.data, func(err error) { done <- err }):
+	case <-timer.C:
+		d.retry(ctx, item, n, ErrDeliveryTimeout)
+		return
+	case <-ctx.Done():
+		return
+	}
+	go func() {
+		select {
+		case err := <-done:
+			if err != nil {
+				d.retry(ctx, item, n, err)
+				return
+			}
+			d.metrics.latency.Observe(metrics.Since(start))
+			d.success()
+			d.release(item, nil, false)
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// retry attempts delivery again after a backoff, until all retries have been used.
+// The block Policy keeps retrying once all retries have been used.
+func (d *deliverer) retry(ctx context.Context, item deliveryItem, n int, err error) {
+	d.failure()
+	if n >= d.opts.Retries {
+		if d.opts.Policy != PolicyBlock {
+			d.release(item, err, true)
+			return
+		}
+		if n == d.opts.Retries {
+			d.p.reportErr(&OutputError{Output: d.id, Err: fmt.Errorf("retrying until delivered: %w", err)})
+		}
+	}
+	backoff := d.opts.MinBackoff
+	for i := 0; i < n && backoff < d.opts.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.opts.MaxBackoff {
+		backoff = d.opts.MaxBackoff
+	}
+	d.p.L.Debugf("LFM Pipeline Retrying Output %d in %v: %v", d.id, backoff, err)
+	d.metrics.retries.Inc()
+	go func() {
+		select {
+		case <-time.After(backoff):
+			d.attempt(ctx, item, n+1)
+		case <-ctx.Done():
+		}
+	}()
+}
+
+// release frees the in-flight slot used by the Event and acknowledges it.
+func (d *deliverer) release(item deliveryItem, err error, failed bool) {
+	<-d.slots
+	if failed {
+		d.reject(item, err)
+		return
+	}
+	d.metrics.delivered.Inc()
+	item.ack(nil)
+}
+
+// reject applies the Delivery Policy to an Event which could not be delivered.
+func (d *deliverer) reject(item deliveryItem, err error) {
+	d.p.reportErr(&OutputError{Output: d.id, Err: err})
+	switch d.opts.Policy {
+	case PolicyDrop:
+		d.metrics.dropped.Inc()
+		item.ack(nil)
+	default:
+		d.metrics.failed.Inc()
+		item.ack(err)
+	}
+}
+
+// allow returns true if the circuit breaker is closed or ready to retry.
+// The block Policy waits for the breaker to allow a retry.
+func (d *deliverer) allow(ctx context.Context) bool {
+	for {
+		d.lock.Lock()
+		wait := time.Until(d.openUntil)
+		d.lock.Unlock()
+		if wait <= 0 {
+			return true
+		}
+		if d.opts.Policy != PolicyBlock {
+			return false
+		}
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+func (d *deliverer) success() {
+	d.lock.Lock()
+	d.failures = 0
+	d.lock.Unlock()
+	d.metrics.breaker.Set(0)
+}
+
+func (d *deliverer) failure() {
+	d.lock.Lock()
+	d.failures++
+	if d.failures >= d.opts.BreakerThreshold {
+		if time.Now().After(d.openUntil) {
+			d.p.L.Warnf("LFM Pipeline Output %d circuit breaker opened after %d failures", d.id, d.failures)
+		}
+		d.openUntil = time.Now().Add(d.opts.BreakerCooldown)
+		d.metrics.breaker.Set(1)
+	}
+	d.lock.Unlock()
+}
+
+// breakerOpen returns true if the circuit breaker is currently open.
+func (d *deliverer) breakerOpen() bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return time.Now().Before(d.openUntil)
+}
@@ -0,0 +1,133 @@
+package lfm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jbvmio/lfm/log"
+	"github.com/jbvmio/lfm/plugin"
+)
+
+// failingOutput fails to deliver a number of Events before delivering the rest.
+type failingOutput struct {
+	fails int
+	data  chan plugin.Event
+	errs  chan error
+}
+
+func newFailingOutput(fails int) *failingOutput {
+	out := &failingOutput{fails: fails, data: make(chan plugin.Event), errs: make(chan error)}
+	go func() {
+		for e := range out.data {
+			if out.fails > 0 {
+				out.fails--
+				e.Ack(errors.New("delivery failed"))
+				continue
+			}
+			e.Ack(nil)
+		}
+	}()
+	return out
+}
+
+func (out *failingOutput) Start() error                     { return nil }
+func (out *failingOutput) Stop() error                      { return nil }
+func (out *failingOutput) Destination() chan<- plugin.Event { return out.data }
+func (out *failingOutput) Errors() <-chan error             { return out.errs }
+
+func deliver(t *testing.T, policy string, fails int) error {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	p := &Pipeline{Name: t.Name(), L: log.NewNoop(), Errs: make(chan error, 10)}
+	d := newDeliverer(p, 0, newFailingOutput(fails), Delivery{
+		Retries:          2,
+		MinBackoff:       time.Millisecond,
+		MaxBackoff:       5 * time.Millisecond,
+		BreakerThreshold: 100,
+		Policy:           policy,
+	})
+	go d.run(ctx)
+	acked := make(chan error, 1)
+	d.enqueue(ctx, deliveryItem{data: []byte(`{}`), ack: func(err error) { acked <- err }})
+	select {
+	case err := <-acked:
+		return err
+	case <-time.After(time.Second):
+		t.Fatal("event was not acknowledged")
+	}
+	return nil
+}
+
+func TestDeliveryBlockRetriesUntilDelivered(t *testing.T) {
+	if err := deliver(t, PolicyBlock, 10); err != nil {
+		t.Fatalf("expected the event to be delivered, got %v", err)
+	}
+}
+
+func TestDeliveryDeadLetterAfterRetries(t *testing.T) {
+	if err := deliver(t, PolicyDeadLetter, 10); err == nil {
+		t.Fatal("expected the event to fail after all retries")
+	}
+	if err := deliver(t, PolicyDeadLetter, 2); err != nil {
+		t.Fatalf("expected the event to be delivered within the retries, got %v", err)
+	}
+}
+
+// slowOutput acknowledges each Event it accepts after a delay.
+type slowOutput struct {
+	delay     time.Duration
+	data      chan plugin.Event
+	delivered chan []byte
+	errs      chan error
+}
+
+func (out *slowOutput) Start() error {
+	go func() {
+		for e := range out.data {
+			time.Sleep(out.delay)
+			out.delivered <- e.Data
+			e.Ack(nil)
+		}
+	}()
+	return nil
+}
+
+func (out *slowOutput) Stop() error                      { return nil }
+func (out *slowOutput) Destination() chan<- plugin.Event { return out.data }
+func (out *slowOutput) Errors() <-chan error             { return out.errs }
+
+func TestDeliveryTimeoutOnlyBeforeAccepted(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	out := &slowOutput{delay: 50 * time.Millisecond, data: make(chan plugin.Event), delivered: make(chan []byte, 10), errs: make(chan error)}
+	out.Start()
+	p := &Pipeline{Name: t.Name(), L: log.NewNoop(), Errs: make(chan error, 10)}
+	d := newDeliverer(p, 0, out, Delivery{
+		Timeout:    10 * time.Millisecond,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+		InFlight:   1,
+	})
+	go d.run(ctx)
+	acked := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		d.enqueue(ctx, deliveryItem{data: []byte{byte('a' + i)}, ack: func(err error) { acked <- err }})
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-acked:
+			if err != nil {
+				t.Fatalf("expected the event to be delivered, got %v", err)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("event was not acknowledged")
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := len(out.delivered); got != 2 {
+		t.Fatalf("expected each event to be delivered once, got %d deliveries", got)
+	}
+}
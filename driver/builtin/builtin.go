@@ -0,0 +1,12 @@
+// Package builtin registers all Drivers provided with lfm.
+//
+// Import it for its side effects:
+//
+//	import _ "github.com/jbvmio/lfm/driver/builtin"
+package builtin
+
+import (
+	// Built-in Drivers:
+	_ "github.com/jbvmio/lfm/driver/exec"
+	_ "github.com/jbvmio/lfm/driver/json"
+)
@@ -12,20 +12,14 @@ type Config interface {
 }
 
 // FromConfig attempts to Generate the appropriate Driver based on the details entered.
+// Drivers are resolved by name using the driver registry.
 func FromConfig(details map[string]interface{}) (driver.Driver, error) {
-	cfg, err := GetConfig(details)
-	if err != nil {
-		return nil, err
-	}
-	switch C := cfg.(type) {
-	case *json.Config:
-		return json.NewDriver(C)
-	default:
-		return nil, errors.Errorf("missing or invalid driver type: %T", cfg)
-	}
+	return driver.New(details)
 }
 
 // GetConfig attempts to Generate a Config based on the details entered.
+//
+// Deprecated: Only the json driver is supported, use driver.New to create any registered Driver.
 func GetConfig(details map[string]interface{}) (Config, error) {
 	var cfg Config
 	d, there := details[`driver`].(string)
@@ -1,6 +1,7 @@
 package driver
 
 import (
+	"fmt"
 	"sync"
 )
 
@@ -15,6 +16,22 @@ type Driver interface {
 	Process(Payload)
 }
 
+// Error records an error returned by a Driver within a processing Step.
+type Error struct {
+	Step   int
+	Driver int
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("step %d driver %d: %v", e.Step, e.Driver, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
 // Payload is passed through a Driver and stores and retrieves data as needed to process the desired Result.
 type Payload interface {
 	Bytes() []byte
@@ -24,14 +41,19 @@ type Payload interface {
 	KV(id string) KVStore
 	Results() chan Result
 	Discard()
+	Tracer() Tracer
 }
 
+// Tracer receives messages describing how Drivers processed a Payload, such as the conditions matched.
+type Tracer func(format string, args ...interface{})
+
 type payload struct {
 	b       []byte
 	err     error
 	lock    sync.Mutex
 	kv      map[string]KVStore
 	results chan Result
+	tracer  Tracer
 }
 
 // NewPayload returns a new Payload.
@@ -46,6 +68,13 @@ func NewPayload() Payload {
 	return &P
 }
 
+// NewTracedPayload returns a new Payload passing trace messages from Drivers to the Tracer.
+func NewTracedPayload(t Tracer) Payload {
+	P := NewPayload().(*payload)
+	P.tracer = t
+	return P
+}
+
 func (p *payload) Bytes() []byte {
 	return p.b
 }
@@ -81,6 +110,11 @@ func (p *payload) Discard() {
 	close(p.results)
 }
 
+// Tracer returns the Tracer of the Payload, or nil if not traced.
+func (p *payload) Tracer() Tracer {
+	return p.tracer
+}
+
 // Result contains the results of processing data through Drivers and any errors along the way.
 type Result interface {
 	Bytes() []byte
@@ -0,0 +1,97 @@
+package exec
+
+import (
+	"fmt"
+
+	"github.com/jbvmio/lfm/driver"
+	"github.com/jbvmio/lfm/internal/proc"
+	"gopkg.in/yaml.v2"
+)
+
+func init() {
+	driver.Register(`exec`, func(details map[string]interface{}) (driver.Driver, error) {
+		var cfg Config
+		if err := cfg.Configure(details); err != nil {
+			return nil, err
+		}
+		return NewDriver(&cfg)
+	})
+	driver.RegisterConfig(`exec`, func() driver.Config { return &Config{} })
+}
+
+// Config contains configuration details when using the Exec Driver.
+//
+// The command is launched once per Driver and receives a process Message for each event, containing the
+// payload data, tags and vars. The response data replaces the payload, or discards the event if empty.
+// Tags and vars in the response replace those of the payload when present.
+type Config struct {
+	proc.Config `yaml:",inline"`
+}
+
+// Configure attempts to configure the Config based on the details entered.
+func (c *Config) Configure(details map[string]interface{}) error {
+	d, err := yaml.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("invalid configuration format: %v", err)
+	}
+	var cfg Config
+	err = yaml.Unmarshal(d, &cfg)
+	if err != nil {
+		return fmt.Errorf("invalid configuration: %v", err)
+	}
+	*c = cfg
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("invalid exec driver: %v", err)
+	}
+	return nil
+}
+
+// Driver processes payloads using a supervised child process.
+type Driver struct {
+	proc *proc.Process
+}
+
+// NewDriver returns a new Exec Driver, starting its process.
+func NewDriver(cfg *Config) (*Driver, error) {
+	p, err := proc.Start(cfg.Config, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Driver{
+		proc: p,
+	}, nil
+}
+
+// Process sends the payload to the child process and waits for the result.
+func (d *Driver) Process(P driver.Payload) {
+	tags := make(map[string]string)
+	for k, v := range P.KV(driver.TagsLabel).All() {
+		tags[k] = fmt.Sprint(v)
+	}
+	resp, err := d.proc.Call(proc.Message{
+		Type: proc.TypeProcess,
+		Data: P.Bytes(),
+		Tags: tags,
+		Vars: P.KV(driver.VarsLabel).All(),
+	})
+	if err != nil {
+		P.Results() <- driver.NewResult(nil, fmt.Errorf("exec driver: %w", err))
+		return
+	}
+	if resp.Tags != nil {
+		all := make(map[string]interface{}, len(resp.Tags))
+		for k, v := range resp.Tags {
+			all[k] = v
+		}
+		P.KV(driver.TagsLabel).Use(all)
+	}
+	if resp.Vars != nil {
+		P.KV(driver.VarsLabel).Use(resp.Vars)
+	}
+	P.Results() <- driver.NewResult(resp.Data, nil)
+}
+
+// Close stops the child process.
+func (d *Driver) Close() error {
+	return d.proc.Stop()
+}
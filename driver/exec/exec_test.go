@@ -0,0 +1,123 @@
+package exec
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jbvmio/lfm/driver"
+	"github.com/jbvmio/lfm/internal/proc"
+)
+
+// helperEnv marks the test binary as running as a helper child process.
+const helperEnv = `LFM_HELPER_PROCESS`
+
+func helperConfig(mode string) *Config {
+	return &Config{proc.Config{
+		Command:    []string{os.Args[0], `-test.run=TestHelperProcess`, `--`, mode},
+		Env:        map[string]string{helperEnv: `1`},
+		Timeout:    5 * time.Second,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	}}
+}
+
+// TestHelperProcess is run as the child process by the other tests. Depending on the mode, echo responds
+// to each Message with its data in upper case and an added tag, exit responds once before exiting
+// and silent never responds.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv(helperEnv) != `1` {
+		return
+	}
+	defer os.Exit(0)
+	mode := os.Args[len(os.Args)-1]
+	r, w := bufio.NewReader(os.Stdin), bufio.NewWriter(os.Stdout)
+	for {
+		m, err := proc.ReadFrame(r)
+		if err != nil {
+			return
+		}
+		if mode == `silent` {
+			continue
+		}
+		m.Tags[`helper`] = mode
+		proc.WriteFrame(w, proc.Message{ID: m.ID, Data: bytes.ToUpper(m.Data), Tags: m.Tags})
+		w.Flush()
+		if mode == `exit` {
+			return
+		}
+	}
+}
+
+// process runs the data through the Driver, returning the result and the payload tags.
+func process(t *testing.T, d *Driver, data string) (driver.Result, map[string]interface{}) {
+	t.Helper()
+	P := driver.NewPayload()
+	P.UseBytes([]byte(data))
+	P.KV(driver.TagsLabel).Add(`app`, `test`)
+	go d.Process(P)
+	select {
+	case r := <-P.Results():
+		return r, P.KV(driver.TagsLabel).All()
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a result")
+	}
+	return nil, nil
+}
+
+func TestProcess(t *testing.T) {
+	d, err := NewDriver(helperConfig(`echo`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	r, tags := process(t, d, `hello`)
+	if r.Error() != nil {
+		t.Fatal(r.Error())
+	}
+	if string(r.Bytes()) != `HELLO` || tags[`app`] != `test` || tags[`helper`] != `echo` {
+		t.Fatalf("expected HELLO with the app and helper tags, got %s with %v", r.Bytes(), tags)
+	}
+}
+
+func TestProcessTimeout(t *testing.T) {
+	cfg := helperConfig(`silent`)
+	cfg.Timeout = 200 * time.Millisecond
+	d, err := NewDriver(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if r, _ := process(t, d, `hello`); !errors.Is(r.Error(), proc.ErrTimeout) {
+		t.Fatalf("expected %v, got %v", proc.ErrTimeout, r.Error())
+	}
+}
+
+func TestProcessAfterRestart(t *testing.T) {
+	d, err := NewDriver(helperConfig(`exit`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Close()
+	if r, _ := process(t, d, `first`); r.Error() != nil || string(r.Bytes()) != `FIRST` {
+		t.Fatalf("expected FIRST, got %s: %v", r.Bytes(), r.Error())
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		r, _ := process(t, d, `second`)
+		if r.Error() == nil {
+			if string(r.Bytes()) != `SECOND` {
+				t.Fatalf("expected SECOND, got %s", r.Bytes())
+			}
+			return
+		}
+		// calls fail until the process is restarted.
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the process to be restarted, got %v", r.Error())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -13,6 +13,21 @@ const (
 	FieldsLabel = `fields`
 )
 
+func init() {
+	driver.Register(`json`, func(details map[string]interface{}) (driver.Driver, error) {
+		var cfg Config
+		if err := cfg.Configure(details); err != nil {
+			return nil, err
+		}
+		d, err := NewDriver(&cfg)
+		if err != nil {
+			return nil, err
+		}
+		return d, nil
+	})
+	driver.RegisterConfig(`json`, func() driver.Config { return &Config{} })
+}
+
 // Config contains configuration details when using the JSON Driver.
 type Config struct {
 	Method  string         `yaml:"method" json:"method"`
@@ -50,6 +65,51 @@ func (c *Config) Configure(details map[string]interface{}) error {
 	return nil
 }
 
+// Validate checks the method, actions and conditions of the Config without creating a Driver.
+func (c *Config) Validate() error {
+	if jsonMethodFunc[c.Method] == nil {
+		return fmt.Errorf("invalid method %s", c.Method)
+	}
+	for _, f := range c.Fields {
+		if f.Path == "" {
+			return fmt.Errorf("missing path for method %s", c.Method)
+		}
+		action := f.Action
+		if action == "" {
+			switch c.Method {
+			case `extract`, `filter`:
+				continue
+			default:
+				return fmt.Errorf("missing action for json method: %s", c.Method)
+			}
+		}
+		ok, action, name := parseFunction(action)
+		if !ok {
+			return fmt.Errorf("invalid action for path %s: %s", f.Path, f.Action)
+		}
+		if name == "" {
+			switch action {
+			case `drop`, `exists`, `keep`, `remove`, `changeJSON`:
+			default:
+				return fmt.Errorf("missing action value for path %s: %s", f.Path, f.Action)
+			}
+		}
+		if _, ok := jsonMethodAllowedActions[c.Method][action]; !ok {
+			return fmt.Errorf("invalid action for path %s: %s", f.Path, action)
+		}
+		for _, cond := range f.Conditions {
+			valid, condition, _ := parseFunction(cond)
+			if !valid {
+				return fmt.Errorf("invalid condition for path %s: %s", f.Path, condition)
+			}
+			if _, ok := jsonActionAllowedConditions[action][condition]; !ok {
+				return fmt.Errorf("invalid condition for action %s: %s", action, condition)
+			}
+		}
+	}
+	return nil
+}
+
 // Driver holds dynamic data as it is passed through various operations.
 type Driver struct {
 	actions driverActions
@@ -131,6 +191,9 @@ func (d *Driver) Process(P driver.Payload) {
 		}
 	}
 	if JP.Remove() && len(JP.KV(driver.TagsLabel).All()) < 1 {
+		if t := JP.Tracer(); t != nil {
+			t("event dropped: removed by a filter action")
+		}
 		JP.Results() <- driver.NewResult([]byte{}, nil)
 		return
 	}
@@ -284,11 +347,6 @@ func NewDriver(cfg *Config) (*Driver, error) {
 				}
 			}
 
-			fmt.Println(">>     METHOD:", cfg.Method)
-			fmt.Println(">>     ACTION:", action)
-			fmt.Println(">>       NAME:", name)
-			fmt.Println(">> CONDITIONS:", f.Conditions)
-
 			cs := make([]jsonConditionalFn, 0, len(f.Conditions))
 			switch len(f.Conditions) {
 			case 0:
@@ -300,20 +358,13 @@ func NewDriver(cfg *Config) (*Driver, error) {
 						return &Driver{}, fmt.Errorf("invalid condition for path %s: %s", f.Path, condition)
 					}
 					if C, ok := jsonActionAllowedConditions[action][condition]; ok {
-						fmt.Println("  >> APPENDING CONDITION:", condition)
 						cs = append(cs, C(arg))
 					} else {
 						return &Driver{}, fmt.Errorf("invalid condition for action %s: %s", action, condition)
 					}
-
-					fmt.Println("  >> CONDITION:", condition)
-					fmt.Println("  >>       ARG:", arg)
-
 				}
 			}
 
-			fmt.Println()
-
 			var actFn func(Payload)
 			if F, ok := jsonMethodAllowedActions[cfg.Method][action]; ok {
 				actFn = F(name, fn, cs...)
@@ -321,9 +372,65 @@ func NewDriver(cfg *Config) (*Driver, error) {
 			if actFn == nil {
 				return &Driver{}, fmt.Errorf("invalid action for path %s: %s", f.Path, action)
 			}
-			fns = append(fns, actFn)
+			fns = append(fns, traceAction(actFn, fn, cfg.Method, f, action, name, cs))
 		}
 	}
 	driver.fns = fns
 	return &driver, nil
 }
+
+// traceAction wraps the action func, describing the value found and the conditions matched when the Payload is traced.
+func traceAction(actFn func(Payload), fn jsonMethodFn, method string, f fieldActions, action, name string, cs []jsonConditionalFn) func(Payload) {
+	desc := fmt.Sprintf("%s path %s action %s", method, f.Path, action)
+	return func(P Payload) {
+		t := P.Tracer()
+		if t == nil {
+			actFn(P)
+			return
+		}
+		val := traceValue(P, fn, method, action, name)
+		t("%s: value %s", desc, traceJSON(val))
+		for n, c := range f.Conditions {
+			if n < len(cs) {
+				t("  condition %s matched: %v", c, cs[n](val))
+			}
+		}
+		removed := P.Remove()
+		actFn(P)
+		switch {
+		case P.Error() != nil:
+			t("  error: %v", P.Error())
+		case !removed && P.Remove():
+			t("  event marked for removal by %s", action)
+		}
+	}
+}
+
+// traceValue returns the value the action applies its conditions to.
+func traceValue(P Payload, fn jsonMethodFn, method, action, name string) interface{} {
+	if action == `keepIf` {
+		if there, Fn, arg := parseFunction(name); there {
+			return P.KV(jsonDriverActionKV[Fn]).Get(arg)
+		}
+		return nil
+	}
+	if method != `transform` {
+		return fn(P.Bytes())
+	}
+	b := P.Bytes()
+	if fields := P.KV(FieldsLabel).All(); len(fields) > 0 {
+		b, _ = JS.Marshal(fields)
+	}
+	if val, ok := fn(b).([]interface{}); ok && len(val) > 1 {
+		return val[1]
+	}
+	return nil
+}
+
+func traceJSON(x interface{}) string {
+	b, err := JS.Marshal(x)
+	if err != nil {
+		return fmt.Sprint(x)
+	}
+	return string(b)
+}
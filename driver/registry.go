@@ -0,0 +1,94 @@
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Factory returns a Driver configured using the details of a workflow.
+type Factory func(details map[string]interface{}) (Driver, error)
+
+// Config represents the configuration details for a Driver.
+type Config interface {
+	Configure(map[string]interface{}) error
+}
+
+// ConfigFactory returns a new, unconfigured Config for a Driver.
+type ConfigFactory func() Config
+
+var (
+	registryLock sync.RWMutex
+	factories    = make(map[string]Factory)
+	configs      = make(map[string]ConfigFactory)
+)
+
+// Register makes a Driver available by the name used for driver in a workflow.
+// It is intended to be called from the init function of the package providing the Driver
+// and panics if the name is already registered or the factory is nil.
+func Register(name string, factory Factory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if factory == nil {
+		panic("driver: Register factory is nil for " + name)
+	}
+	if _, dup := factories[name]; dup {
+		panic("driver: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// RegisterConfig makes the Config of a registered Driver available, allowing workflows to be checked
+// against its fields without creating the Driver. It panics if the name is already registered or the factory is nil.
+func RegisterConfig(name string, factory ConfigFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if factory == nil {
+		panic("driver: RegisterConfig factory is nil for " + name)
+	}
+	if _, dup := configs[name]; dup {
+		panic("driver: RegisterConfig called twice for " + name)
+	}
+	configs[name] = factory
+}
+
+// NewConfig returns a new Config for the named Driver, or nil if the Driver did not register a Config.
+// An error is returned if no Driver is registered with the name.
+func NewConfig(name string) (Config, error) {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	if _, ok := factories[name]; !ok {
+		return nil, fmt.Errorf("invalid driver: %s", name)
+	}
+	if factory, ok := configs[name]; ok {
+		return factory(), nil
+	}
+	return nil, nil
+}
+
+// New returns a Driver created by the registered Factory named by driver in the workflow details.
+func New(details map[string]interface{}) (Driver, error) {
+	name, there := details[`driver`].(string)
+	if !there || name == "" {
+		return nil, fmt.Errorf("missing or invalid driver")
+	}
+	registryLock.RLock()
+	factory, ok := factories[name]
+	registryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("invalid driver: %s", name)
+	}
+	return factory(details)
+}
+
+// Drivers returns the sorted names of all registered Drivers.
+func Drivers() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
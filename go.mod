@@ -3,16 +3,21 @@ module github.com/jbvmio/lfm
 go 1.14
 
 require (
+	github.com/Shopify/sarama v1.27.0
 	github.com/cortexproject/cortex v1.4.0
-	github.com/go-kit/kit v0.10.0
+	github.com/go-kit/kit v0.10.0 // indirect
+	github.com/gogo/protobuf v1.3.1
+	github.com/golang/snappy v0.0.1
 	github.com/grafana/loki v1.6.1
 	github.com/jbvmio/kafka v1.0.21
 	github.com/nxadm/tail v1.4.4
 	github.com/pkg/errors v0.9.1
+	github.com/prometheus/client_golang v1.7.1
 	github.com/prometheus/common v0.11.1
 	github.com/spf13/pflag v1.0.5
 	github.com/tidwall/gjson v1.6.1
 	go.uber.org/zap v1.16.0
+	golang.org/x/crypto v0.0.0-20200728195943-123391ffb6de
 	gopkg.in/yaml.v2 v2.3.0
 	gopkg.in/yaml.v3 v3.0.0-20200615113413-eeeca48fe776
 )
@@ -3,17 +3,39 @@ package drivers
 import (
 	"fmt"
 	"io/ioutil"
+	"strconv"
 
 	"github.com/jbvmio/lfm/driver"
+	"github.com/jbvmio/lfm/metrics"
+	"github.com/prometheus/client_golang/prometheus"
 
 	"github.com/jbvmio/lfm/pipeline"
 )
 
-// MakeDriversFunc creates a useable function using the given Drivers.
-func MakeDriversFunc(steps [][]driver.Driver) func(pipeline.Data) (bool, error) {
+// driverMetrics holds the result counters for a single Driver.
+type driverMetrics struct {
+	passed   prometheus.Counter
+	filtered prometheus.Counter
+	failed   prometheus.Counter
+}
+
+// MakeDriversFunc creates a useable function using the given Drivers for the named Pipeline Stage.
+func MakeDriversFunc(name string, stage int, steps [][]driver.Driver) func(pipeline.Data) (bool, error) {
 	if len(steps) < 1 {
 		return pipeline.NoopData
 	}
+	dm := make([][]driverMetrics, len(steps))
+	for step, drivers := range steps {
+		dm[step] = make([]driverMetrics, len(drivers))
+		for n := range drivers {
+			labels := []string{name, strconv.Itoa(stage), strconv.Itoa(step), strconv.Itoa(n)}
+			dm[step][n] = driverMetrics{
+				passed:   metrics.DriverEvents.WithLabelValues(append(labels, metrics.ResultPassed)...),
+				filtered: metrics.DriverEvents.WithLabelValues(append(labels, metrics.ResultFiltered)...),
+				failed:   metrics.DriverEvents.WithLabelValues(append(labels, metrics.ResultFailed)...),
+			}
+		}
+	}
 	return func(d pipeline.Data) (bool, error) {
 		if len(d.Bytes()) < 1 {
 			return false, fmt.Errorf("empty data received")
@@ -24,21 +46,49 @@ func MakeDriversFunc(steps [][]driver.Driver) func(pipeline.Data) (bool, error)
 		}
 		P := driver.NewPayload()
 		defer P.Discard()
-		for _, drivers := range steps {
-			for _, d := range drivers {
-				P.UseBytes(data)
-				go d.Process(P)
-				result := <-P.Results()
-				if result.Error() != nil {
-					return false, result.Error()
-				}
-				if len(result.Bytes()) < 1 {
-					return false, nil
-				}
-				data = result.Bytes()
+		AddMetadata(P, pipeline.Metadata(d))
+		data, err = ProcessSteps(P, data, steps, func(step, n int, result driver.Result) {
+			switch {
+			case result.Error() != nil:
+				dm[step][n].failed.Inc()
+			case len(result.Bytes()) < 1:
+				dm[step][n].filtered.Inc()
+			default:
+				dm[step][n].passed.Inc()
 			}
+		})
+		if data == nil || err != nil {
+			return false, err
 		}
 		d.Write(data)
 		return true, nil
 	}
 }
+
+// AddMetadata adds the metadata of an Event to the tmpVars of the Payload.
+func AddMetadata(P driver.Payload, meta map[string]string) {
+	for k, v := range meta {
+		P.KV(driver.VarsLabel).Add(k, v)
+	}
+}
+
+// ProcessSteps passes the data through the Drivers of each step in order using the Payload,
+// calling done with the Result of each Driver. Returns nil data if the data was filtered.
+func ProcessSteps(P driver.Payload, data []byte, steps [][]driver.Driver, done func(step, n int, result driver.Result)) ([]byte, error) {
+	for step, drivers := range steps {
+		for n, d := range drivers {
+			P.UseBytes(data)
+			go d.Process(P)
+			result := <-P.Results()
+			done(step, n, result)
+			if result.Error() != nil {
+				return nil, &driver.Error{Step: step, Driver: n, Err: result.Error()}
+			}
+			if len(result.Bytes()) < 1 {
+				return nil, nil
+			}
+			data = result.Bytes()
+		}
+	}
+	return data, nil
+}
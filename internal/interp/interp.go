@@ -0,0 +1,230 @@
+// Package interp expands references within the string values of YAML documents:
+//
+//	${VAR}            the value of the environment variable VAR, which must be set.
+//	${VAR:-default}   the value of VAR, or default if VAR is unset or empty.
+//	${file:path}      the contents of the file at path, without any trailing newline. A relative path is
+//	                  resolved against the directory of the document containing the reference.
+//	$${               a literal ${.
+//
+// Template parameter references, ${param:NAME} or ${param:NAME:-default}, are left in place to be expanded using Params.
+package interp
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	paramPrefix = `param:`
+	filePrefix  = `file:`
+)
+
+var (
+	refRegex       = regexp.MustCompile(`\$\$\{|\$\{([^}]*)\}`)
+	paramRegex     = regexp.MustCompile(`\$\{param:([^}]*)\}`)
+	nameRegex      = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	singleRefRegex = regexp.MustCompile(`^\$\{([^}]*)\}$`)
+)
+
+// String expands all references in s, resolving relative file references against dir.
+// Relative file references are rejected if dir is empty.
+func String(s, dir string) (string, error) {
+	return replace(s, refRegex, func(ref string) (string, error) {
+		switch {
+		case ref == `$${`:
+			return `${`, nil
+		case strings.HasPrefix(ref, `${`+paramPrefix):
+			return ref, nil
+		}
+		return expand(ref[2:len(ref)-1], dir)
+	})
+}
+
+// replace replaces each match of re within s using fn, returning the first error.
+func replace(s string, re *regexp.Regexp, fn func(string) (string, error)) (string, error) {
+	var err error
+	out := re.ReplaceAllStringFunc(s, func(ref string) string {
+		val, e := fn(ref)
+		if e != nil && err == nil {
+			err = e
+		}
+		return val
+	})
+	return out, err
+}
+
+func expand(ref, dir string) (string, error) {
+	if strings.HasPrefix(ref, filePrefix) {
+		path := strings.TrimPrefix(ref, filePrefix)
+		if !filepath.IsAbs(path) {
+			if dir == "" {
+				return "", fmt.Errorf("relative file reference %s requires an absolute path", path)
+			}
+			path = filepath.Join(dir, path)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("could not read file reference: %w", err)
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	}
+	name, def, hasDef, err := parseRef(ref)
+	if err != nil {
+		return "", err
+	}
+	val, set := os.LookupEnv(name)
+	switch {
+	case hasDef && val == "":
+		return def, nil
+	case !set:
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return val, nil
+}
+
+// parseRef splits a NAME or NAME:-default reference.
+func parseRef(ref string) (name, def string, hasDef bool, err error) {
+	name = ref
+	if i := strings.Index(ref, `:-`); i >= 0 {
+		name, def, hasDef = ref[:i], ref[i+2:], true
+	}
+	if !nameRegex.MatchString(name) {
+		return "", "", false, fmt.Errorf("invalid reference ${%s}", ref)
+	}
+	return name, def, hasDef, nil
+}
+
+// Error records a value of a YAML document which could not be expanded.
+type Error struct {
+	// Keys is the path to the value from the document root, with sequence indexes given as [n].
+	Keys []string
+	Node *yaml.Node
+	Err  error
+}
+
+// Key returns the Keys as a single path, omitting the first number of keys.
+func (e *Error) Key(skip int) string {
+	var b strings.Builder
+	for n, k := range e.Keys {
+		if n < skip {
+			continue
+		}
+		if n > skip && !strings.HasPrefix(k, `[`) {
+			b.WriteString(`.`)
+		}
+		b.WriteString(k)
+	}
+	return b.String()
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("key %s: %v", e.Key(0), e.Err)
+}
+
+// Node expands the references of all string values within n in place, resolving relative file references
+// against dir, and returns an Error for each value which could not be expanded.
+// An unquoted value consisting of a single ${VAR} reference is re-resolved, allowing it to provide a number or boolean,
+// while all other values, including file contents, remain strings.
+func Node(n *yaml.Node, dir string) []*Error {
+	return expandNode(n, func(s string) (string, error) {
+		return String(s, dir)
+	}, func(ref, val string) string {
+		if m := singleRefRegex.FindStringSubmatch(ref); m != nil && !strings.HasPrefix(m[1], filePrefix) {
+			return resolvedTag(val)
+		}
+		return `!!str`
+	})
+}
+
+// Params expands the template parameter references of all string values within n in place using params,
+// returning an Error for each value referencing a parameter which is not given and has no default.
+// An unquoted value consisting of a single parameter reference takes the type of the parameter value.
+func Params(n *yaml.Node, params map[string]*yaml.Node) []*Error {
+	return expandNode(n, func(s string) (string, error) {
+		return replace(s, paramRegex, func(ref string) (string, error) {
+			name, def, hasDef, err := parseRef(ref[len(`${`+paramPrefix) : len(ref)-1])
+			if err != nil {
+				return "", err
+			}
+			p, set := params[name]
+			switch {
+			case hasDef && (!set || p.Value == ""):
+				return def, nil
+			case !set:
+				return "", fmt.Errorf("parameter %s is not set", name)
+			}
+			return p.Value, nil
+		})
+	}, func(ref, val string) string {
+		m := singleRefRegex.FindStringSubmatch(ref)
+		if m == nil || !strings.HasPrefix(m[1], paramPrefix) {
+			return `!!str`
+		}
+		name, _, _, _ := parseRef(strings.TrimPrefix(m[1], paramPrefix))
+		if p := params[name]; p != nil && p.Value == val {
+			return p.ShortTag()
+		}
+		// the default given in the template.
+		return resolvedTag(val)
+	})
+}
+
+// HasParams reports whether s contains a template parameter reference.
+func HasParams(s string) bool {
+	return paramRegex.MatchString(s)
+}
+
+// expandNode expands the string values within n in place using fn. The tag of each unquoted value which changed
+// is set using tag, given the value before and after expansion.
+func expandNode(n *yaml.Node, fn func(string) (string, error), tag func(ref, val string) string) (errs []*Error) {
+	walk(n, nil, func(keys []string, x *yaml.Node) {
+		if x.Tag != `!!str` || !strings.Contains(x.Value, `${`) {
+			return
+		}
+		val, err := fn(x.Value)
+		if err != nil {
+			errs = append(errs, &Error{Keys: append([]string(nil), keys...), Node: x, Err: err})
+			return
+		}
+		if val == x.Value {
+			return
+		}
+		if x.Style == 0 {
+			x.Tag = tag(x.Value, val)
+		}
+		x.Value = val
+	})
+	return errs
+}
+
+// resolvedTag returns the tag of val as an unquoted value.
+func resolvedTag(val string) string {
+	x := yaml.Node{Kind: yaml.ScalarNode, Value: val}
+	return x.ShortTag()
+}
+
+func walk(n *yaml.Node, keys []string, fn func([]string, *yaml.Node)) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		for _, x := range n.Content {
+			walk(x, keys, fn)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			walk(n.Content[i+1], append(keys, n.Content[i].Value), fn)
+		}
+	case yaml.SequenceNode:
+		for i, x := range n.Content {
+			walk(x, append(keys, `[`+strconv.Itoa(i)+`]`), fn)
+		}
+	case yaml.ScalarNode:
+		fn(keys, n)
+	}
+}
@@ -0,0 +1,141 @@
+package interp
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "lfm-interp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func setenv(t *testing.T, vars map[string]string) func() {
+	t.Helper()
+	for k, v := range vars {
+		if err := os.Setenv(k, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		for k := range vars {
+			os.Unsetenv(k)
+		}
+	}
+}
+
+func TestString(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, `secret`), []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer setenv(t, map[string]string{`LFM_INTERP_SET`: `value`, `LFM_INTERP_EMPTY`: ``})()
+	for _, x := range []struct {
+		in   string
+		dir  string
+		want string
+		err  string
+	}{
+		{in: `plain`, want: `plain`},
+		{in: `${LFM_INTERP_SET}`, want: `value`},
+		{in: `a-${LFM_INTERP_SET}-b`, want: `a-value-b`},
+		{in: `${LFM_INTERP_EMPTY}`, want: ``},
+		{in: `${LFM_INTERP_UNSET:-default}`, want: `default`},
+		{in: `${LFM_INTERP_EMPTY:-default}`, want: `default`},
+		{in: `${LFM_INTERP_SET:-default}`, want: `value`},
+		{in: `${LFM_INTERP_UNSET:-}`, want: ``},
+		{in: `$${LFM_INTERP_SET}`, want: `${LFM_INTERP_SET}`},
+		{in: `$$${LFM_INTERP_SET}`, want: `$${LFM_INTERP_SET}`},
+		{in: `${param:name}`, want: `${param:name}`},
+		{in: `${file:` + filepath.Join(dir, `secret`) + `}`, want: `s3cret`},
+		{in: `${file:secret}`, dir: dir, want: `s3cret`},
+		{in: `${LFM_INTERP_UNSET}`, err: `environment variable LFM_INTERP_UNSET is not set`},
+		{in: `${1BAD}`, err: `invalid reference ${1BAD}`},
+		{in: `${file:secret}`, err: `relative file reference`},
+		{in: `${file:missing}`, dir: dir, err: `could not read file reference`},
+	} {
+		got, err := String(x.in, x.dir)
+		switch {
+		case x.err != "":
+			if err == nil || !strings.Contains(err.Error(), x.err) {
+				t.Fatalf("%s: expected error %q, got %v", x.in, x.err, err)
+			}
+		case err != nil:
+			t.Fatalf("%s: %v", x.in, err)
+		case got != x.want:
+			t.Fatalf("%s: expected %q, got %q", x.in, x.want, got)
+		}
+	}
+}
+
+func parse(t *testing.T, s string) *yaml.Node {
+	t.Helper()
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(s), &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc.Content[0]
+}
+
+func TestNodeTags(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, `secret`), []byte("true\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer setenv(t, map[string]string{`LFM_INTERP_NUM`: `5`, `LFM_INTERP_NULL`: `null`, `LFM_INTERP_TILDE`: `~`})()
+	n := parse(t, `
+num: ${LFM_INTERP_NUM}
+quoted: "${LFM_INTERP_NUM}"
+mixed: ${LFM_INTERP_NUM}0
+null: ${LFM_INTERP_NULL}
+tilde: x${LFM_INTERP_TILDE}
+file: ${file:secret}
+`)
+	if errs := Node(n, dir); len(errs) > 0 {
+		t.Fatal(errs[0])
+	}
+	want := map[string][2]string{
+		`num`:    {`!!int`, `5`},
+		`quoted`: {`!!str`, `5`},
+		`mixed`:  {`!!str`, `50`},
+		`null`:   {`!!null`, `null`},
+		`tilde`:  {`!!str`, `x~`},
+		`file`:   {`!!str`, `true`},
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		k, v := n.Content[i].Value, n.Content[i+1]
+		if w := want[k]; v.Tag != w[0] || v.Value != w[1] {
+			t.Fatalf("%s: expected %s %q, got %s %q", k, w[0], w[1], v.Tag, v.Value)
+		}
+	}
+}
+
+func TestNodeErrorKeys(t *testing.T) {
+	n := parse(t, `
+pipeline:
+  destinations:
+    - plugin: loki
+      url: ${LFM_INTERP_UNSET}
+`)
+	errs := Node(n, "")
+	if len(errs) != 1 {
+		t.Fatalf("expected 1 error, got %d", len(errs))
+	}
+	if got := errs[0].Key(1); got != `destinations[0].url` {
+		t.Fatalf("expected key destinations[0].url, got %s", got)
+	}
+	if got := errs[0].Error(); got != `key pipeline.destinations[0].url: environment variable LFM_INTERP_UNSET is not set` {
+		t.Fatalf("unexpected error: %s", got)
+	}
+}
@@ -0,0 +1,538 @@
+// Package loader reads lfm config files into a single YAML tree of pipelines, following includes,
+// inlining stageFiles, instantiating templates and expanding references, while keeping the file and
+// position of every value for reporting errors.
+//
+// Besides pipelines, a config file may contain the keys:
+//
+//	include     a path, directory or glob pattern, or list of them, of config files to load.
+//	templates   named stages and steps, under the keys stages and steps, which pipelines reference
+//	            using template and optional params keys in place of or alongside their own keys.
+//
+// Relative include, stageFile and ${file:path} reference paths are resolved against the directory of the file
+// containing them.
+package loader
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jbvmio/lfm/internal/interp"
+	"gopkg.in/yaml.v3"
+)
+
+// Reserved top level keys of a config file.
+const (
+	IncludeKey   = `include`
+	TemplatesKey = `templates`
+)
+
+// Keys used when referencing a template.
+const (
+	TemplateKey = `template`
+	ParamsKey   = `params`
+)
+
+// Error is an error found at a location within a file while loading.
+type Error struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.File, e.Msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+// Document is the result of loading a config file.
+type Document struct {
+	// Root maps the names of the pipelines from all files to their configs.
+	Root *yaml.Node
+	// Files contains the paths of every file and included directory read, starting with the config file.
+	Files []string
+
+	origin     map[*yaml.Node]string
+	unexpanded map[*yaml.Node]bool
+}
+
+// File returns the path of the file containing the node.
+func (d *Document) File(n *yaml.Node) string {
+	return d.origin[n]
+}
+
+// Unexpanded reports whether the node is a value which could not be expanded, or a stage or step
+// whose template could not be instantiated.
+func (d *Document) Unexpanded(n *yaml.Node) bool {
+	return d.unexpanded[n]
+}
+
+type loader struct {
+	doc       *Document
+	errs      []*Error
+	loaded    map[string]bool
+	pipelines map[string]*yaml.Node
+	stages    map[string]*yaml.Node
+	steps     map[string]*yaml.Node
+}
+
+// Load loads the config file at path. The returned Document holds everything that could be loaded,
+// alongside all the errors found.
+func Load(path string) (*Document, []*Error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		l := newLoader()
+		l.doc.Files = append(l.doc.Files, path)
+		return l.doc, []*Error{{File: path, Msg: err.Error()}}
+	}
+	return LoadBytes(path, b)
+}
+
+// LoadBytes loads the config contents as though read from the file at path.
+func LoadBytes(path string, b []byte) (*Document, []*Error) {
+	l := newLoader()
+	l.addFile(path)
+	if root, ok := l.file(path, b); ok && root == nil {
+		l.errs = append(l.errs, &Error{File: path, Msg: "empty configuration"})
+	}
+	l.resolve()
+	return l.doc, l.errs
+}
+
+func newLoader() *loader {
+	return &loader{
+		doc: &Document{
+			Root:       &yaml.Node{Kind: yaml.MappingNode, Tag: `!!map`},
+			origin:     make(map[*yaml.Node]string),
+			unexpanded: make(map[*yaml.Node]bool),
+		},
+		loaded:    make(map[string]bool),
+		pipelines: make(map[string]*yaml.Node),
+		stages:    make(map[string]*yaml.Node),
+		steps:     make(map[string]*yaml.Node),
+	}
+}
+
+func (l *loader) errorf(n *yaml.Node, format string, args ...interface{}) {
+	l.errs = append(l.errs, &Error{File: l.doc.origin[n], Line: n.Line, Column: n.Column, Msg: fmt.Sprintf(format, args...)})
+}
+
+// addFile records the file as read, returning false if it already was.
+func (l *loader) addFile(path string) bool {
+	key := path
+	if abs, err := filepath.Abs(path); err == nil {
+		key = abs
+	}
+	if l.loaded[key] {
+		return false
+	}
+	l.loaded[key] = true
+	l.doc.Files = append(l.doc.Files, path)
+	return true
+}
+
+var lineRegex = regexp.MustCompile(`^yaml: line (\d+): `)
+
+// parse returns the root node of the contents with its references expanded, or nil if empty.
+// It returns false if the contents could not be parsed.
+func (l *loader) parse(path string, b []byte, describe func(*interp.Error) string) (*yaml.Node, bool) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		e := &Error{File: path, Msg: err.Error()}
+		if m := lineRegex.FindStringSubmatch(e.Msg); m != nil {
+			e.Line, _ = strconv.Atoi(m[1])
+			e.Msg = strings.TrimPrefix(e.Msg, m[0])
+		}
+		l.errs = append(l.errs, e)
+		return nil, false
+	}
+	if len(doc.Content) < 1 {
+		return nil, true
+	}
+	root := resolve(doc.Content[0])
+	l.setOrigin(root, path)
+	for _, e := range interp.Node(root, filepath.Dir(path)) {
+		l.doc.unexpanded[e.Node] = true
+		l.errorf(e.Node, "%s: %v", describe(e), e.Err)
+	}
+	return root, true
+}
+
+func (l *loader) setOrigin(n *yaml.Node, path string) {
+	if _, ok := l.doc.origin[n]; ok {
+		return
+	}
+	l.doc.origin[n] = path
+	for _, x := range n.Content {
+		l.setOrigin(x, path)
+	}
+}
+
+// file loads the pipelines and templates of a config file, followed by the files it includes.
+func (l *loader) file(path string, b []byte) (*yaml.Node, bool) {
+	root, ok := l.parse(path, b, func(e *interp.Error) string {
+		switch {
+		case len(e.Keys) < 2 || e.Keys[0] == IncludeKey || e.Keys[0] == TemplatesKey:
+			return "key " + e.Key(0)
+		default:
+			return fmt.Sprintf("pipeline %s key %s", e.Keys[0], e.Key(1))
+		}
+	})
+	if root == nil {
+		return root, ok
+	}
+	if root.Kind != yaml.MappingNode {
+		l.errorf(root, "expected a map of pipeline names to pipeline configs")
+		return root, ok
+	}
+	var includes []*yaml.Node
+	eachKey(root, func(k, v *yaml.Node) {
+		switch k.Value {
+		case IncludeKey:
+			includes = append(includes, v)
+		case TemplatesKey:
+			l.templates(v)
+		default:
+			if prev := l.pipelines[k.Value]; prev != nil {
+				l.errorf(k, "pipeline %s is already defined at %s", k.Value, l.position(prev))
+				return
+			}
+			l.pipelines[k.Value] = k
+			l.doc.Root.Content = append(l.doc.Root.Content, k, v)
+		}
+	})
+	for _, n := range includes {
+		l.include(path, n)
+	}
+	return root, ok
+}
+
+func (l *loader) position(n *yaml.Node) string {
+	return fmt.Sprintf("%s:%d:%d", l.doc.origin[n], n.Line, n.Column)
+}
+
+// include loads the config files referenced by an include value.
+func (l *loader) include(base string, n *yaml.Node) {
+	var refs []*yaml.Node
+	switch n.Kind {
+	case yaml.ScalarNode:
+		refs = []*yaml.Node{n}
+	case yaml.SequenceNode:
+		for _, x := range n.Content {
+			refs = append(refs, resolve(x))
+		}
+	default:
+		l.errorf(n, "include must be a path or list of paths")
+	}
+	for _, ref := range refs {
+		if ref.Kind != yaml.ScalarNode || ref.Value == "" {
+			l.errorf(ref, "include must be a path or list of paths")
+			continue
+		}
+		if l.doc.unexpanded[ref] {
+			continue
+		}
+		path := relativeTo(base, ref.Value)
+		paths, err := includePaths(path)
+		if err != nil {
+			l.errorf(ref, "invalid include: %v", err)
+			continue
+		}
+		if fi, err := os.Stat(path); err == nil && fi.IsDir() {
+			// Watching the directory notices files being added or removed.
+			l.addFile(path)
+		}
+		for _, p := range paths {
+			if !l.addFile(p) {
+				continue
+			}
+			b, err := ioutil.ReadFile(p)
+			if err != nil {
+				l.errorf(ref, "invalid include: %v", err)
+				continue
+			}
+			l.file(p, b)
+		}
+	}
+}
+
+// includePaths returns the config files matching an include path: the file itself, the yaml files
+// within a directory or the files matching a glob pattern, in lexical order.
+func includePaths(path string) ([]string, error) {
+	if strings.ContainsAny(path, `*?[`) {
+		return filepath.Glob(path)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return []string{path}, nil
+	}
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, e := range entries {
+		switch filepath.Ext(e.Name()) {
+		case `.yaml`, `.yml`:
+			if !e.IsDir() {
+				paths = append(paths, filepath.Join(path, e.Name()))
+			}
+		}
+	}
+	sort.Strings(paths)
+	return paths, nil
+}
+
+func relativeTo(base, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(base), path)
+}
+
+// templates registers the stage and step templates of a templates value.
+func (l *loader) templates(n *yaml.Node) {
+	if n.Kind != yaml.MappingNode {
+		l.errorf(n, "expected a map of stages and steps for templates")
+		return
+	}
+	eachKey(n, func(k, v *yaml.Node) {
+		var registry map[string]*yaml.Node
+		var numberKey string
+		switch k.Value {
+		case `stages`:
+			registry, numberKey = l.stages, `stage`
+		case `steps`:
+			registry, numberKey = l.steps, `step`
+		default:
+			l.errorf(k, "unknown key %q in templates, expected stages or steps", k.Value)
+			return
+		}
+		if v.Kind != yaml.MappingNode {
+			l.errorf(v, "expected a map of template names to %s", k.Value)
+			return
+		}
+		eachKey(v, func(name, t *yaml.Node) {
+			switch {
+			case t.Kind != yaml.MappingNode:
+				l.errorf(t, "expected a map for %s template %s", numberKey, name.Value)
+			case value(t, numberKey) != nil:
+				l.errorf(t, "%s template %s cannot set %s", numberKey, name.Value, numberKey)
+			case value(t, TemplateKey) != nil:
+				l.errorf(t, "%s template %s cannot reference another template", numberKey, name.Value)
+			case registry[name.Value] != nil:
+				l.errorf(name, "%s template %s is already defined at %s", numberKey, name.Value, l.position(registry[name.Value]))
+			default:
+				registry[name.Value] = t
+			}
+		})
+	})
+}
+
+// resolve inlines the stageFiles of every stage and instantiates the templates referenced by the pipelines.
+func (l *loader) resolve() {
+	for _, name := range sortedKeys(l.stages) {
+		l.stageFile(l.stages[name])
+	}
+	eachKey(l.doc.Root, func(_, p *yaml.Node) {
+		if p.Kind != yaml.MappingNode {
+			return
+		}
+		procs := value(p, `processors`)
+		if procs == nil || procs.Kind != yaml.SequenceNode {
+			return
+		}
+		for i, x := range procs.Content {
+			stage := resolve(x)
+			if stage.Kind != yaml.MappingNode {
+				continue
+			}
+			l.stageFile(stage)
+			stage = l.instance(stage, `stage`, l.stages)
+			procs.Content[i] = stage
+			steps := value(stage, `steps`)
+			if steps == nil || steps.Kind != yaml.SequenceNode {
+				continue
+			}
+			for j, y := range steps.Content {
+				if step := resolve(y); step.Kind == yaml.MappingNode {
+					steps.Content[j] = l.instance(step, `step`, l.steps)
+				}
+			}
+		}
+	})
+	walk(l.doc.Root, func(n *yaml.Node) {
+		if n.Kind == yaml.ScalarNode && !l.doc.unexpanded[n] && interp.HasParams(n.Value) {
+			l.doc.unexpanded[n] = true
+			l.errorf(n, "template parameter reference outside of a template")
+		}
+	})
+}
+
+// stageFile replaces the stageFile of a stage with the path resolved against the file containing it,
+// adding the steps read from the stageFile to the stage.
+func (l *loader) stageFile(stage *yaml.Node) {
+	n := value(stage, `stageFile`)
+	if n == nil || n.Kind != yaml.ScalarNode || l.doc.unexpanded[n] {
+		return
+	}
+	if value(stage, `steps`) != nil {
+		l.errorf(n, "stage defines both steps and a stageFile")
+		l.doc.unexpanded[n] = true
+		return
+	}
+	path := relativeTo(l.doc.origin[n], n.Value)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		l.errorf(n, "invalid stageFile: %v", err)
+		l.doc.unexpanded[n] = true
+		return
+	}
+	n.Value = path
+	l.addFile(path)
+	steps, _ := l.parse(path, b, func(e *interp.Error) string {
+		return "stageFile key " + e.Key(0)
+	})
+	if steps == nil {
+		return
+	}
+	k := &yaml.Node{Kind: yaml.ScalarNode, Tag: `!!str`, Value: `steps`, Line: n.Line, Column: n.Column}
+	l.doc.origin[k] = l.doc.origin[n]
+	stage.Content = append(stage.Content, k, steps)
+}
+
+// instance returns the stage or step given by ref, instantiating the template it references with its
+// params and overriding the keys of the template with those of ref.
+func (l *loader) instance(ref *yaml.Node, kind string, registry map[string]*yaml.Node) *yaml.Node {
+	nameNode := value(ref, TemplateKey)
+	if nameNode == nil {
+		return ref
+	}
+	out := &yaml.Node{Kind: yaml.MappingNode, Tag: `!!map`, Line: ref.Line, Column: ref.Column}
+	l.doc.origin[out] = l.doc.origin[ref]
+	overrides := make(map[string]bool)
+	eachKey(ref, func(k, v *yaml.Node) {
+		switch k.Value {
+		case TemplateKey, ParamsKey:
+			return
+		case `steps`, `stageFile`:
+			overrides[`steps`], overrides[`stageFile`] = true, true
+		}
+		overrides[k.Value] = true
+		out.Content = append(out.Content, k, v)
+	})
+	tmpl := registry[nameNode.Value]
+	if nameNode.Kind != yaml.ScalarNode || tmpl == nil {
+		l.errorf(nameNode, "unknown %s template %q", kind, nameNode.Value)
+		l.doc.unexpanded[out] = true
+		return out
+	}
+	params, ok := l.params(value(ref, ParamsKey))
+	if !ok {
+		l.doc.unexpanded[out] = true
+		return out
+	}
+	inst := l.copy(tmpl)
+	for _, e := range interp.Params(inst, params) {
+		l.doc.unexpanded[e.Node] = true
+		l.errorf(nameNode, "%s template %s key %s: %v", kind, nameNode.Value, e.Key(0), e.Err)
+	}
+	eachKey(inst, func(k, v *yaml.Node) {
+		if !overrides[k.Value] {
+			out.Content = append(out.Content, k, v)
+		}
+	})
+	return out
+}
+
+// params returns the value nodes of a params map.
+func (l *loader) params(n *yaml.Node) (map[string]*yaml.Node, bool) {
+	params := make(map[string]*yaml.Node)
+	if n == nil {
+		return params, true
+	}
+	if n.Kind != yaml.MappingNode {
+		l.errorf(n, "expected a map of parameter names to values for params")
+		return nil, false
+	}
+	ok := true
+	eachKey(n, func(k, v *yaml.Node) {
+		if l.doc.unexpanded[v] {
+			// Already reported.
+			ok = false
+			return
+		}
+		if v.Kind != yaml.ScalarNode {
+			l.errorf(v, "expected a value for parameter %s", k.Value)
+			ok = false
+			return
+		}
+		params[k.Value] = v
+	})
+	return params, ok
+}
+
+// copy returns a deep copy of the node, keeping the origin of each node.
+func (l *loader) copy(n *yaml.Node) *yaml.Node {
+	cp := *n
+	cp.Content = make([]*yaml.Node, len(n.Content))
+	for i, x := range n.Content {
+		cp.Content[i] = l.copy(x)
+	}
+	l.doc.origin[&cp] = l.doc.origin[n]
+	if l.doc.unexpanded[n] {
+		l.doc.unexpanded[&cp] = true
+	}
+	return &cp
+}
+
+func sortedKeys(m map[string]*yaml.Node) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// eachKey calls fn with each key and value of a mapping.
+func eachKey(n *yaml.Node, fn func(k, v *yaml.Node)) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		fn(n.Content[i], resolve(n.Content[i+1]))
+	}
+}
+
+// value returns the value of the key in a mapping, or nil if not found.
+func value(n *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return resolve(n.Content[i+1])
+		}
+	}
+	return nil
+}
+
+func walk(n *yaml.Node, fn func(*yaml.Node)) {
+	fn(n)
+	for _, x := range n.Content {
+		walk(x, fn)
+	}
+}
+
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
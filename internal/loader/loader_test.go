@@ -0,0 +1,312 @@
+package loader
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "lfm-loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// writeFiles writes each of the files, given by their path relative to dir.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, data := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// lookup returns the value at the path of keys, using [n] for sequence indexes, or nil if not found.
+func lookup(n *yaml.Node, keys ...string) *yaml.Node {
+	for _, k := range keys {
+		if n == nil {
+			return nil
+		}
+		switch {
+		case n.Kind == yaml.SequenceNode && strings.HasPrefix(k, `[`):
+			i, err := strconv.Atoi(k[1 : len(k)-1])
+			if err != nil || i >= len(n.Content) {
+				return nil
+			}
+			n = resolve(n.Content[i])
+		case n.Kind == yaml.MappingNode:
+			n = value(n, k)
+		default:
+			return nil
+		}
+	}
+	return n
+}
+
+func expectErrors(t *testing.T, name string, errs []*Error, want []string) {
+	t.Helper()
+	if len(errs) != len(want) {
+		t.Fatalf("%s: expected %d error(s), got %v", name, len(want), errs)
+	}
+	for i, w := range want {
+		if !strings.Contains(errs[i].Error(), w) {
+			t.Fatalf("%s: expected error containing %q, got %q", name, w, errs[i].Error())
+		}
+	}
+}
+
+func TestLoadExpandsReferences(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	os.Setenv(`LFM_LOADER_BROKER`, `kafka:9092`)
+	defer os.Unsetenv(`LFM_LOADER_BROKER`)
+	writeFiles(t, dir, map[string]string{
+		`secrets/password`:  "null\n",
+		`stages/unset.yaml`: `- step: ${LFM_LOADER_UNSET}`,
+		`stages/steps.yaml`: `
+- step: 0
+  workflow:
+    driver: json
+    method: ${LFM_LOADER_METHOD:-filter}
+`,
+	})
+	for _, x := range []struct {
+		name   string
+		config string
+		errs   []string
+		check  func(*Document) string
+	}{
+		{
+			name: `env and default`,
+			config: `
+logs:
+  sources:
+    - plugin: kafka
+      brokers: ${LFM_LOADER_BROKER}
+      group: ${LFM_LOADER_GROUP:-lfm}
+`,
+			check: func(d *Document) string {
+				in := lookup(d.Root, `logs`, `sources`, `[0]`)
+				if got := value(in, `brokers`).Value + ` ` + value(in, `group`).Value; got != `kafka:9092 lfm` {
+					return got
+				}
+				return ""
+			},
+		},
+		{
+			name: `file relative to config`,
+			config: `
+logs:
+  destinations:
+    - plugin: loki
+      password: ${file:secrets/password}
+`,
+			check: func(d *Document) string {
+				if v := lookup(d.Root, `logs`, `destinations`, `[0]`, `password`); v.Tag != `!!str` || v.Value != `null` {
+					return v.Tag + ` ` + v.Value
+				}
+				return ""
+			},
+		},
+		{
+			name: `stageFile`,
+			config: `
+logs:
+  processors:
+    - stage: 0
+      stageFile: stages/steps.yaml
+`,
+			check: func(d *Document) string {
+				if v := lookup(d.Root, `logs`, `processors`, `[0]`, `steps`, `[0]`, `workflow`, `method`); v == nil || v.Value != `filter` {
+					return "missing method"
+				}
+				return ""
+			},
+		},
+		{
+			name: `unset variable`,
+			config: `
+logs:
+  sources:
+    - plugin: kafka
+      brokers: ${LFM_LOADER_UNSET}
+`,
+			errs: []string{`config.yaml:5:16: pipeline logs key sources[0].brokers: environment variable LFM_LOADER_UNSET is not set`},
+		},
+		{
+			name: `unset variable in stageFile`,
+			config: `
+logs:
+  processors:
+    - stage: 0
+      stageFile: stages/unset.yaml
+`,
+			errs: []string{`unset.yaml:1:9: stageFile key [0].step: environment variable LFM_LOADER_UNSET is not set`},
+		},
+	} {
+		doc, errs := LoadBytes(filepath.Join(dir, `config.yaml`), []byte(x.config))
+		expectErrors(t, x.name, errs, x.errs)
+		if x.check != nil {
+			if got := x.check(doc); got != "" {
+				t.Fatalf("%s: unexpected value %s", x.name, got)
+			}
+		}
+	}
+}
+
+func TestLoadIncludes(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		`config.yaml`:         "include: conf.d/*.yaml\nmain: {}\n",
+		`conf.d/a.yaml`:       "include: ../config.yaml\na: {}\n",
+		`conf.d/b.yaml`:       "include: [nested]\nb: {}\n",
+		`conf.d/c.yml`:        "c: {}\n",
+		`conf.d/nested/d.yml`: "include: ../b.yaml\nd: {}\n",
+		`conf.d/nested/x.txt`: "x: {}\n",
+		`dup.yaml`:            "include: conf.d/a.yaml\na: {}\n",
+		`missing.yaml`:        "include: [none.yaml, conf.d/none*.yaml]\n",
+	})
+	for _, x := range []struct {
+		file      string
+		pipelines []string
+		errs      []string
+	}{
+		{file: `config.yaml`, pipelines: []string{`main`, `a`, `b`, `d`}},
+		{file: `conf.d/nested/d.yml`, pipelines: []string{`d`, `b`}},
+		{file: `dup.yaml`, pipelines: []string{`a`, `main`, `b`, `d`}, errs: []string{`a.yaml:2:1: pipeline a is already defined at`}},
+		{file: `missing.yaml`, errs: []string{`missing.yaml:1:11: invalid include:`, `empty configuration`}},
+	} {
+		doc, errs := Load(filepath.Join(dir, x.file))
+		if x.errs == nil && len(errs) > 0 {
+			t.Fatalf("%s: %v", x.file, errs)
+		}
+		if len(x.errs) > 0 && (len(errs) < 1 || !strings.Contains(errs[0].Error(), x.errs[0])) {
+			t.Fatalf("%s: expected error containing %q, got %v", x.file, x.errs[0], errs)
+		}
+		var got []string
+		eachKey(doc.Root, func(k, _ *yaml.Node) { got = append(got, k.Value) })
+		if strings.Join(got, ` `) != strings.Join(x.pipelines, ` `) {
+			t.Fatalf("%s: expected pipelines %v, got %v", x.file, x.pipelines, got)
+		}
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	writeFiles(t, dir, map[string]string{
+		`steps.yaml`: "- step: 0\n  workflow: {driver: json, method: filter}\n",
+	})
+	templates := `
+templates:
+  stages:
+    normalize:
+      workers: ${param:workers:-2}
+      ordering: ${param:ordering}
+      steps:
+        - template: extract
+          params:
+            path: ${param:path:-message}
+    fromFile:
+      stageFile: steps.yaml
+  steps:
+    extract:
+      workflow:
+        driver: json
+        method: extract
+        fieldActions:
+          - path: ${param:path}
+            label: "${param:path}"
+`
+	for _, x := range []struct {
+		name  string
+		stage string
+		want  map[string]string
+		errs  []string
+	}{
+		{
+			name:  `defaults`,
+			stage: `{stage: 0, template: normalize, params: {ordering: none}}`,
+			want: map[string]string{
+				`workers`:  `!!int 2`,
+				`ordering`: `!!str none`,
+				`steps.[0].workflow.fieldActions.[0].path`: `!!str message`,
+			},
+		},
+		{
+			name:  `params`,
+			stage: `{stage: 0, template: normalize, params: {workers: 4, ordering: key, path: "true"}}`,
+			want: map[string]string{
+				`workers`:  `!!int 4`,
+				`ordering`: `!!str key`,
+				`steps.[0].workflow.fieldActions.[0].path`:  `!!str true`,
+				`steps.[0].workflow.fieldActions.[0].label`: `!!str true`,
+			},
+		},
+		{
+			name:  `overrides`,
+			stage: `{stage: 0, template: normalize, params: {ordering: none}, workers: 8, steps: [{step: 0, workflow: {driver: json, method: filter}}]}`,
+			want: map[string]string{
+				`workers`:                   `!!int 8`,
+				`steps.[0].workflow.method`: `!!str filter`,
+			},
+		},
+		{
+			name:  `steps override stageFile`,
+			stage: `{stage: 0, template: fromFile, steps: [{step: 0, workflow: {driver: json, method: extract}}]}`,
+			want: map[string]string{
+				`steps.[0].workflow.method`: `!!str extract`,
+				`stageFile`:                 ``,
+			},
+		},
+		{
+			name:  `stageFile template`,
+			stage: `{stage: 0, template: fromFile}`,
+			want:  map[string]string{`steps.[0].workflow.method`: `!!str filter`},
+		},
+		{
+			name:  `missing param`,
+			stage: `{stage: 0, template: normalize}`,
+			errs:  []string{`stage template normalize key ordering: parameter ordering is not set`},
+		},
+		{
+			name:  `unknown template`,
+			stage: `{stage: 0, template: missing}`,
+			errs:  []string{`unknown stage template "missing"`},
+		},
+		{
+			name:  `steps and stageFile`,
+			stage: `{stage: 0, stageFile: steps.yaml, steps: []}`,
+			errs:  []string{`stage defines both steps and a stageFile`},
+		},
+	} {
+		config := templates + "logs:\n  processors:\n    - " + x.stage + "\n"
+		doc, errs := LoadBytes(filepath.Join(dir, `config.yaml`), []byte(config))
+		expectErrors(t, x.name, errs, x.errs)
+		stage := lookup(doc.Root, `logs`, `processors`, `[0]`)
+		for path, want := range x.want {
+			v := lookup(stage, strings.Split(path, `.`)...)
+			var got string
+			if v != nil {
+				got = v.ShortTag() + ` ` + v.Value
+			}
+			if got != want {
+				t.Fatalf("%s: expected %s to be %q, got %q", x.name, path, want, got)
+			}
+		}
+	}
+}
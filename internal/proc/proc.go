@@ -0,0 +1,448 @@
+// Package proc supervises child processes which exchange length-prefixed JSON Messages over stdin and stdout.
+//
+// Each frame is a 4 byte big-endian length followed by a JSON encoded Message of that length.
+// Message data is base64 encoded, as with any JSON encoded []byte.
+//
+// Calls are matched to their responses using the Message ID, allowing a child to answer calls in any order.
+// Messages received from the child without a matching call are passed to the handler given to Start.
+package proc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// MaxFrameSize is the largest frame accepted from a child process.
+const MaxFrameSize = 64 << 20
+
+// Config defaults.
+const (
+	defaultTimeout    = 10 * time.Second
+	defaultMinBackoff = time.Second
+	defaultMaxBackoff = 30 * time.Second
+	defaultStopGrace  = 5 * time.Second
+	defaultMaxTimeout = 3
+)
+
+// Errors returned by a Process.
+var (
+	ErrTimeout = errors.New("timed out waiting for process")
+	ErrExited  = errors.New("process exited")
+	ErrStopped = errors.New("process stopped")
+)
+
+// Message Types:
+const (
+	TypeProcess = `process`
+	TypeEvent   = `event`
+	TypeAck     = `ack`
+)
+
+// Message is the JSON object exchanged with a child process.
+type Message struct {
+	ID    uint64                 `json:"id"`
+	Type  string                 `json:"type,omitempty"`
+	Data  []byte                 `json:"data,omitempty"`
+	Tags  map[string]string      `json:"tags,omitempty"`
+	Vars  map[string]interface{} `json:"vars,omitempty"`
+	Error string                 `json:"error,omitempty"`
+}
+
+// Config contains the details for launching and supervising a child process.
+// The Timeout bounds writing a Message and receiving the response to a Call. A process which fails to read
+// a Message within the Timeout, or to respond to MaxTimeouts consecutive Calls, is killed and restarted.
+type Config struct {
+	Command     []string          `yaml:"command" json:"command"`
+	Env         map[string]string `yaml:"env" json:"env"`
+	Dir         string            `yaml:"dir" json:"dir"`
+	Timeout     time.Duration     `yaml:"timeout" json:"timeout"`
+	MaxTimeouts int               `yaml:"maxTimeouts" json:"maxTimeouts"`
+	MinBackoff  time.Duration     `yaml:"minBackoff" json:"minBackoff"`
+	MaxBackoff  time.Duration     `yaml:"maxBackoff" json:"maxBackoff"`
+}
+
+// Validate assigns any defaults and returns an error if the Config is invalid.
+func (c *Config) Validate() error {
+	if len(c.Command) < 1 || c.Command[0] == "" {
+		return fmt.Errorf("missing or invalid command")
+	}
+	if c.Timeout <= 0 {
+		c.Timeout = defaultTimeout
+	}
+	if c.MaxTimeouts <= 0 {
+		c.MaxTimeouts = defaultMaxTimeout
+	}
+	if c.MinBackoff <= 0 {
+		c.MinBackoff = defaultMinBackoff
+	}
+	if c.MaxBackoff <= 0 {
+		c.MaxBackoff = defaultMaxBackoff
+	}
+	return nil
+}
+
+// WriteFrame writes the Message to w as a single frame.
+func WriteFrame(w io.Writer, m Message) error {
+	b, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4+len(b))
+	binary.BigEndian.PutUint32(buf, uint32(len(b)))
+	copy(buf[4:], b)
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadFrame reads a single frame from r and returns the decoded Message.
+func ReadFrame(r io.Reader) (Message, error) {
+	var m Message
+	var size [4]byte
+	if _, err := io.ReadFull(r, size[:]); err != nil {
+		return m, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > MaxFrameSize {
+		return m, fmt.Errorf("frame of %d bytes exceeds max size of %d", n, MaxFrameSize)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return m, fmt.Errorf("invalid frame: %w", err)
+	}
+	return m, nil
+}
+
+// Process is a supervised child process, restarted with an exponential backoff whenever it exits.
+type Process struct {
+	cfg      Config
+	handler  func(Message)
+	errs     chan error
+	lock     sync.Mutex
+	wlock    sync.Mutex
+	cmd      *exec.Cmd
+	stdin    *os.File
+	timeouts int
+	pending  map[uint64]chan Message
+	nextID   uint64
+	stopChan chan struct{}
+	stopped  bool
+	wg       sync.WaitGroup
+}
+
+// Start launches and supervises the child process described by the Config.
+// The handler, if not nil, receives any Message not matching a pending Call.
+func Start(cfg Config, handler func(Message)) (*Process, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	p := &Process{
+		cfg:      cfg,
+		handler:  handler,
+		errs:     make(chan error, 100),
+		pending:  make(map[uint64]chan Message),
+		stopChan: make(chan struct{}),
+	}
+	started := make(chan error, 1)
+	p.wg.Add(1)
+	go p.supervise(started)
+	if err := <-started; err != nil {
+		p.Stop()
+		return nil, err
+	}
+	return p, nil
+}
+
+// supervise runs the child process until stopped, restarting it whenever it exits.
+// The result of the first launch is sent to started.
+func (p *Process) supervise(started chan<- error) {
+	defer p.wg.Done()
+	backoff := p.cfg.MinBackoff
+	for {
+		began := time.Now()
+		done, err := p.launch()
+		if started != nil {
+			started <- err
+			started = nil
+			if err != nil {
+				return
+			}
+		}
+		if err == nil {
+			err = <-done
+			p.failPending(ErrExited)
+			if time.Since(began) > p.cfg.MaxBackoff {
+				backoff = p.cfg.MinBackoff
+			}
+		}
+		select {
+		case <-p.stopChan:
+			return
+		default:
+		}
+		p.error(fmt.Errorf("process %s exited, restarting in %v: %v", p.cfg.Command[0], backoff, err))
+		select {
+		case <-p.stopChan:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > p.cfg.MaxBackoff {
+			backoff = p.cfg.MaxBackoff
+		}
+	}
+}
+
+// launch starts the child process, returning a channel receiving the result once it exits.
+func (p *Process) launch() (<-chan error, error) {
+	cmd := exec.Command(p.cfg.Command[0], p.cfg.Command[1:]...)
+	cmd.Dir = p.cfg.Dir
+	cmd.Stderr = os.Stderr
+	cmd.Env = os.Environ()
+	for k, v := range p.cfg.Env {
+		cmd.Env = append(cmd.Env, k+`=`+v)
+	}
+	// stdin is an os.Pipe rather than cmd.StdinPipe, allowing write deadlines.
+	r, stdin, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stdin = r
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		r.Close()
+		stdin.Close()
+		return nil, err
+	}
+	err = cmd.Start()
+	r.Close()
+	if err != nil {
+		stdin.Close()
+		return nil, fmt.Errorf("could not start process %s: %w", p.cfg.Command[0], err)
+	}
+	p.lock.Lock()
+	p.cmd, p.stdin, p.timeouts = cmd, stdin, 0
+	p.lock.Unlock()
+	done := make(chan error, 1)
+	go func() {
+		r := bufio.NewReader(stdout)
+		var err error
+		for {
+			var m Message
+			m, err = ReadFrame(r)
+			if err != nil {
+				break
+			}
+			p.dispatch(m)
+		}
+		if err != io.EOF {
+			p.error(fmt.Errorf("process %s read error: %w", p.cfg.Command[0], err))
+			cmd.Process.Kill()
+		}
+		err = cmd.Wait()
+		p.lock.Lock()
+		if p.stdin == stdin {
+			p.stdin = nil
+		}
+		p.lock.Unlock()
+		stdin.Close()
+		done <- err
+	}()
+	return done, nil
+}
+
+// dispatch delivers the Message to its pending Call, or to the handler.
+func (p *Process) dispatch(m Message) {
+	p.lock.Lock()
+	ch, ok := p.pending[m.ID]
+	if ok {
+		delete(p.pending, m.ID)
+	}
+	p.lock.Unlock()
+	switch {
+	case ok:
+		ch <- m
+	case p.handler != nil:
+		p.handler(m)
+	}
+}
+
+// failPending fails all pending Calls with the given error.
+func (p *Process) failPending(err error) {
+	p.lock.Lock()
+	for id, ch := range p.pending {
+		ch <- Message{ID: id, Error: err.Error()}
+		delete(p.pending, id)
+	}
+	p.lock.Unlock()
+}
+
+// Send writes the Message to the child process without waiting for a response.
+// ErrTimeout is returned if the Message is not written within the configured Timeout.
+func (p *Process) Send(m Message) error {
+	return p.send(m, time.Now().Add(p.cfg.Timeout))
+}
+
+// send writes the Message to the child process, returning ErrTimeout if it is not written by the deadline.
+// A timed out write may leave a partial frame, so the process is killed and restarted.
+func (p *Process) send(m Message, deadline time.Time) error {
+	p.lock.Lock()
+	cmd, stdin, stopped := p.cmd, p.stdin, p.stopped
+	p.lock.Unlock()
+	switch {
+	case stopped:
+		return ErrStopped
+	case stdin == nil:
+		return ErrExited
+	}
+	p.wlock.Lock()
+	defer p.wlock.Unlock()
+	stdin.SetWriteDeadline(deadline)
+	err := WriteFrame(stdin, m)
+	if os.IsTimeout(err) {
+		p.kill(cmd, fmt.Errorf("process %s did not read a message within %v, killing", p.cfg.Command[0], p.cfg.Timeout))
+		return ErrTimeout
+	}
+	return err
+}
+
+// Call sends the Message with a new ID and waits up to the configured Timeout for the response.
+// A response with an Error is returned as an error.
+func (p *Process) Call(m Message) (Message, error) {
+	type result struct {
+		m   Message
+		err error
+	}
+	ch := make(chan result, 1)
+	p.CallAsync(m, func(m Message, err error) {
+		ch <- result{m, err}
+	})
+	r := <-ch
+	return r.m, r.err
+}
+
+// CallAsync sends the Message with a new ID before returning, calling done with the response
+// or an error once received or the configured Timeout is exceeded.
+// The Timeout starts before the Message is written. Messages are written in the order CallAsync is called.
+func (p *Process) CallAsync(m Message, done func(Message, error)) {
+	deadline := time.Now().Add(p.cfg.Timeout)
+	ch := make(chan Message, 1)
+	p.lock.Lock()
+	p.nextID++
+	m.ID = p.nextID
+	p.pending[m.ID] = ch
+	cmd := p.cmd
+	p.lock.Unlock()
+	if err := p.send(m, deadline); err != nil {
+		p.cancel(m.ID)
+		done(Message{}, err)
+		return
+	}
+	go func(id uint64) {
+		timer := time.NewTimer(time.Until(deadline))
+		defer timer.Stop()
+		select {
+		case resp := <-ch:
+			p.lock.Lock()
+			p.timeouts = 0
+			p.lock.Unlock()
+			if resp.Error != "" {
+				done(resp, errors.New(resp.Error))
+				return
+			}
+			done(resp, nil)
+		case <-timer.C:
+			p.cancel(id)
+			p.timedOut(cmd)
+			done(Message{}, ErrTimeout)
+		}
+	}(m.ID)
+}
+
+// timedOut records a Call to the given process which timed out, killing the process
+// once MaxTimeouts consecutive Calls have timed out.
+func (p *Process) timedOut(cmd *exec.Cmd) {
+	p.lock.Lock()
+	if p.cmd != cmd {
+		// the process was already restarted.
+		p.lock.Unlock()
+		return
+	}
+	p.timeouts++
+	n := p.timeouts
+	if n >= p.cfg.MaxTimeouts {
+		p.timeouts = 0
+	}
+	p.lock.Unlock()
+	if n >= p.cfg.MaxTimeouts {
+		p.kill(cmd, fmt.Errorf("process %s timed out responding to %d consecutive calls, killing", p.cfg.Command[0], n))
+	}
+}
+
+// kill reports the error and kills the given process, which is then restarted.
+func (p *Process) kill(cmd *exec.Cmd, err error) {
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	p.error(err)
+	cmd.Process.Kill()
+}
+
+func (p *Process) cancel(id uint64) {
+	p.lock.Lock()
+	delete(p.pending, id)
+	p.lock.Unlock()
+}
+
+func (p *Process) error(err error) {
+	select {
+	case p.errs <- err:
+	default:
+	}
+}
+
+// Stop closes the stdin of the child process, allowing it to exit, and kills it if still running after a grace period.
+func (p *Process) Stop() error {
+	p.lock.Lock()
+	if p.stopped {
+		p.lock.Unlock()
+		return nil
+	}
+	p.stopped = true
+	close(p.stopChan)
+	cmd, stdin := p.cmd, p.stdin
+	p.lock.Unlock()
+	if stdin != nil {
+		stdin.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(defaultStopGrace):
+		if cmd != nil && cmd.Process != nil {
+			cmd.Process.Kill()
+		}
+		<-done
+	}
+	p.failPending(ErrStopped)
+	return nil
+}
+
+// Errors returns the channel receiving errors encountered while supervising the process.
+func (p *Process) Errors() <-chan error {
+	return p.errs
+}
@@ -0,0 +1,160 @@
+package proc
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// helperEnv marks the test binary as running as a helper child process.
+const helperEnv = `LFM_HELPER_PROCESS`
+
+// helperConfig returns a Config running the test binary as a helper child process using the given mode.
+func helperConfig(mode string) Config {
+	return Config{
+		Command:    []string{os.Args[0], `-test.run=TestHelperProcess`, `--`, mode},
+		Env:        map[string]string{helperEnv: `1`},
+		Timeout:    5 * time.Second,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	}
+}
+
+// TestHelperProcess is run as the child process by the other tests. Each child sends a started event,
+// then depending on the mode: echo responds to each call with its data, exit responds to a single call
+// before exiting, silent reads calls without responding and blocked waits before reading calls.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv(helperEnv) != `1` {
+		return
+	}
+	defer os.Exit(0)
+	mode := os.Args[len(os.Args)-1]
+	w := bufio.NewWriter(os.Stdout)
+	WriteFrame(w, Message{Type: TypeEvent, Data: []byte(`started`)})
+	w.Flush()
+	if mode == `blocked` {
+		time.Sleep(time.Second)
+		mode = `silent`
+	}
+	r := bufio.NewReader(os.Stdin)
+	for {
+		m, err := ReadFrame(r)
+		if err != nil {
+			return
+		}
+		if mode == `silent` {
+			continue
+		}
+		resp := Message{ID: m.ID, Data: bytes.ToUpper(m.Data), Tags: m.Tags}
+		if string(m.Data) == `fail` {
+			resp.Error = `failed`
+		}
+		WriteFrame(w, resp)
+		w.Flush()
+		if mode == `exit` {
+			return
+		}
+	}
+}
+
+// startHelper starts a helper child process, returning it with a counter of the times it was started.
+func startHelper(t *testing.T, cfg Config) (*Process, *int32) {
+	t.Helper()
+	started := new(int32)
+	p, err := Start(cfg, func(m Message) {
+		if m.Type == TypeEvent && string(m.Data) == `started` {
+			atomic.AddInt32(started, 1)
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, started
+}
+
+func waitStarted(t *testing.T, started *int32, n int32) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(started) < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the process to be started %d time(s), started %d", n, atomic.LoadInt32(started))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestCall(t *testing.T) {
+	p, _ := startHelper(t, helperConfig(`echo`))
+	defer p.Stop()
+	for _, x := range []struct {
+		data string
+		want string
+		err  string
+	}{
+		{data: `hello`, want: `HELLO`},
+		{data: `fail`, err: `failed`},
+		{data: `world`, want: `WORLD`},
+	} {
+		resp, err := p.Call(Message{Type: TypeProcess, Data: []byte(x.data), Tags: map[string]string{`app`: `test`}})
+		switch {
+		case x.err != "":
+			if err == nil || err.Error() != x.err {
+				t.Fatalf("%s: expected error %s, got %v", x.data, x.err, err)
+			}
+		case err != nil:
+			t.Fatalf("%s: %v", x.data, err)
+		case string(resp.Data) != x.want || resp.Tags[`app`] != `test`:
+			t.Fatalf("%s: expected %s with tags, got %s with %v", x.data, x.want, resp.Data, resp.Tags)
+		}
+	}
+}
+
+func TestCallTimeoutRestarts(t *testing.T) {
+	cfg := helperConfig(`silent`)
+	cfg.Timeout = 200 * time.Millisecond
+	cfg.MaxTimeouts = 2
+	p, started := startHelper(t, cfg)
+	defer p.Stop()
+	waitStarted(t, started, 1)
+	for i := 0; i < cfg.MaxTimeouts; i++ {
+		if _, err := p.Call(Message{Type: TypeProcess, Data: []byte(`data`)}); !errors.Is(err, ErrTimeout) {
+			t.Fatalf("expected %v, got %v", ErrTimeout, err)
+		}
+	}
+	waitStarted(t, started, 2)
+}
+
+func TestSendTimeoutRestarts(t *testing.T) {
+	cfg := helperConfig(`blocked`)
+	cfg.Timeout = 200 * time.Millisecond
+	p, started := startHelper(t, cfg)
+	defer p.Stop()
+	waitStarted(t, started, 1)
+	// larger than the pipe buffer, so the write blocks while the process is not reading.
+	data := make([]byte, 1<<20)
+	begin := time.Now()
+	if _, err := p.Call(Message{Type: TypeProcess, Data: data}); !errors.Is(err, ErrTimeout) {
+		t.Fatalf("expected %v, got %v", ErrTimeout, err)
+	}
+	if elapsed := time.Since(begin); elapsed > time.Second {
+		t.Fatalf("expected the call to time out within the timeout, took %v", elapsed)
+	}
+	waitStarted(t, started, 2)
+}
+
+func TestRestartAfterExit(t *testing.T) {
+	p, started := startHelper(t, helperConfig(`exit`))
+	defer p.Stop()
+	waitStarted(t, started, 1)
+	if resp, err := p.Call(Message{Type: TypeProcess, Data: []byte(`first`)}); err != nil || string(resp.Data) != `FIRST` {
+		t.Fatalf("expected FIRST, got %s: %v", resp.Data, err)
+	}
+	waitStarted(t, started, 2)
+	if resp, err := p.Call(Message{Type: TypeProcess, Data: []byte(`second`)}); err != nil || string(resp.Data) != `SECOND` {
+		t.Fatalf("expected SECOND after restarting, got %s: %v", resp.Data, err)
+	}
+}
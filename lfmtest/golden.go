@@ -0,0 +1,168 @@
+package lfmtest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/jbvmio/lfm"
+)
+
+// Golden file names used within a test directory.
+const (
+	InputFile    = `input.txt`
+	ExpectedFile = `expected.jsonl`
+)
+
+// UpdateEnv is the environment variable which, when set to 1, writes the expected file from the outputs instead of comparing.
+const UpdateEnv = `LFMTEST_UPDATE`
+
+// Golden runs each line of the input file in dir through the named pipeline and compares the outputs with the
+// expected file, failing the test with a diff on mismatch. Outputs are compared as JSON where valid, ignoring
+// key order, and sorted so the order in which Events complete does not matter.
+func Golden(t testing.TB, cfgs lfm.Configs, name, dir string) {
+	t.Helper()
+	lines, err := readLines(filepath.Join(dir, InputFile))
+	if err != nil {
+		t.Fatalf("error reading input: %v", err)
+	}
+	r, err := Run(cfgs, name, lines)
+	if err != nil {
+		t.Fatalf("error running pipeline %s: %v", name, err)
+	}
+	for _, err := range r.Errors {
+		t.Logf("pipeline %s: %v", name, err)
+	}
+	expectedPath := filepath.Join(dir, ExpectedFile)
+	if os.Getenv(UpdateEnv) == `1` {
+		var b bytes.Buffer
+		for _, line := range normalize(r.Outputs) {
+			b.WriteString(line + "\n")
+		}
+		if err := ioutil.WriteFile(expectedPath, b.Bytes(), 0644); err != nil {
+			t.Fatalf("error updating expected: %v", err)
+		}
+		return
+	}
+	expected, err := readLines(expectedPath)
+	if err != nil {
+		t.Fatalf("error reading expected: %v", err)
+	}
+	if diff := Diff(expected, r.Outputs); diff != "" {
+		t.Errorf("pipeline %s output mismatch for %s (-expected +actual):\n%s", name, dir, diff)
+		for _, err := range r.Failed {
+			t.Logf("failed event: %v", err)
+		}
+	}
+}
+
+// GoldenDirs runs Golden as a subtest for each directory within root containing an input file.
+func GoldenDirs(t *testing.T, cfgs lfm.Configs, name, root string) {
+	t.Helper()
+	entries, err := ioutil.ReadDir(root)
+	if err != nil {
+		t.Fatalf("error reading %s: %v", root, err)
+	}
+	var found bool
+	for _, e := range entries {
+		dir := filepath.Join(root, e.Name())
+		if _, err := os.Stat(filepath.Join(dir, InputFile)); !e.IsDir() || err != nil {
+			continue
+		}
+		found = true
+		t.Run(e.Name(), func(t *testing.T) {
+			Golden(t, cfgs, name, dir)
+		})
+	}
+	if !found {
+		t.Fatalf("no directories containing %s found in %s", InputFile, root)
+	}
+}
+
+// Diff compares the expected and actual lines, returning a line diff or an empty string if they match.
+// Lines are compared as JSON where valid, ignoring key order, and sorted before comparing.
+func Diff(expected, actual []string) string {
+	a, b := normalize(expected), normalize(actual)
+	// Longest common subsequence of the sorted lines.
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			switch {
+			case a[i] == b[j]:
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			case lcs[i+1][j] >= lcs[i][j+1]:
+				lcs[i][j] = lcs[i+1][j]
+			default:
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var diff strings.Builder
+	var changed bool
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			fmt.Fprintf(&diff, "  %s\n", a[i])
+			i++
+			j++
+		case i < len(a) && (j == len(b) || lcs[i+1][j] >= lcs[i][j+1]):
+			fmt.Fprintf(&diff, "- %s\n", a[i])
+			changed = true
+			i++
+		default:
+			fmt.Fprintf(&diff, "+ %s\n", b[j])
+			changed = true
+			j++
+		}
+	}
+	if !changed {
+		return ""
+	}
+	return diff.String()
+}
+
+// normalize returns the sorted lines, re-encoding valid JSON with sorted keys.
+func normalize(lines []string) []string {
+	out := make([]string, 0, len(lines))
+	for _, line := range lines {
+		dec := json.NewDecoder(strings.NewReader(line))
+		dec.UseNumber()
+		var x interface{}
+		if err := dec.Decode(&x); err == nil && !dec.More() {
+			if b, err := json.Marshal(x); err == nil {
+				line = string(b)
+			}
+		}
+		out = append(out, line)
+	}
+	sort.Strings(out)
+	return out
+}
+
+func readLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines, scanner.Err()
+}
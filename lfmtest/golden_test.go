@@ -0,0 +1,107 @@
+package lfmtest_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jbvmio/lfm"
+	"github.com/jbvmio/lfm/lfmtest"
+)
+
+func loadConfigs(t *testing.T) lfm.Configs {
+	t.Helper()
+	cfgs, _, err := lfm.LoadConfig(`testdata/pipeline.yaml`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfgs
+}
+
+func TestGolden(t *testing.T) {
+	lfmtest.GoldenDirs(t, loadConfigs(t), `example`, `testdata/golden`)
+}
+
+func ExampleDiff() {
+	expected := []string{`{"a":1,"b":2}`, `second`, `third`}
+	actual := []string{`third`, `{"b":2,"a":1}`, `fourth`}
+	fmt.Print(lfmtest.Diff(expected, actual))
+	// Output:
+	// - second
+	// + fourth
+	//   third
+	//   {"a":1,"b":2}
+}
+
+func TestDiffNormalizes(t *testing.T) {
+	expected := []string{`{"a":1,"b":{"c":[1,2]}}`, `plain text`, `{"n":1.50}`}
+	actual := []string{`plain text`, `{"n":1.50}`, `{"b":{"c":[1,2]},"a":1}`}
+	if diff := lfmtest.Diff(expected, actual); diff != "" {
+		t.Fatalf("expected no diff, got:\n%s", diff)
+	}
+	if diff := lfmtest.Diff(expected, actual[:2]); diff == "" {
+		t.Fatal("expected a diff for a missing line")
+	}
+}
+
+func TestRun(t *testing.T) {
+	r, err := lfmtest.Run(loadConfigs(t), `example`, []string{`one`, `two`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`{"env":"test","src":"one"}`, `{"env":"test","src":"two"}`}
+	if diff := lfmtest.Diff(want, r.Outputs); diff != "" {
+		t.Fatalf("unexpected outputs:\n%s", diff)
+	}
+	if len(r.Failed) > 0 {
+		t.Fatalf("expected no failed events, got %v", r.Failed)
+	}
+	if _, err := lfmtest.Run(loadConfigs(t), `missing`, nil); err == nil {
+		t.Fatal("expected an error for a missing pipeline")
+	}
+}
+
+// runPipeline runs the Input through the metadata pipeline into the Output using the Delivery options.
+func runPipeline(t *testing.T, in *lfmtest.Input, out *lfmtest.Output, delivery lfm.Delivery) {
+	t.Helper()
+	c := loadConfigs(t)[`metadata`]
+	c.Sources, c.Destinations = nil, nil
+	p, err := lfm.NewPipeline(`metadata`, c, lfm.WithInputs(in), lfm.WithOutputs(out))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.Deliveries = []lfm.Delivery{delivery}
+	p.Run(make(chan error, 100))
+	defer p.Stop()
+	if !in.Wait(5 * time.Second) {
+		t.Fatal("events were not acknowledged")
+	}
+}
+
+func TestInputMetadata(t *testing.T) {
+	in, out := lfmtest.NewInput(`one`), lfmtest.NewOutput()
+	in.Metadata = map[string]string{`source`: `unit`}
+	runPipeline(t, in, out, lfm.Delivery{})
+	if diff := lfmtest.Diff([]string{`{"source":"unit","src":"one"}`}, out.Lines()); diff != "" {
+		t.Fatalf("unexpected outputs:\n%s", diff)
+	}
+}
+
+func TestOutputErr(t *testing.T) {
+	in, out := lfmtest.NewInput(`one`, `two`), lfmtest.NewOutput()
+	out.Err = errors.New("unavailable")
+	// The default block policy retries until delivered.
+	runPipeline(t, in, out, lfm.Delivery{
+		Policy:     lfm.PolicyDeadLetter,
+		Retries:    1,
+		MinBackoff: time.Millisecond,
+		MaxBackoff: time.Millisecond,
+	})
+	if lines := out.Lines(); len(lines) > 0 {
+		t.Fatalf("expected no delivered lines, got %v", lines)
+	}
+	if failed := in.Failed(); len(failed) != 2 {
+		t.Fatalf("expected 2 failed events, got %v", failed)
+	}
+}
@@ -0,0 +1,166 @@
+// Package lfmtest helps test lfm pipeline configurations, running sample lines through the processors
+// of a pipeline using in-memory Input and Output Plugins and comparing the outputs with golden files.
+package lfmtest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/jbvmio/lfm/plugin"
+)
+
+// Input is an in-memory Input Plugin providing each of its lines as an Event.
+// Metadata, if set before starting, is provided with every Event.
+type Input struct {
+	Metadata map[string]string
+	lines    []string
+	data     chan plugin.Event
+	errs     chan error
+	stopChan chan struct{}
+	pending  sync.WaitGroup
+	lock     sync.Mutex
+	failed   []error
+}
+
+// NewInput returns an Input providing the given lines.
+func NewInput(lines ...string) *Input {
+	in := &Input{
+		lines:    lines,
+		data:     make(chan plugin.Event),
+		errs:     make(chan error),
+		stopChan: make(chan struct{}),
+	}
+	in.pending.Add(len(lines))
+	return in
+}
+
+// Start starts the plugin.
+func (in *Input) Start() error {
+	go func() {
+		for _, line := range in.lines {
+			e := plugin.NewEvent([]byte(line), in.ack)
+			e.Metadata = in.Metadata
+			select {
+			case <-in.stopChan:
+				return
+			case in.data <- e:
+			}
+		}
+	}()
+	return nil
+}
+
+func (in *Input) ack(err error) {
+	if err != nil {
+		in.lock.Lock()
+		in.failed = append(in.failed, err)
+		in.lock.Unlock()
+	}
+	in.pending.Done()
+}
+
+// Wait waits up to the timeout for all Events to be acknowledged, returning false if the timeout was exceeded.
+func (in *Input) Wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		in.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// Failed returns the errors Events were acknowledged with.
+func (in *Input) Failed() []error {
+	in.lock.Lock()
+	defer in.lock.Unlock()
+	return append([]error(nil), in.failed...)
+}
+
+// Stop stops the plugin.
+func (in *Input) Stop() error {
+	close(in.stopChan)
+	return nil
+}
+
+// Source returns the channel of Events provided by the Input.
+func (in *Input) Source() <-chan plugin.Event {
+	return in.data
+}
+
+// Errors returns the error channel for the Input Plugin.
+func (in *Input) Errors() <-chan error {
+	return in.errs
+}
+
+// Output is an in-memory Output Plugin recording the data of each Event received.
+// Events are acknowledged with Err, allowing delivery failures to be tested using a Delivery policy
+// other than block, which retries until delivered.
+type Output struct {
+	Err      error
+	data     chan plugin.Event
+	errs     chan error
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+	lock     sync.Mutex
+	lines    []string
+}
+
+// NewOutput returns a new Output.
+func NewOutput() *Output {
+	return &Output{
+		data:     make(chan plugin.Event),
+		errs:     make(chan error),
+		stopChan: make(chan struct{}),
+	}
+}
+
+// Start starts the plugin.
+func (out *Output) Start() error {
+	out.wg.Add(1)
+	go func() {
+		defer out.wg.Done()
+		for {
+			select {
+			case <-out.stopChan:
+				return
+			case e := <-out.data:
+				if out.Err == nil {
+					out.lock.Lock()
+					out.lines = append(out.lines, string(e.Data))
+					out.lock.Unlock()
+				}
+				e.Ack(out.Err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Lines returns the data of each Event delivered, in the order received.
+func (out *Output) Lines() []string {
+	out.lock.Lock()
+	defer out.lock.Unlock()
+	return append([]string(nil), out.lines...)
+}
+
+// Stop stops the plugin.
+func (out *Output) Stop() error {
+	close(out.stopChan)
+	out.wg.Wait()
+	return nil
+}
+
+// Destination returns the channel used for accept data to the intended Plugin destination.
+func (out *Output) Destination() chan<- plugin.Event {
+	return out.data
+}
+
+// Errors returns the error channel for the Output Plugin.
+func (out *Output) Errors() <-chan error {
+	return out.errs
+}
@@ -0,0 +1,51 @@
+package lfmtest
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jbvmio/lfm"
+)
+
+// Timeout is the time allowed for all lines to complete a pipeline when using Run.
+var Timeout = 10 * time.Second
+
+// Result holds the outcome of running lines through a pipeline.
+type Result struct {
+	// Outputs contains the data delivered to the Output, in the order received.
+	Outputs []string
+	// Failed contains the errors of Events which failed processing or delivery.
+	Failed []error
+	// Errors contains any other errors reported by the pipeline.
+	Errors []error
+}
+
+// Run runs the lines through the processors of the named pipeline of the Configs and returns the Result
+// once every line has completed. In-memory plugins are used in place of the sources and destinations,
+// and any deadLetter or queue of the pipeline is not used.
+func Run(cfgs lfm.Configs, name string, lines []string) (Result, error) {
+	var r Result
+	c, ok := cfgs[name]
+	if !ok {
+		return r, fmt.Errorf("no pipeline named %s", name)
+	}
+	c.Sources, c.Destinations, c.DeadLetter, c.Queue = nil, nil, nil, nil
+	in, out := NewInput(lines...), NewOutput()
+	p, err := lfm.NewPipeline(name, c, lfm.WithInputs(in), lfm.WithOutputs(out))
+	if err != nil {
+		return r, err
+	}
+	errs := make(chan error, 1000)
+	p.Run(errs)
+	done := in.Wait(Timeout)
+	p.Stop()
+	r.Outputs = out.Lines()
+	r.Failed = in.Failed()
+	for len(errs) > 0 {
+		r.Errors = append(r.Errors, <-errs)
+	}
+	if !done {
+		return r, fmt.Errorf("pipeline %s did not complete all %d line(s) within %v", name, len(lines), Timeout)
+	}
+	return r, nil
+}
@@ -0,0 +1,266 @@
+package lfm
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+
+	"github.com/jbvmio/lfm/driver"
+	"github.com/jbvmio/lfm/plugin"
+	"github.com/jbvmio/lfm/queue"
+
+	// Built-in Drivers and Plugins:
+	_ "github.com/jbvmio/lfm/driver/builtin"
+	_ "github.com/jbvmio/lfm/plugin/builtin"
+)
+
+// loadInputs loads Input Plugins. If any Input fails to load, those already loaded are stopped.
+func loadInputs(cfg Configs) (inputs map[string][]plugin.Input, err error) {
+	inputs = make(map[string][]plugin.Input)
+	if len(cfg) < 1 {
+		return nil, fmt.Errorf("invalid config")
+	}
+	for k, v := range cfg {
+		var ins []plugin.Input
+		for _, input := range v.Sources {
+			p, err := pluginName(input)
+			if err != nil {
+				stopPlugins(append(flattenInputs(inputs), ins...), nil, nil)
+				return nil, fmt.Errorf("error loading input for %s: %v", k, err)
+			}
+			in, err := loadInputPlugin(k, p, input)
+			if err != nil {
+				stopPlugins(append(flattenInputs(inputs), ins...), nil, nil)
+				return nil, fmt.Errorf("error loading input: %v", err)
+			}
+			ins = append(ins, in)
+		}
+		inputs[k] = append(inputs[k], ins...)
+	}
+	return
+}
+
+// loadOutputs loads Output Plugins. If any Output fails to load, those already loaded are stopped.
+func loadOutputs(cfg Configs) (outputs map[string][]plugin.Output, err error) {
+	outputs = make(map[string][]plugin.Output)
+	if len(cfg) < 1 {
+		return nil, fmt.Errorf("invalid config")
+	}
+	for k, v := range cfg {
+		var outs []plugin.Output
+		for _, output := range v.Destinations {
+			p, err := pluginName(output)
+			if err != nil {
+				stopPlugins(nil, append(flattenOutputs(outputs), outs...), nil)
+				return nil, fmt.Errorf("error loading output for %s: %v", k, err)
+			}
+			out, err := loadOutputPlugin(p, output)
+			if err != nil {
+				stopPlugins(nil, append(flattenOutputs(outputs), outs...), nil)
+				return nil, fmt.Errorf("error loading output: %v", err)
+			}
+			outs = append(outs, out)
+		}
+		outputs[k] = append(outputs[k], outs...)
+	}
+	return
+}
+
+// loadDeadLetters loads the dead letter Output Plugins for each configuration defining one.
+// If any fails to load, those already loaded are stopped.
+func loadDeadLetters(cfg Configs) (outputs map[string]plugin.Output, err error) {
+	outputs = make(map[string]plugin.Output)
+	for k, v := range cfg {
+		if len(v.DeadLetter) < 1 {
+			continue
+		}
+		p, err := pluginName(v.DeadLetter)
+		if err == nil {
+			outputs[k], err = loadOutputPlugin(p, v.DeadLetter)
+		}
+		if err != nil {
+			for _, out := range outputs {
+				if out != nil {
+					out.Stop()
+				}
+			}
+			return nil, fmt.Errorf("error loading dead letter output for %s: %v", k, err)
+		}
+	}
+	return
+}
+
+// stopPlugins stops the given Inputs, Outputs and dead letter Output, which may not have been started.
+func stopPlugins(inputs []plugin.Input, outputs []plugin.Output, deadLetter plugin.Output) {
+	for _, in := range inputs {
+		in.Stop()
+	}
+	for _, out := range outputs {
+		out.Stop()
+	}
+	if deadLetter != nil {
+		deadLetter.Stop()
+	}
+}
+
+func flattenInputs(inputs map[string][]plugin.Input) []plugin.Input {
+	var all []plugin.Input
+	for _, ins := range inputs {
+		all = append(all, ins...)
+	}
+	return all
+}
+
+func flattenOutputs(outputs map[string][]plugin.Output) []plugin.Output {
+	var all []plugin.Output
+	for _, outs := range outputs {
+		all = append(all, outs...)
+	}
+	return all
+}
+
+// loadQueues wraps the Inputs and Outputs of each configuration defining a disk queue.
+// Outputs are always queued while Inputs are only queued if enabled.
+func loadQueues(cfg Configs, inputs map[string][]plugin.Input, outputs map[string][]plugin.Output) error {
+	for k, v := range cfg {
+		if v.Queue == nil {
+			continue
+		}
+		if err := v.Queue.Validate(); err != nil {
+			return fmt.Errorf("invalid queue for %s: %v", k, err)
+		}
+		for i, out := range outputs[k] {
+			q, err := queue.Open(filepath.Join(v.Queue.Path, k, fmt.Sprintf("output-%d", i)), *v.Queue)
+			if err != nil {
+				return fmt.Errorf("error loading queue for %s: %v", k, err)
+			}
+			outputs[k][i] = queue.NewOutput(q, out)
+		}
+		if !v.Queue.Input {
+			continue
+		}
+		for i, in := range inputs[k] {
+			q, err := queue.Open(filepath.Join(v.Queue.Path, k, fmt.Sprintf("input-%d", i)), *v.Queue)
+			if err != nil {
+				return fmt.Errorf("error loading queue for %s: %v", k, err)
+			}
+			inputs[k][i] = queue.NewInput(q, in)
+		}
+	}
+	return nil
+}
+
+// loadProcessors loads processing Drivers.
+func loadProcessors(cfg Configs) (processors map[string][][][]driver.Driver, err error) {
+	processors = make(map[string][][][]driver.Driver)
+	for k, v := range cfg {
+		var stageOrder []int
+		for _, s := range v.Processors {
+			stageOrder = append(stageOrder, s.Stage)
+		}
+
+		sort.SliceStable(stageOrder, func(i, j int) bool {
+			return stageOrder[i] < stageOrder[j]
+		})
+		if checkNumDupes(stageOrder) {
+			return nil, fmt.Errorf("%s has duplicate stage number defined", k)
+		}
+		stages := make([][][]driver.Driver, len(stageOrder))
+		for STAGE, o := range stageOrder {
+			for _, processor := range v.Processors {
+				if processor.Stage == o {
+					var stepOrder []int
+					for _, st := range processor.Steps {
+						stepOrder = append(stepOrder, st.Step)
+					}
+					sort.SliceStable(stepOrder, func(i, j int) bool {
+						return stepOrder[i] < stepOrder[j]
+					})
+
+					if checkNumDupes(stepOrder) {
+						return nil, fmt.Errorf("%s stage %d has missing or duplicate step numbers", k, o)
+					}
+					stages[STAGE] = make([][]driver.Driver, len(stepOrder))
+
+					//tags := driver.NewKVStore()
+					//vars := driver.NewKVStore()
+
+					for STEP, sto := range stepOrder {
+						for _, step := range processor.Steps {
+							if step.Step == sto {
+								if len(step.Workflow) < 1 {
+									return nil, fmt.Errorf("%s stage %d step %d has no workflow defined", k, o, sto)
+								}
+								var drivers []driver.Driver
+								for n, wf := range step.Workflow {
+									d, err := driver.New(wf)
+									if err != nil {
+										stages[STAGE][STEP] = drivers
+										CloseDrivers(stages)
+										return nil, fmt.Errorf("invalid configuration for %s stage %d step %d workflow %d: %v", k, o, sto, n, err)
+									}
+									drivers = append(drivers, d)
+								}
+
+								stages[STAGE][STEP] = drivers
+							}
+
+						}
+					}
+					processors[k] = stages
+				}
+			}
+		}
+	}
+	return processors, nil
+}
+
+func checkNumDupes(n []int) (hasDupe bool) {
+	dupe := make(map[int]bool)
+	for _, x := range n {
+		if !dupe[x] {
+			dupe[x] = true
+			continue
+		}
+		hasDupe = true
+		return
+	}
+	return
+}
+
+func loadInputPlugin(id, name string, details map[string]interface{}) (p plugin.Input, err error) {
+	c, err := plugin.NewInputConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	if name == `kafka` {
+		if g, there := details[`group`].(string); there {
+			details[`group`] = g + `-` + id
+		}
+	}
+	err = c.Configure(details)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring input: %v", err)
+	}
+	return c.CreateInput()
+}
+
+func loadOutputPlugin(name string, details map[string]interface{}) (p plugin.Output, err error) {
+	c, err := plugin.NewOutputConfig(name)
+	if err != nil {
+		return nil, err
+	}
+	err = c.Configure(details)
+	if err != nil {
+		return nil, fmt.Errorf("error configuring output: %v", err)
+	}
+	return c.CreateOutput()
+}
+
+func pluginName(x map[string]interface{}) (string, error) {
+	p, ok := x[`plugin`].(string)
+	if !ok {
+		return "", fmt.Errorf("no plugin defined")
+	}
+	return p, nil
+}
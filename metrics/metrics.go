@@ -0,0 +1,195 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = `lfm`
+
+// Results used as the result label value.
+const (
+	ResultPassed    = `passed`
+	ResultFiltered  = `filtered`
+	ResultFailed    = `failed`
+	ResultDelivered = `delivered`
+	ResultDropped   = `dropped`
+)
+
+// Routes used as the route label value of KafkaRouted.
+const (
+	RouteTopic    = `topic`
+	RouteCreated  = `created`
+	RouteFallback = `fallback`
+	RouteFailed   = `failed`
+)
+
+// Pipeline Metrics.
+var (
+	InputEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "input_events_total",
+		Help:      "Number of events received from each Input.",
+	}, []string{"pipeline", "input"})
+	PipelineEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pipeline_events_total",
+		Help:      "Number of events completing a Pipeline by result.",
+	}, []string{"pipeline", "result"})
+	DeadLetters = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dead_letters_total",
+		Help:      "Number of events sent to the dead letter Output.",
+	}, []string{"pipeline"})
+)
+
+// Output Metrics.
+var (
+	OutputEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "output_events_total",
+		Help:      "Number of events sent to each Output by result.",
+	}, []string{"pipeline", "output", "result"})
+	OutputRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "output_retries_total",
+		Help:      "Number of delivery retries for each Output.",
+	}, []string{"pipeline", "output"})
+	OutputQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "output_queue_depth",
+		Help:      "Number of events waiting to be delivered to each Output.",
+	}, []string{"pipeline", "output"})
+	OutputBreakerOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "output_breaker_open",
+		Help:      "Whether the circuit breaker for each Output is open.",
+	}, []string{"pipeline", "output"})
+	OutputLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "output_latency_seconds",
+		Help:      "Time taken for each Output to acknowledge an event.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"pipeline", "output"})
+)
+
+// Queue Metrics.
+var (
+	QueueDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "queue_dropped_records_total",
+		Help:      "Number of unacknowledged records removed from each disk queue by the dropOldest fullPolicy.",
+	}, []string{"queue"})
+)
+
+// Stage Metrics.
+var (
+	StageEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "stage_events_total",
+		Help:      "Number of events processed by each Stage by result.",
+	}, []string{"pipeline", "stage", "result"})
+	StageQueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "stage_queue_depth",
+		Help:      "Number of events waiting to be processed by each Stage.",
+	}, []string{"pipeline", "stage"})
+	StageLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "stage_latency_seconds",
+		Help:      "Time taken for each Stage to process an event.",
+		Buckets:   prometheus.ExponentialBuckets(0.00005, 4, 10),
+	}, []string{"pipeline", "stage"})
+	DriverEvents = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "driver_events_total",
+		Help:      "Number of events processed by each Driver by result.",
+	}, []string{"pipeline", "stage", "step", "driver", "result"})
+)
+
+// Plugin Metrics.
+var (
+	KafkaConsumerLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "kafka_consumer_lag",
+		Help:      "Number of messages remaining between the last consumed offset and the high watermark.",
+	}, []string{"group", "topic", "partition"})
+	KafkaProduced = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_produced_total",
+		Help:      "Number of messages produced to each Kafka topic by result.",
+	}, []string{"topic", "result"})
+	KafkaRouted = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "kafka_routed_total",
+		Help:      "Number of Events routed by topic templates, by whether the routed topic, a created topic or the fallback topic was used.",
+	}, []string{"route"})
+	LokiEntries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "loki_entries_total",
+		Help:      "Number of entries sent to Loki by result, counted once the push of their batch completes. Invalid entries are counted as failed.",
+	}, []string{"result"})
+	LokiBatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "loki_batches_total",
+		Help:      "Number of batches pushed to Loki by result, after any retries.",
+	}, []string{"result"})
+)
+
+var collectors = []prometheus.Collector{
+	InputEvents,
+	PipelineEvents,
+	DeadLetters,
+	OutputEvents,
+	OutputRetries,
+	OutputQueueDepth,
+	OutputBreakerOpen,
+	OutputLatency,
+	QueueDropped,
+	StageEvents,
+	StageQueueDepth,
+	StageLatency,
+	DriverEvents,
+	KafkaConsumerLag,
+	KafkaProduced,
+	KafkaRouted,
+	LokiEntries,
+	LokiBatches,
+}
+
+func init() {
+	prometheus.MustRegister(collectors...)
+}
+
+// Register registers all lfm metrics with the given Registerer, in addition to the default registry.
+// Metrics already registered with the Registerer are skipped.
+func Register(reg prometheus.Registerer) error {
+	for _, c := range collectors {
+		if err := reg.Register(c); err != nil {
+			if _, ok := err.(prometheus.AlreadyRegisteredError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Since returns the seconds elapsed since t.
+func Since(t time.Time) float64 {
+	return time.Since(t).Seconds()
+}
+
+// Handler returns the http.Handler exposing all registered metrics.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Serve exposes all registered metrics at /metrics on the given address.
+func Serve(addr string) error {
+	mux := http.NewServeMux()
+	mux.Handle(`/metrics`, Handler())
+	return http.ListenAndServe(addr, mux)
+}
@@ -1,25 +1,92 @@
 package lfm
 
 import (
-	"bytes"
 	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jbvmio/lfm/driver"
 	"github.com/jbvmio/lfm/log"
+	"github.com/jbvmio/lfm/metrics"
 	"github.com/jbvmio/lfm/pipeline"
 	"github.com/jbvmio/lfm/plugin"
 )
 
 // Pipelines is a collection of Pipelines.
 type Pipelines struct {
-	pls  []Pipeline
-	errs chan error
-	l    log.Logger
+	pls     []*Pipeline
+	errs    chan error
+	l       log.Logger
+	lock    sync.RWMutex
+	running bool
 }
 
 // AddPipeline add a Pipeline to the Collection.
+// If the collection is already running, the Pipeline is started.
 func (P *Pipelines) AddPipeline(p Pipeline) {
-	P.pls = append(P.pls, p)
+	P.lock.Lock()
+	defer P.lock.Unlock()
+	pl := &p
+	P.pls = append(P.pls, pl)
+	if P.running {
+		P.l.Infof("LFM Starting Pipeline %s", pl.Name)
+		pl.Run(P.errs)
+	}
+}
+
+// RemovePipeline stops and removes the named Pipeline from the Collection, returning false if not found.
+// The Pipeline is removed before it is stopped, so the Collection remains available while it drains.
+func (P *Pipelines) RemovePipeline(name string) bool {
+	P.lock.Lock()
+	var removed *Pipeline
+	for i := 0; i < len(P.pls); i++ {
+		if P.pls[i].Name == name {
+			removed = P.pls[i]
+			P.pls = append(P.pls[:i], P.pls[i+1:]...)
+			break
+		}
+	}
+	running := P.running
+	P.lock.Unlock()
+	if removed == nil {
+		return false
+	}
+	if running {
+		P.l.Infof("LFM Stopping Pipeline %s", name)
+		removed.Stop()
+	}
+	return true
+}
+
+// SwapProcessors replaces the Stage processors of the named running Pipeline without restarting its Inputs or Outputs.
+// The Config and Stages of the Pipeline are updated to reflect the new processors.
+func (P *Pipelines) SwapProcessors(name string, cfg Config, stages [][][]driver.Driver, processors []pipeline.DataFunc) error {
+	P.lock.Lock()
+	defer P.lock.Unlock()
+	if !P.running {
+		return fmt.Errorf("pipeline %s is not running", name)
+	}
+	for i := 0; i < len(P.pls); i++ {
+		if P.pls[i].Name == name {
+			return P.pls[i].swapProcessors(cfg, stages, processors)
+		}
+	}
+	return fmt.Errorf("no pipeline named %s", name)
+}
+
+// Names returns the names of all Pipelines in the Collection.
+func (P *Pipelines) Names() []string {
+	P.lock.RLock()
+	defer P.lock.RUnlock()
+	names := make([]string, len(P.pls))
+	for i := 0; i < len(P.pls); i++ {
+		names[i] = P.pls[i].Name
+	}
+	return names
 }
 
 // UseLogger assigns a logger for the Pipeline collection.
@@ -29,6 +96,8 @@ func (P *Pipelines) UseLogger(l log.Logger) {
 
 // Run starts the collection of Pipelines.
 func (P *Pipelines) Run() {
+	P.lock.Lock()
+	defer P.lock.Unlock()
 	if P.l == nil {
 		P.l = log.NewNoop()
 	}
@@ -38,15 +107,37 @@ func (P *Pipelines) Run() {
 		P.l.Infof("LFM Starting Pipeline %s", P.pls[i].Name)
 		P.pls[i].Run(P.errs)
 	}
+	P.running = true
 }
 
-// Stop stops the collection of Pipelines.
-func (P *Pipelines) Stop() {
+// Stop stops the collection of Pipelines, draining each Pipeline concurrently.
+// Returns the total number of Events lost by Pipelines exceeding their DrainTimeout.
+func (P *Pipelines) Stop() int {
+	P.lock.Lock()
+	if !P.running {
+		P.lock.Unlock()
+		return 0
+	}
+	P.running = false
+	pls := make([]*Pipeline, len(P.pls))
+	copy(pls, P.pls)
+	P.lock.Unlock()
 	P.l.Infof("LFM Stopping Pipeline Collection")
-	for i := 0; i < len(P.pls); i++ {
-		P.l.Infof("LFM Stopping Pipeline %s", P.pls[i].Name)
-		P.pls[i].Stop()
+	var lost int64
+	var wg sync.WaitGroup
+	for _, p := range pls {
+		wg.Add(1)
+		go func(p *Pipeline) {
+			defer wg.Done()
+			P.l.Infof("LFM Stopping Pipeline %s", p.Name)
+			atomic.AddInt64(&lost, int64(p.Stop()))
+		}(p)
 	}
+	wg.Wait()
+	if lost > 0 {
+		P.l.Warnf("LFM Pipeline Collection stopped, %d event(s) lost", lost)
+	}
+	return int(lost)
 }
 
 // Errors returns the error channel for recieving errors.
@@ -54,17 +145,34 @@ func (P *Pipelines) Errors() <-chan error {
 	return P.errs
 }
 
+// drainInterval is how often in-flight Events are checked while draining.
+const drainInterval = 50 * time.Millisecond
+
+// DefaultDrainTimeout is used when the DrainTimeout of a Pipeline is not set.
+var DefaultDrainTimeout = 30 * time.Second
+
 // Pipeline combines all plugins, drivers and stages for processing data.
+// When stopped, the Pipeline stops reading from its Inputs and waits up to the DrainTimeout for in-flight Events
+// to complete before stopping the Inputs, Outputs and Stages.
 type Pipeline struct {
-	Name    string
-	Inputs  []plugin.Input
-	Outputs []plugin.Output
-	Stages  [][][]driver.Driver
-	Errs    chan error
-	ctx     context.Context
-	stop    context.CancelFunc
-	P       pipeline.Pipeline
-	L       log.Logger
+	inflight     int64
+	DrainTimeout time.Duration
+	Name         string
+	Inputs       []plugin.Input
+	Outputs      []plugin.Output
+	DeadLetter   plugin.Output
+	Deliveries   []Delivery
+	Stages       [][][]driver.Driver
+	Config       Config
+	Errs         chan error
+	ctx          context.Context
+	stop         context.CancelFunc
+	ingress      context.Context
+	stopIngress  context.CancelFunc
+	deliverers   []*deliverer
+	running      int32
+	P            pipeline.Pipeline
+	L            log.Logger
 }
 
 // Run starts all the Pipeline components.
@@ -74,6 +182,7 @@ func (p *Pipeline) Run(errs chan error) {
 	}
 	p.L.Infof("LFM Pipeline Starting")
 	p.ctx, p.stop = context.WithCancel(context.Background())
+	p.ingress, p.stopIngress = context.WithCancel(p.ctx)
 	p.Errs = errs
 	p.L.Infof("LFM Pipeline Starting %d Input(s)", len(p.Inputs))
 	for _, x := range p.Inputs {
@@ -83,25 +192,86 @@ func (p *Pipeline) Run(errs chan error) {
 	for _, x := range p.Outputs {
 		x.Start()
 	}
-	for _, x := range p.Inputs {
-		go p.startIngress(p.ctx, x)
+	if p.DeadLetter != nil {
+		p.L.Infof("LFM Pipeline Starting Dead Letter Output")
+		p.DeadLetter.Start()
 	}
-	go p.startEgress(p.ctx, p.Outputs)
-	go p.startErrs(p.ctx)
 	p.P.Run()
+	p.deliverers = make([]*deliverer, len(p.Outputs))
+	for n, out := range p.Outputs {
+		var opts Delivery
+		if n < len(p.Deliveries) {
+			opts = p.Deliveries[n]
+		}
+		p.deliverers[n] = newDeliverer(p, n, out, opts)
+		go p.deliverers[n].run(p.ctx)
+	}
+	for n, x := range p.Inputs {
+		go p.startIngress(p.ingress, n, x)
+	}
+	go p.startEgress(p.ctx, p.deliverers)
+	go p.startErrs(p.ctx)
+	for _, x := range p.Inputs {
+		go p.startPluginErrs(p.ctx, x)
+	}
+	for _, x := range p.Outputs {
+		go p.startPluginErrs(p.ctx, x)
+	}
+	if p.DeadLetter != nil {
+		go p.startPluginErrs(p.ctx, p.DeadLetter)
+	}
+	atomic.StoreInt32(&p.running, 1)
 	p.L.Infof("LFM Pipeline Started")
 }
 
+// swapProcessors replaces the processors of each Stage, which must match the number of running Stages.
+// The previous Drivers are closed once the Stages complete the Events already being processed by them.
+func (p *Pipeline) swapProcessors(cfg Config, stages [][][]driver.Driver, processors []pipeline.DataFunc) error {
+	if len(processors) != len(p.P.Stages) {
+		return fmt.Errorf("pipeline %s has %d stage(s), received processors for %d", p.Name, len(p.P.Stages), len(processors))
+	}
+	done := make([]<-chan struct{}, len(p.P.Stages))
+	for n, s := range p.P.Stages {
+		done[n] = s.SwapProcessors(processors[n])
+	}
+	go func(prev [][][]driver.Driver) {
+		for _, c := range done {
+			<-c
+		}
+		CloseDrivers(prev)
+	}(p.Stages)
+	p.Config = cfg
+	p.Stages = stages
+	return nil
+}
+
+// CloseDrivers releases any Drivers implementing io.Closer.
+func CloseDrivers(stages [][][]driver.Driver) {
+	for _, steps := range stages {
+		for _, drivers := range steps {
+			for _, d := range drivers {
+				if c, ok := d.(io.Closer); ok {
+					c.Close()
+				}
+			}
+		}
+	}
+}
+
 // Errors returns the error channel for recieving errors.
 func (p *Pipeline) Errors() <-chan error {
 	p.L.Debugf("LFM Pipeline returning error channel")
 	return p.Errs
 }
 
-// Stop stops all the Pipeline components.
-func (p *Pipeline) Stop() {
+// Stop stops all the Pipeline components in order: reading from Inputs is stopped, in-flight Events are drained
+// from the Stages and Outputs, then the Inputs, Outputs and Stages are stopped.
+// Returns the number of Events lost if the DrainTimeout was exceeded.
+func (p *Pipeline) Stop() int {
 	p.L.Infof("LFM Pipeline Received Stop Request")
-	p.stop()
+	atomic.StoreInt32(&p.running, 0)
+	p.stopIngress()
+	lost := p.drain()
 	p.L.Infof("LFM Pipeline Stopping %d Input(s)", len(p.Inputs))
 	for _, x := range p.Inputs {
 		x.Stop()
@@ -110,40 +280,96 @@ func (p *Pipeline) Stop() {
 	for _, x := range p.Outputs {
 		x.Stop()
 	}
+	if p.DeadLetter != nil {
+		p.L.Infof("LFM Pipeline Stopping Dead Letter Output")
+		p.DeadLetter.Stop()
+	}
+	p.stop()
 	p.P.Stop()
+	CloseDrivers(p.Stages)
 	p.L.Infof("LFM Pipeline Stopped")
+	return lost
 }
 
-func (p *Pipeline) startIngress(ctx context.Context, input plugin.Input) {
+// close releases the plugins, queues and Drivers of a Pipeline which was built but never run.
+func (p *Pipeline) close() {
+	stopPlugins(p.Inputs, p.Outputs, p.DeadLetter)
+	CloseDrivers(p.Stages)
+}
+
+// drain waits for all in-flight Events to be acknowledged, returning the number remaining if the DrainTimeout is exceeded.
+func (p *Pipeline) drain() int {
+	timeout := p.DrainTimeout
+	if timeout <= 0 {
+		timeout = DefaultDrainTimeout
+	}
+	p.L.Infof("LFM Pipeline Draining %d in-flight Event(s)", atomic.LoadInt64(&p.inflight))
+	deadline := time.NewTimer(timeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(drainInterval)
+	defer ticker.Stop()
+	for {
+		n := atomic.LoadInt64(&p.inflight)
+		if n <= 0 {
+			p.L.Infof("LFM Pipeline Drained")
+			return 0
+		}
+		select {
+		case <-deadline.C:
+			p.L.Warnf("LFM Pipeline drain deadline of %v exceeded, %d in-flight event(s) lost", timeout, n)
+			return int(n)
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pipeline) startIngress(ctx context.Context, n int, input plugin.Input) {
 	p.L.Infof("LFM Pipeline Running Input")
-	for data := range input.Source() {
+	received := metrics.InputEvents.WithLabelValues(p.Name, strconv.Itoa(n))
+	for event := range input.Source() {
+		received.Inc()
+		// Blocks until the first Stage has room, applying backpressure to the Input.
+		// Discarded Events are not acknowledged, allowing the Input to replay them.
+		atomic.AddInt64(&p.inflight, 1)
 		select {
 		case <-ctx.Done():
+			atomic.AddInt64(&p.inflight, -1)
 			p.L.Debugf("LFM Pipeline is done, discarding data from Input")
-		default:
+		case p.P.In() <- pipeline.NewEvent(event.Data, p.ackFunc(event)).WithMetadata(event.Metadata):
 			p.L.Debugf("LFM Pipeline Received Data from Input")
-			p.P.In() <- bytes.NewBuffer(data)
 		}
 	}
 	p.L.Infof("LFM Pipeline Stopped an Input")
 }
 
-func (p *Pipeline) startEgress(ctx context.Context, outputs []plugin.Output) {
-	p.L.Infof("LFM Pipeline Running %d Output(s)", len(outputs))
+func (p *Pipeline) startEgress(ctx context.Context, deliverers []*deliverer) {
+	p.L.Infof("LFM Pipeline Running %d Output(s)", len(deliverers))
 	for data := range p.P.Out() {
 		select {
 		case <-ctx.Done():
-			p.L.Debugf("LFM Pipeline is done, skip sending to %d Output(s)", len(outputs))
+			p.L.Debugf("LFM Pipeline is done, skip sending to %d Output(s)", len(deliverers))
 		default:
-			p.L.Debugf("LFM Pipeline Sending Data to %d Output(s)", len(outputs))
-			for _, out := range outputs {
-				// add a timeout here or in pipeline/stage lib:
-				// timout()
-				out.Destination() <- data.Bytes()
+			p.L.Debugf("LFM Pipeline Sending Data to %d Output(s)", len(deliverers))
+			// The Data is acknowledged once every Output has acknowledged its Event.
+			d := data
+			ack := plugin.AckGroup(len(deliverers), func(err error) {
+				pipeline.Ack(d, err)
+			})
+			for n, x := range deliverers {
+				x.enqueue(ctx, deliveryItem{data: data.Bytes(), ack: outputAck(n, ack)})
 			}
 		}
 	}
-	p.L.Infof("LFM Pipeline Stopped %d Output(s)", len(outputs))
+	p.L.Infof("LFM Pipeline Stopped %d Output(s)", len(deliverers))
+}
+
+// reportErr sends the error to the Pipeline error channel without blocking delivery.
+func (p *Pipeline) reportErr(err error) {
+	select {
+	case p.Errs <- err:
+	default:
+		p.L.Errorf("LFM Pipeline error channel full, dropping error: %v", err)
+	}
 }
 
 func (p *Pipeline) startErrs(ctx context.Context) {
@@ -159,3 +385,20 @@ func (p *Pipeline) startErrs(ctx context.Context) {
 	}
 	p.L.Infof("LFM Pipeline Stopped Error Monitor")
 }
+
+func (p *Pipeline) startPluginErrs(ctx context.Context, x plugin.Plugin) {
+	p.L.Infof("LFM Pipeline Running Plugin Error Monitor")
+	for {
+		select {
+		case <-ctx.Done():
+			p.L.Infof("LFM Pipeline Stopped Plugin Error Monitor")
+			return
+		case err := <-x.Errors():
+			p.L.Debugf("LFM Pipeline Received Error from Plugin, Sending error: %v", err)
+			select {
+			case p.Errs <- err:
+			case <-ctx.Done():
+			}
+		}
+	}
+}
@@ -1,5 +1,11 @@
 package pipeline
 
+import (
+	"bytes"
+	"fmt"
+	"sync"
+)
+
 // Data represents data traveling through the pipeline.
 type Data interface {
 	Bytes() []byte
@@ -18,3 +24,80 @@ type OutputFn func(<-chan Data, DataFunc)
 
 // DataFunc is used by all Input, Process and Output Fns.
 type DataFunc func(Data) (bool, error)
+
+// Acker is implemented by Data which is acknowledged once it is done traveling through the pipeline.
+type Acker interface {
+	Ack(error)
+}
+
+// Ack acknowledges the given Data if it implements Acker.
+func Ack(d Data, err error) {
+	if a, ok := d.(Acker); ok {
+		a.Ack(err)
+	}
+}
+
+// MetadataCarrier is implemented by Data carrying metadata about its source.
+type MetadataCarrier interface {
+	Metadata() map[string]string
+}
+
+// Metadata returns the metadata of the given Data if it implements MetadataCarrier, otherwise nil.
+func Metadata(d Data) map[string]string {
+	if m, ok := d.(MetadataCarrier); ok {
+		return m.Metadata()
+	}
+	return nil
+}
+
+// Event is Data which calls an ack func once acknowledged.
+type Event struct {
+	*bytes.Buffer
+	ack  func(error)
+	once sync.Once
+	meta map[string]string
+}
+
+// NewEvent returns a new Event for the given data and ack func.
+// The data is copied, leaving the given slice unmodified as the Event is processed.
+func NewEvent(b []byte, ack func(error)) *Event {
+	return &Event{
+		Buffer: bytes.NewBuffer(append([]byte(nil), b...)),
+		ack:    ack,
+	}
+}
+
+// WithMetadata sets the metadata of the Event, returning the Event.
+func (e *Event) WithMetadata(meta map[string]string) *Event {
+	e.meta = meta
+	return e
+}
+
+// Metadata returns the metadata of the Event.
+func (e *Event) Metadata() map[string]string {
+	return e.meta
+}
+
+// Ack acknowledges the Event. Only the first call has any effect.
+func (e *Event) Ack(err error) {
+	e.once.Do(func() {
+		if e.ack != nil {
+			e.ack(err)
+		}
+	})
+}
+
+// StageError records an error returned while processing Data within a Stage.
+type StageError struct {
+	Stage int
+	Err   error
+}
+
+func (e *StageError) Error() string {
+	return fmt.Sprintf("stage %d: %v", e.Stage, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *StageError) Unwrap() error {
+	return e.Err
+}
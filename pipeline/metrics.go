@@ -0,0 +1,42 @@
+package pipeline
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/jbvmio/lfm/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// stageMetrics holds the metrics for a single Stage.
+type stageMetrics struct {
+	passed   prometheus.Counter
+	filtered prometheus.Counter
+	failed   prometheus.Counter
+	depth    prometheus.Gauge
+	latency  prometheus.Observer
+}
+
+func newStageMetrics(pipeline string, id int) stageMetrics {
+	stage := strconv.Itoa(id)
+	return stageMetrics{
+		passed:   metrics.StageEvents.WithLabelValues(pipeline, stage, metrics.ResultPassed),
+		filtered: metrics.StageEvents.WithLabelValues(pipeline, stage, metrics.ResultFiltered),
+		failed:   metrics.StageEvents.WithLabelValues(pipeline, stage, metrics.ResultFailed),
+		depth:    metrics.StageQueueDepth.WithLabelValues(pipeline, stage),
+		latency:  metrics.StageLatency.WithLabelValues(pipeline, stage),
+	}
+}
+
+// observe records the result of processing Data which started at the given time.
+func (m stageMetrics) observe(start time.Time, pass bool, err error) {
+	m.latency.Observe(metrics.Since(start))
+	switch {
+	case pass:
+		m.passed.Inc()
+	case err != nil:
+		m.failed.Inc()
+	default:
+		m.filtered.Inc()
+	}
+}
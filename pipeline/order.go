@@ -0,0 +1,177 @@
+package pipeline
+
+import (
+	"fmt"
+	"hash/fnv"
+)
+
+// Ordering determines the order in which a Stage emits processed Data.
+type Ordering int
+
+// Available Orderings:
+const (
+	// OrderNone emits Data as soon as it is processed.
+	OrderNone Ordering = iota
+	// OrderInput processes Data in parallel but emits it in the order it was received.
+	OrderInput
+	// OrderKey emits Data in the order it was received for each key returned by the Stage OrderKey func.
+	OrderKey
+)
+
+var orderingStrings = [...]string{
+	`none`,
+	`ordered`,
+	`key`,
+}
+
+func (o Ordering) String() string {
+	if o < 0 || int(o) >= len(orderingStrings) {
+		return fmt.Sprintf("Ordering(%d)", int(o))
+	}
+	return orderingStrings[o]
+}
+
+// OrderingFromString returns the Ordering matching the given name.
+// An empty name returns OrderNone.
+func OrderingFromString(name string) (Ordering, error) {
+	if name == "" {
+		return OrderNone, nil
+	}
+	for i, s := range orderingStrings {
+		if s == name {
+			return Ordering(i), nil
+		}
+	}
+	return OrderNone, fmt.Errorf("invalid ordering: %s", name)
+}
+
+// KeyFunc returns the ordering key for the given Data.
+type KeyFunc func(Data) string
+
+type orderedJob struct {
+	d    Data
+	pass chan bool
+}
+
+// runOrdered processes Data using all Workers while emitting results in the order they were received.
+func (s *Stage) runOrdered() {
+	jobs := make(chan orderedJob)
+	pending := make(chan orderedJob, s.Workers)
+	for i := 0; i < s.Workers; i++ {
+		s.wg.Add(1)
+		go func(id int) {
+			defer s.wg.Done()
+			for {
+				select {
+				case <-s.stopChan:
+					s.l.Debugf("worker %d received stop signal, stopping ...", id)
+					return
+				case <-s.CTX.Done():
+					s.l.Debugf("worker %d received completion signal, stopping ...", id)
+					return
+				case job := <-jobs:
+					job.pass <- s.processStage(job.d)
+				}
+			}
+		}(i)
+	}
+	s.wg.Add(2)
+	go func() {
+		defer s.wg.Done()
+		for {
+			select {
+			case <-s.stopChan:
+				return
+			case <-s.CTX.Done():
+				return
+			case d := <-s.in:
+				job := orderedJob{d: d, pass: make(chan bool, 1)}
+				select {
+				case pending <- job:
+				case <-s.stopChan:
+					return
+				case <-s.CTX.Done():
+					return
+				}
+				select {
+				case jobs <- job:
+				case <-s.stopChan:
+					return
+				case <-s.CTX.Done():
+					return
+				}
+			}
+		}
+	}()
+	go func() {
+		defer s.wg.Done()
+		for {
+			select {
+			case <-s.stopChan:
+				return
+			case <-s.CTX.Done():
+				return
+			case job := <-pending:
+				select {
+				case pass := <-job.pass:
+					if pass {
+						s.send(job.d)
+					}
+				case <-s.stopChan:
+					return
+				case <-s.CTX.Done():
+					return
+				}
+			}
+		}
+	}()
+}
+
+// runKeyed assigns each key to a single Worker, keeping Data with the same key in order
+// while Data with different keys is processed in parallel.
+func (s *Stage) runKeyed() {
+	lanes := make([]chan Data, s.Workers)
+	for i := 0; i < s.Workers; i++ {
+		lanes[i] = make(chan Data)
+		s.wg.Add(1)
+		go func(id int, lane chan Data) {
+			defer s.wg.Done()
+			for {
+				select {
+				case <-s.stopChan:
+					s.l.Debugf("worker %d received stop signal, stopping ...", id)
+					return
+				case <-s.CTX.Done():
+					s.l.Debugf("worker %d received completion signal, stopping ...", id)
+					return
+				case d := <-lane:
+					if s.processStage(d) {
+						s.send(d)
+					}
+				}
+			}
+		}(i, lanes[i])
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		for {
+			select {
+			case <-s.stopChan:
+				return
+			case <-s.CTX.Done():
+				return
+			case d := <-s.in:
+				h := fnv.New32a()
+				h.Write([]byte(s.OrderKey(d)))
+				select {
+				case lanes[h.Sum32()%uint32(len(lanes))] <- d:
+				case <-s.stopChan:
+					return
+				case <-s.CTX.Done():
+					return
+				}
+			}
+		}
+	}()
+}
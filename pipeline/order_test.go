@@ -0,0 +1,118 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// delayed sleeps longer for Data received earlier, so unordered Workers would emit it last.
+func delayed(n int) DataFunc {
+	return func(d Data) (bool, error) {
+		i, err := strconv.Atoi(strings.SplitN(string(d.Bytes()), `-`, 2)[1])
+		if err != nil {
+			return false, err
+		}
+		time.Sleep(time.Duration(n-i) * time.Millisecond)
+		return true, nil
+	}
+}
+
+func runOrdering(t *testing.T, s *Stage, data []string) []string {
+	t.Helper()
+	p := NewPipeline(context.Background(), nil)
+	p.AddStages(s)
+	p.Run()
+	defer p.Stop()
+	go func() {
+		for _, x := range data {
+			p.In() <- NewEvent([]byte(x), nil)
+		}
+	}()
+	out := make([]string, len(data))
+	for i := range out {
+		select {
+		case d := <-p.Out():
+			out[i] = string(d.Bytes())
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d data, received %d", len(data), i)
+		}
+	}
+	return out
+}
+
+func TestOrderInputEmitsInSequence(t *testing.T) {
+	const n = 20
+	data := make([]string, n)
+	for i := range data {
+		data[i] = fmt.Sprintf("data-%d", i)
+	}
+	s := NewStage(context.Background(), nil)
+	s.Workers = 4
+	s.Ordering = OrderInput
+	s.Processors = []DataFunc{delayed(n)}
+	out := runOrdering(t, &s, data)
+	for i := range data {
+		if out[i] != data[i] {
+			t.Fatalf("expected %v, got %v", data, out)
+		}
+	}
+}
+
+func TestOrderKeyEmitsInSequence(t *testing.T) {
+	const n = 20
+	keys := []string{`a`, `b`, `c`}
+	data := make([]string, n)
+	for i := range data {
+		data[i] = fmt.Sprintf("%s-%d", keys[i%len(keys)], i)
+	}
+	s := NewStage(context.Background(), nil)
+	s.Workers = 4
+	s.Ordering = OrderKey
+	s.OrderKey = func(d Data) string {
+		return strings.SplitN(string(d.Bytes()), `-`, 2)[0]
+	}
+	s.Processors = []DataFunc{delayed(n)}
+	last := make(map[string]int)
+	for _, x := range runOrdering(t, &s, data) {
+		parts := strings.SplitN(x, `-`, 2)
+		i, _ := strconv.Atoi(parts[1])
+		if prev, ok := last[parts[0]]; ok && i < prev {
+			t.Fatalf("key %s: expected %d to be emitted before %d", parts[0], i, prev)
+		}
+		last[parts[0]] = i
+	}
+	if len(last) != len(keys) {
+		t.Fatalf("expected data for %d keys, got %d", len(keys), len(last))
+	}
+}
+
+func TestOrderKeyWithoutFunc(t *testing.T) {
+	s := NewStage(context.Background(), nil)
+	s.Workers = 2
+	s.Ordering = OrderKey
+	out := runOrdering(t, &s, []string{`data-0`, `data-1`})
+	if len(out) != 2 {
+		t.Fatalf("expected 2 data, got %d", len(out))
+	}
+	if got := s.Status().Ordering; got != `none` {
+		t.Fatalf("expected ordering none without an OrderKey func, got %s", got)
+	}
+}
+
+func TestOrderingString(t *testing.T) {
+	for o, want := range map[Ordering]string{
+		OrderNone:  `none`,
+		OrderInput: `ordered`,
+		OrderKey:   `key`,
+		-1:         `Ordering(-1)`,
+		3:          `Ordering(3)`,
+	} {
+		if got := o.String(); got != want {
+			t.Fatalf("expected %s, got %s", want, got)
+		}
+	}
+}
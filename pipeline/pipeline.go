@@ -13,6 +13,7 @@ type Pipeline struct {
 	errs     chan error
 	stopChan chan struct{}
 	CTX      context.Context
+	Name     string
 	Stages   []*Stage
 	l        log.Logger
 }
@@ -55,15 +56,28 @@ func (p *Pipeline) AddStages(stages ...*Stage) {
 	p.Stages = append(p.Stages, stages...)
 }
 
+// configure links the Stages together. Each Stage receives Data through a queue
+// sized by its QueueSize, which is shared with the output of the previous Stage.
 func (p *Pipeline) configure() {
-	linkedChan := p.in
+	p.out = p.in
 	for i := 0; i < len(p.Stages); i++ {
 		p.l.Infof("configuring stage %d", i)
+		if p.Stages[i].QueueSize < 1 {
+			p.Stages[i].QueueSize = DefaultQueueSize
+		}
+		queue := make(chan Data, p.Stages[i].QueueSize)
+		switch i {
+		case 0:
+			p.in = queue
+		default:
+			p.Stages[i-1].out = queue
+		}
+		p.Stages[i].id = i
+		p.Stages[i].metrics = newStageMetrics(p.Name, i)
 		p.Stages[i].errs = p.errs
-		p.Stages[i].in = linkedChan
-		linkedChan = p.Stages[i].out
+		p.Stages[i].in = queue
+		p.out = p.Stages[i].out
 	}
-	p.out = linkedChan
 }
 
 // Run starts all Stages within the Pipeline.
@@ -2,26 +2,54 @@ package pipeline
 
 import (
 	"context"
+	"runtime"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jbvmio/lfm/log"
 )
 
+// Stage defaults used when Workers or QueueSize are not set.
+const (
+	DefaultQueueSize = 1000
+)
+
+// DefaultWorkers is the number of workers used by a Stage when Workers is not set.
+var DefaultWorkers = runtime.NumCPU()
+
+// DefaultStallTimeout is used when StallTimeout is not set.
+var DefaultStallTimeout = time.Minute
+
 // Stage represents a self contained set of functions to process Data.
+// A Stage is considered stalled when Data is queued but none has completed processing within the StallTimeout.
 type Stage struct {
-	in         chan Data
-	out        chan Data
-	errs       chan error
-	stopChan   chan struct{}
-	wg         sync.WaitGroup
-	inputFn    InputFn
-	processors []ProcessFn
-	outputFn   OutputFn
-	CTX        context.Context
-	InputFn    DataFunc
-	Processors []DataFunc
-	OutputFn   DataFunc
-	l          log.Logger
+	last         int64
+	in           chan Data
+	out          chan Data
+	errs         chan error
+	stopChan     chan struct{}
+	wg           sync.WaitGroup
+	CTX          context.Context
+	InputFn      DataFunc
+	Processors   []DataFunc
+	OutputFn     DataFunc
+	Workers      int
+	QueueSize    int
+	Ordering     Ordering
+	OrderKey     KeyFunc
+	StallTimeout time.Duration
+	id           int
+	metrics      stageMetrics
+	procs        *processorSet
+	procsLock    sync.RWMutex
+	l            log.Logger
+}
+
+// processorSet holds the Processors of a Stage and tracks the Data being processed using them.
+type processorSet struct {
+	funcs  []DataFunc
+	active sync.WaitGroup
 }
 
 // NewStage returns a new Stage.
@@ -58,82 +86,15 @@ func (s *Stage) Error() chan error {
 	return s.errs
 }
 
-// DefaultInputFn is used for the InputFn DataFunc.
-func (s *Stage) DefaultInputFn(d Data, df DataFunc) <-chan Data {
-	s.l.Debugf("receiving input ...")
-	out := make(chan Data)
-	go func() {
-		pass, err := df(d)
-		switch {
-		case err != nil:
-			s.l.Errorf("error receiving input: %v", err)
-			s.errs <- err
-			close(out)
-		case !pass:
-			s.l.Debugf("receiving input failed validation, discarding")
-			close(out)
-		default:
-			s.l.Debugf("delivering input data")
-			out <- d
-			close(out)
-		}
-	}()
-	return out
-}
-
-// DefaultProcessorFn is used for all ProcessFn DataFunc.
-func (s *Stage) DefaultProcessorFn(in <-chan Data, df DataFunc) <-chan Data {
-	s.l.Debugf("begin processing received data")
-	out := make(chan Data)
-	go func() {
-		d, ok := <-in
-		if ok {
-			pass, err := df(d)
-			switch {
-			case err != nil:
-				s.l.Errorf("error processing data: %v", err)
-				s.errs <- err
-				close(out)
-			case !pass:
-				s.l.Debugf("processing data failed validation, discarding ...")
-				close(out)
-			default:
-				out <- d
-				close(out)
-				s.l.Debugf("processed data successfully")
-			}
-		}
-	}()
-	return out
-}
-
-// DefaultOutputFn is used for the OutputFn DataFunc.
-func (s *Stage) DefaultOutputFn(in <-chan Data, df DataFunc) {
-	s.l.Debugf("receiving output ...")
-	go func() {
-		d, ok := <-in
-		if ok {
-			pass, err := df(d)
-			switch {
-			case err != nil:
-				s.l.Errorf("error receiving output: %v", err)
-				s.errs <- err
-			case !pass:
-				s.l.Debugf("receiving output failed validation, discarding ...")
-			default:
-				s.out <- d
-				s.l.Debugf("sent output successfully")
-			}
-		}
-	}()
-}
-
 // NoopData performs no actions on the given data.
 func NoopData(d Data) (bool, error) {
 	return true, nil
 }
 
 // Run starts processing data through the stage.
+// A fixed number of Workers process Data received from the stage input. Once all Workers are busy,
+// and the input queue is full, senders to the stage will block until a Worker becomes available.
+// The Ordering determines whether processed Data is emitted in the order it was received.
 func (s *Stage) Run() {
 	s.l.Infof("starting ...")
 	if s.InputFn == nil {
@@ -142,25 +103,36 @@ func (s *Stage) Run() {
 	if s.OutputFn == nil {
 		s.OutputFn = NoopData
 	}
-	s.wg.Add(1)
-	go func() {
-		defer s.wg.Done()
-	runStage:
-		for {
-			select {
-			case <-s.stopChan:
-				s.l.Debugf("received stop signal, stopping ...")
-				break runStage
-			case <-s.CTX.Done():
-				s.l.Debugf("received completion signal, stopping ...")
-				break runStage
-			case d := <-s.in:
-				s.l.Debugf("received data")
-				s.wg.Add(1)
-				go s.processStage(&s.wg, d)
-			}
+	if s.Workers < 1 {
+		s.Workers = DefaultWorkers
+	}
+	if s.StallTimeout <= 0 {
+		s.StallTimeout = DefaultStallTimeout
+	}
+	s.procsLock.Lock()
+	s.procs = &processorSet{funcs: s.Processors}
+	s.procsLock.Unlock()
+	s.touch()
+	switch {
+	case s.Ordering == OrderKey && s.OrderKey == nil:
+		s.l.Errorf("key ordering requires an OrderKey func, processing without ordering")
+		s.Ordering = OrderNone
+	case s.Ordering < OrderNone || s.Ordering > OrderKey:
+		s.l.Errorf("invalid ordering %d, processing without ordering", int(s.Ordering))
+		s.Ordering = OrderNone
+	}
+	s.l.Infof("starting %d worker(s) using %s ordering", s.Workers, s.Ordering)
+	switch s.Ordering {
+	case OrderInput:
+		s.runOrdered()
+	case OrderKey:
+		s.runKeyed()
+	default:
+		for i := 0; i < s.Workers; i++ {
+			s.wg.Add(1)
+			go s.runWorker(i)
 		}
-	}()
+	}
 }
 
 // Stop stops processing data within the stage.
@@ -171,14 +143,139 @@ func (s *Stage) Stop() {
 	s.l.Infof("stopped.")
 }
 
-func (s *Stage) processStage(wg *sync.WaitGroup, d Data) {
+// SwapProcessors replaces the Processors of a running Stage.
+// Data already being processed completes using the previous Processors, after which the returned channel is closed.
+func (s *Stage) SwapProcessors(processors ...DataFunc) <-chan struct{} {
+	s.l.Infof("swapping %d processor(s)", len(processors))
+	s.procsLock.Lock()
+	prev := s.procs
+	s.procs = &processorSet{funcs: processors}
+	s.procsLock.Unlock()
+	done := make(chan struct{})
+	if prev == nil {
+		close(done)
+		return done
+	}
+	go func() {
+		prev.active.Wait()
+		close(done)
+	}()
+	return done
+}
+
+// acquire returns the current Processors, which must be released once the Data is processed.
+func (s *Stage) acquire() *processorSet {
+	s.procsLock.RLock()
+	defer s.procsLock.RUnlock()
+	s.procs.active.Add(1)
+	return s.procs
+}
+
+// StageStatus describes the current state of a Stage.
+type StageStatus struct {
+	Workers      int       `json:"workers"`
+	QueueSize    int       `json:"queueSize"`
+	QueueDepth   int       `json:"queueDepth"`
+	Ordering     string    `json:"ordering"`
+	LastActivity time.Time `json:"lastActivity"`
+	Stalled      bool      `json:"stalled"`
+}
+
+// Status returns the current StageStatus.
+func (s *Stage) Status() StageStatus {
+	last := time.Unix(0, atomic.LoadInt64(&s.last))
+	depth := len(s.in)
+	return StageStatus{
+		Workers:      s.Workers,
+		QueueSize:    cap(s.in),
+		QueueDepth:   depth,
+		Ordering:     s.Ordering.String(),
+		LastActivity: last,
+		Stalled:      depth > 0 && time.Since(last) > s.StallTimeout,
+	}
+}
+
+// touch records the time the Stage last completed processing.
+func (s *Stage) touch() {
+	atomic.StoreInt64(&s.last, time.Now().UnixNano())
+}
+
+func (s *Stage) runWorker(id int) {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.stopChan:
+			s.l.Debugf("worker %d received stop signal, stopping ...", id)
+			return
+		case <-s.CTX.Done():
+			s.l.Debugf("worker %d received completion signal, stopping ...", id)
+			return
+		case d := <-s.in:
+			s.l.Debugf("worker %d received data", id)
+			if s.processStage(d) {
+				s.send(d)
+			}
+		}
+	}
+}
+
+// send delivers processed Data to the stage output, blocking until it is received or the stage is stopped.
+func (s *Stage) send(d Data) bool {
+	select {
+	case s.out <- d:
+		s.l.Debugf("sent output successfully")
+		return true
+	case <-s.stopChan:
+		s.l.Debugf("stage stopped before output could be sent, discarding ...")
+	case <-s.CTX.Done():
+		s.l.Debugf("stage completed before output could be sent, discarding ...")
+	}
+	return false
+}
+
+// processStage runs the Data through the stage DataFuncs, returning true if the Data should be sent to the stage output.
+// Data which is discarded or fails processing is acknowledged.
+func (s *Stage) processStage(d Data) bool {
 	s.l.Debugf("starting data processing")
-	defer wg.Done()
-	input := s.DefaultInputFn(d, s.InputFn)
-	for n, process := range s.Processors {
-		input = s.DefaultProcessorFn(input, process)
+	s.metrics.depth.Set(float64(len(s.in)))
+	start := time.Now()
+	pass, err := s.apply(d, s.InputFn)
+	procs := s.acquire()
+	for n := 0; pass && n < len(procs.funcs); n++ {
+		pass, err = s.apply(d, procs.funcs[n])
 		s.l.Debugf("data processing completed processor %d", n)
 	}
-	s.DefaultOutputFn(input, s.OutputFn)
+	procs.active.Done()
+	if pass {
+		pass, err = s.apply(d, s.OutputFn)
+	}
+	s.metrics.observe(start, pass, err)
+	s.touch()
+	if !pass {
+		if err != nil {
+			err = &StageError{Stage: s.id, Err: err}
+		}
+		Ack(d, err)
+		return false
+	}
 	s.l.Debugf("completed data processing")
+	return true
+}
+
+func (s *Stage) apply(d Data, df DataFunc) (bool, error) {
+	pass, err := df(d)
+	switch {
+	case err != nil:
+		s.l.Errorf("error processing data: %v", err)
+		select {
+		case s.errs <- err:
+		case <-s.stopChan:
+		case <-s.CTX.Done():
+		}
+		return false, err
+	case !pass:
+		s.l.Debugf("processing data failed validation, discarding ...")
+		return false, nil
+	}
+	return true, nil
 }
@@ -0,0 +1,94 @@
+package pipeline
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSwapProcessorsWaitsForActiveData(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	s := NewStage(context.Background(), nil)
+	s.Workers = 1
+	s.Processors = []DataFunc{func(d Data) (bool, error) {
+		close(started)
+		<-release
+		return true, nil
+	}}
+	p := NewPipeline(context.Background(), nil)
+	p.AddStages(&s)
+	p.Run()
+	defer p.Stop()
+
+	p.In() <- NewEvent([]byte(`old`), nil)
+	<-started
+	done := s.SwapProcessors(func(d Data) (bool, error) {
+		d.Write([]byte(`-new`))
+		return true, nil
+	})
+	select {
+	case <-done:
+		t.Fatal("expected the swap to wait for data processed by the previous processors")
+	case <-time.After(20 * time.Millisecond):
+	}
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("expected the swap to complete once the data was processed")
+	}
+	if got := string((<-p.Out()).Bytes()); got != `old` {
+		t.Fatalf("expected old, got %s", got)
+	}
+	p.In() <- NewEvent([]byte(`next`), nil)
+	if got := string((<-p.Out()).Bytes()); got != `next-new` {
+		t.Fatalf("expected next-new, got %s", got)
+	}
+}
+
+func TestSaturatedStageBlocksSender(t *testing.T) {
+	started, release := make(chan struct{}, 10), make(chan struct{})
+	s := NewStage(context.Background(), nil)
+	s.Workers = 2
+	s.QueueSize = 3
+	s.Processors = []DataFunc{func(d Data) (bool, error) {
+		started <- struct{}{}
+		<-release
+		return true, nil
+	}}
+	p := NewPipeline(context.Background(), nil)
+	p.AddStages(&s)
+	p.Run()
+	defer p.Stop()
+
+	for i := 0; i < s.Workers; i++ {
+		p.In() <- NewEvent([]byte(`busy`), nil)
+		<-started
+	}
+	for i := 0; i < s.QueueSize; i++ {
+		select {
+		case p.In() <- NewEvent([]byte(`queued`), nil):
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d data to be queued, blocked after %d", s.QueueSize, i)
+		}
+	}
+	sent := make(chan struct{})
+	go func() {
+		p.In() <- NewEvent([]byte(`blocked`), nil)
+		close(sent)
+	}()
+	select {
+	case <-sent:
+		t.Fatal("expected the sender to block while all workers are busy and the queue is full")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	for i := 0; i < s.Workers+s.QueueSize+1; i++ {
+		select {
+		case <-p.Out():
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d data to be processed, received %d", s.Workers+s.QueueSize+1, i)
+		}
+	}
+	<-sent
+}
@@ -0,0 +1,14 @@
+// Package builtin registers all Input and Output Plugins provided with lfm.
+//
+// Import it for its side effects:
+//
+//	import _ "github.com/jbvmio/lfm/plugin/builtin"
+package builtin
+
+import (
+	// Built-in Plugins:
+	_ "github.com/jbvmio/lfm/plugin/exec"
+	_ "github.com/jbvmio/lfm/plugin/kafka"
+	_ "github.com/jbvmio/lfm/plugin/loki"
+	_ "github.com/jbvmio/lfm/plugin/osio"
+)
@@ -2,58 +2,54 @@ package config
 
 import (
 	"github.com/jbvmio/lfm/plugin"
-	"github.com/jbvmio/lfm/plugin/kafka"
-	"github.com/jbvmio/lfm/plugin/loki"
-	"github.com/jbvmio/lfm/plugin/osio"
+
+	// Built-in Plugins:
+	_ "github.com/jbvmio/lfm/plugin/builtin"
 )
 
 // Config represents configuration details for a Input or Output Plugin.
-type Config interface {
-	Configure(map[string]interface{}) error
-}
+type Config = plugin.Config
 
 // InputConfig is a Config for an Input Plugin.
-type InputConfig interface {
-	Config
-	CreateInput() (plugin.Input, error)
-}
+type InputConfig = plugin.InputConfig
 
 // OutputConfig is a Config for an Output Plugin.
-type OutputConfig interface {
-	Config
-	CreateOutput() (plugin.Output, error)
+type OutputConfig = plugin.OutputConfig
+
+// inputNames maps TypeIDs to their registered Input Plugin names.
+var inputNames = map[plugin.TypeID]string{
+	plugin.TypeInputFile:  `file`,
+	plugin.TypeInputKafka: `kafka`,
+}
+
+// outputNames maps TypeIDs to their registered Output Plugin names.
+var outputNames = map[plugin.TypeID]string{
+	plugin.TypeOutputFile:  `file`,
+	plugin.TypeOutputKafka: `kafka`,
+	plugin.TypeOutputLoki:  `loki`,
+	plugin.TypeOutputStd:   `stdout`,
 }
 
 // GetInputConfig returns an InputConfig based on the entered ID.
 // Returns nil if TypeID is None an invalid ID is entered.
+//
+// Deprecated: Use plugin.NewInputConfig with the registered plugin name.
 func GetInputConfig(i plugin.TypeID) InputConfig {
-	switch i {
-	case plugin.TypeNone:
-		return nil
-	case plugin.TypeInputFile:
-		return &osio.FileInputConfig{}
-	case plugin.TypeInputKafka:
-		return &kafka.InputConfig{}
-	default:
+	c, err := plugin.NewInputConfig(inputNames[i])
+	if err != nil {
 		return nil
 	}
+	return c
 }
 
 // GetOutputConfig returns an Output based on the entered ID.
 // Returns nil if TypeID is None an invalid ID is entered.
+//
+// Deprecated: Use plugin.NewOutputConfig with the registered plugin name.
 func GetOutputConfig(i plugin.TypeID) OutputConfig {
-	switch i {
-	case plugin.TypeNone:
-		return nil
-	case plugin.TypeOutputFile:
-		return &osio.FileOutputConfig{}
-	case plugin.TypeOutputKafka:
-		return &kafka.OutputConfig{}
-	case plugin.TypeOutputLoki:
-		return &loki.OutputConfig{}
-	case plugin.TypeOutputStd:
-		return &osio.StdOutputConfig{}
-	default:
+	c, err := plugin.NewOutputConfig(outputNames[i])
+	if err != nil {
 		return nil
 	}
+	return c
 }
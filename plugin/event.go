@@ -0,0 +1,122 @@
+package plugin
+
+import (
+	"sync"
+)
+
+// Event is a single piece of data passed between Plugins and a Pipeline.
+// Metadata optionally describes the source of the Data, such as a Kafka topic, and is provided to Drivers
+// as tmpVars, allowing their actions to use it with getVar.
+type Event struct {
+	Data     []byte
+	Metadata map[string]string
+	ack      func(error)
+}
+
+// NewEvent returns an Event for the given data.
+// The ack func, if not nil, is called once when the Event is acknowledged.
+func NewEvent(data []byte, ack func(error)) Event {
+	if ack == nil {
+		return Event{Data: data}
+	}
+	var once sync.Once
+	return Event{
+		Data: data,
+		ack: func(err error) {
+			once.Do(func() { ack(err) })
+		},
+	}
+}
+
+// Ack acknowledges the Event. A nil error indicates the Event was delivered or intentionally discarded.
+func (e Event) Ack(err error) {
+	if e.ack != nil {
+		e.ack(err)
+	}
+}
+
+// AckGroup returns an ack func which must be called n times before calling the given ack func.
+// The first non-nil error received is passed to the given ack func.
+func AckGroup(n int, ack func(error)) func(error) {
+	if n < 1 {
+		ack(nil)
+		return func(error) {}
+	}
+	var lock sync.Mutex
+	var first error
+	return func(err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if err != nil && first == nil {
+			first = err
+		}
+		n--
+		if n == 0 {
+			ack(first)
+		}
+	}
+}
+
+// OffsetTracker tracks in-flight offsets in the order they were added and reports the
+// highest offset for which it and every offset added before it are done.
+type OffsetTracker struct {
+	lock      sync.Mutex
+	base      uint64
+	offsets   []int64
+	done      []bool
+	committed int64
+}
+
+// NewOffsetTracker returns a new OffsetTracker starting at the given committed offset.
+func NewOffsetTracker(committed int64) *OffsetTracker {
+	return &OffsetTracker{
+		committed: committed,
+	}
+}
+
+// Add tracks the given offset as in-flight and returns its ID.
+func (t *OffsetTracker) Add(offset int64) uint64 {
+	t.lock.Lock()
+	id := t.base + uint64(len(t.offsets))
+	t.offsets = append(t.offsets, offset)
+	t.done = append(t.done, false)
+	t.lock.Unlock()
+	return id
+}
+
+// Done marks the offset with the given ID done, returning the committed offset
+// and whether it advanced as a result.
+func (t *OffsetTracker) Done(id uint64) (int64, bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if id < t.base || id-t.base >= uint64(len(t.done)) {
+		return t.committed, false
+	}
+	t.done[id-t.base] = true
+	var n int
+	for n < len(t.done) && t.done[n] {
+		n++
+	}
+	if n == 0 {
+		return t.committed, false
+	}
+	t.committed = t.offsets[n-1]
+	t.offsets = t.offsets[n:]
+	t.done = t.done[n:]
+	t.base += uint64(n)
+	return t.committed, true
+}
+
+// Committed returns the current committed offset.
+func (t *OffsetTracker) Committed() int64 {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.committed
+}
+
+// Pending returns the number of offsets still in-flight.
+func (t *OffsetTracker) Pending() int {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return len(t.offsets)
+}
@@ -0,0 +1,62 @@
+package plugin
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestOffsetTrackerOutOfOrder(t *testing.T) {
+	tracker := NewOffsetTracker(9)
+	ids := make([]uint64, 5)
+	for i := range ids {
+		ids[i] = tracker.Add(int64(10 + i))
+	}
+	for _, x := range []struct {
+		id        int
+		committed int64
+		advanced  bool
+		pending   int
+	}{
+		{id: 3, committed: 9, pending: 5},
+		{id: 1, committed: 9, pending: 5},
+		{id: 0, committed: 11, advanced: true, pending: 3},
+		{id: 0, committed: 11, pending: 3},
+		{id: 2, committed: 13, advanced: true, pending: 1},
+		{id: 4, committed: 14, advanced: true, pending: 0},
+	} {
+		committed, advanced := tracker.Done(ids[x.id])
+		if committed != x.committed || advanced != x.advanced {
+			t.Fatalf("done %d: expected (%d, %v), got (%d, %v)", x.id, x.committed, x.advanced, committed, advanced)
+		}
+		if pending := tracker.Pending(); pending != x.pending {
+			t.Fatalf("done %d: expected %d pending, got %d", x.id, x.pending, pending)
+		}
+	}
+}
+
+func TestOffsetTrackerConcurrent(t *testing.T) {
+	const n = 1000
+	tracker := NewOffsetTracker(-1)
+	ids := make(chan uint64, n)
+	for i := 0; i < n; i++ {
+		ids <- tracker.Add(int64(i))
+	}
+	close(ids)
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range ids {
+				tracker.Done(id)
+			}
+		}()
+	}
+	wg.Wait()
+	if committed := tracker.Committed(); committed != n-1 {
+		t.Fatalf("expected committed offset %d, got %d", n-1, committed)
+	}
+	if pending := tracker.Pending(); pending != 0 {
+		t.Fatalf("expected no pending offsets, got %d", pending)
+	}
+}
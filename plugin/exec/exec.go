@@ -0,0 +1,233 @@
+// Package exec provides Input and Output Plugins backed by a supervised child process,
+// exchanging length-prefixed JSON Messages over its stdin and stdout.
+//
+// An Input process writes an event Message for each line of data and receives an ack Message with the same ID
+// once the event has been delivered, containing an error if delivery failed.
+// An Output process receives an event Message for each Event and must respond with a Message of the same ID,
+// containing an error if the Event could not be delivered.
+package exec
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/jbvmio/lfm/internal/proc"
+	"github.com/jbvmio/lfm/plugin"
+	"gopkg.in/yaml.v2"
+)
+
+func init() {
+	plugin.RegisterInput(`exec`, func() plugin.InputConfig { return &InputConfig{} })
+	plugin.RegisterOutput(`exec`, func() plugin.OutputConfig { return &OutputConfig{} })
+}
+
+// InputConfig contains configuration details when using the Exec Input Plugin.
+type InputConfig struct {
+	proc.Config `yaml:",inline"`
+	Buffer      int `yaml:"buffer" json:"buffer"`
+}
+
+// Configure attempts to configure the Config based on the details entered.
+func (c *InputConfig) Configure(details map[string]interface{}) error {
+	if err := configure(details, c); err != nil {
+		return err
+	}
+	if c.Buffer == 0 {
+		c.Buffer = 1000
+	}
+	return c.Validate()
+}
+
+// CreateInput creates an Input based on the Config.
+func (c *InputConfig) CreateInput() (plugin.Input, error) {
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid exec input: %v", err)
+	}
+	return &Input{
+		cfg:      c.Config,
+		data:     make(chan plugin.Event, c.Buffer),
+		errs:     make(chan error, c.Buffer),
+		stopChan: make(chan struct{}),
+	}, nil
+}
+
+// OutputConfig contains configuration details when using the Exec Output Plugin.
+type OutputConfig struct {
+	proc.Config `yaml:",inline"`
+}
+
+// Configure attempts to configure the Config based on the details entered.
+func (c *OutputConfig) Configure(details map[string]interface{}) error {
+	if err := configure(details, c); err != nil {
+		return err
+	}
+	return c.Validate()
+}
+
+// CreateOutput creates an Output based on the Config.
+func (c *OutputConfig) CreateOutput() (plugin.Output, error) {
+	if err := c.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid exec output: %v", err)
+	}
+	return &Output{
+		cfg:      c.Config,
+		data:     make(chan plugin.Event),
+		errs:     make(chan error, 100),
+		stopChan: make(chan struct{}),
+	}, nil
+}
+
+func configure(details map[string]interface{}, c interface{}) error {
+	d, err := yaml.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("invalid configuration format: %v", err)
+	}
+	if err := yaml.Unmarshal(d, c); err != nil {
+		return fmt.Errorf("invalid configuration: %v", err)
+	}
+	return nil
+}
+
+// Input reads Events from a child process.
+type Input struct {
+	cfg      proc.Config
+	proc     *proc.Process
+	data     chan plugin.Event
+	errs     chan error
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Start starts the plugin.
+func (in *Input) Start() error {
+	p, err := proc.Start(in.cfg, in.handle)
+	if err != nil {
+		return err
+	}
+	in.proc = p
+	in.wg.Add(1)
+	go forwardErrs(&in.wg, p, in.errs, in.stopChan)
+	return nil
+}
+
+// handle sends each event Message from the process as an Event, acknowledging it back to the process.
+func (in *Input) handle(m proc.Message) {
+	if m.Type != proc.TypeEvent {
+		in.error(fmt.Errorf("exec input received unexpected message type %q", m.Type))
+		return
+	}
+	id := m.ID
+	e := plugin.NewEvent(m.Data, func(err error) {
+		ack := proc.Message{ID: id, Type: proc.TypeAck}
+		if err != nil {
+			ack.Error = err.Error()
+		}
+		if err := in.proc.Send(ack); err != nil && !errors.Is(err, proc.ErrStopped) {
+			in.error(fmt.Errorf("exec input could not ack event %d: %w", id, err))
+		}
+	})
+	select {
+	case <-in.stopChan:
+	case in.data <- e:
+	}
+}
+
+func (in *Input) error(err error) {
+	select {
+	case in.errs <- err:
+	default:
+	}
+}
+
+// Stop stops the plugin.
+func (in *Input) Stop() error {
+	close(in.stopChan)
+	var err error
+	if in.proc != nil {
+		err = in.proc.Stop()
+	}
+	in.wg.Wait()
+	return err
+}
+
+// Source returns the channel of Events read from the process.
+func (in *Input) Source() <-chan plugin.Event {
+	return in.data
+}
+
+// Errors returns the error channel for the Input Plugin.
+func (in *Input) Errors() <-chan error {
+	return in.errs
+}
+
+// Output writes Events to a child process.
+type Output struct {
+	cfg      proc.Config
+	proc     *proc.Process
+	data     chan plugin.Event
+	errs     chan error
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+}
+
+// Start starts the plugin.
+func (out *Output) Start() error {
+	p, err := proc.Start(out.cfg, nil)
+	if err != nil {
+		return err
+	}
+	out.proc = p
+	out.wg.Add(2)
+	go forwardErrs(&out.wg, p, out.errs, out.stopChan)
+	go func() {
+		defer out.wg.Done()
+		for {
+			select {
+			case <-out.stopChan:
+				return
+			case e := <-out.data:
+				out.proc.CallAsync(proc.Message{Type: proc.TypeEvent, Data: e.Data}, func(_ proc.Message, err error) {
+					e.Ack(err)
+				})
+			}
+		}
+	}()
+	return nil
+}
+
+// Stop stops the plugin.
+func (out *Output) Stop() error {
+	close(out.stopChan)
+	out.wg.Wait()
+	if out.proc != nil {
+		return out.proc.Stop()
+	}
+	return nil
+}
+
+// Destination returns the channel used for accept data to the intended Plugin destination.
+func (out *Output) Destination() chan<- plugin.Event {
+	return out.data
+}
+
+// Errors returns the error channel for the Output Plugin.
+func (out *Output) Errors() <-chan error {
+	return out.errs
+}
+
+// forwardErrs sends errors from the process to errs until stopped.
+func forwardErrs(wg *sync.WaitGroup, p *proc.Process, errs chan<- error, stop <-chan struct{}) {
+	defer wg.Done()
+	for {
+		select {
+		case <-stop:
+			return
+		case err := <-p.Errors():
+			select {
+			case errs <- err:
+			default:
+			}
+		}
+	}
+}
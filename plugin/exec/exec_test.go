@@ -0,0 +1,137 @@
+package exec
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/jbvmio/lfm/internal/proc"
+	"github.com/jbvmio/lfm/plugin"
+)
+
+// helperEnv marks the test binary as running as a helper child process.
+const helperEnv = `LFM_HELPER_PROCESS`
+
+func helperConfig(mode string) proc.Config {
+	return proc.Config{
+		Command:    []string{os.Args[0], `-test.run=TestHelperProcess`, `--`, mode},
+		Env:        map[string]string{helperEnv: `1`},
+		Timeout:    5 * time.Second,
+		MinBackoff: 10 * time.Millisecond,
+		MaxBackoff: 10 * time.Millisecond,
+	}
+}
+
+// TestHelperProcess is run as the child process by the other tests. Depending on the mode, output responds
+// to each event, failing those with the data fail, silent never responds and input sends an event,
+// followed by an event containing the error of its ack once received.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv(helperEnv) != `1` {
+		return
+	}
+	defer os.Exit(0)
+	mode := os.Args[len(os.Args)-1]
+	r, w := bufio.NewReader(os.Stdin), bufio.NewWriter(os.Stdout)
+	if mode == `input` {
+		proc.WriteFrame(w, proc.Message{ID: 1, Type: proc.TypeEvent, Data: []byte(`line`)})
+		w.Flush()
+	}
+	for {
+		m, err := proc.ReadFrame(r)
+		if err != nil {
+			return
+		}
+		switch mode {
+		case `silent`:
+			continue
+		case `input`:
+			proc.WriteFrame(w, proc.Message{ID: m.ID + 1, Type: proc.TypeEvent, Data: []byte(`ack: ` + m.Error)})
+		default:
+			resp := proc.Message{ID: m.ID}
+			if string(m.Data) == `fail` {
+				resp.Error = `failed`
+			}
+			proc.WriteFrame(w, resp)
+		}
+		w.Flush()
+	}
+}
+
+func startOutput(t *testing.T, cfg proc.Config) plugin.Output {
+	t.Helper()
+	c := &OutputConfig{Config: cfg}
+	out, err := c.CreateOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := out.Start(); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func send(t *testing.T, out plugin.Output, data string) error {
+	t.Helper()
+	acked := make(chan error, 1)
+	out.Destination() <- plugin.NewEvent([]byte(data), func(err error) { acked <- err })
+	select {
+	case err := <-acked:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatal("event was not acknowledged")
+	}
+	return nil
+}
+
+func TestOutput(t *testing.T) {
+	out := startOutput(t, helperConfig(`output`))
+	defer out.Stop()
+	if err := send(t, out, `line`); err != nil {
+		t.Fatalf("expected the event to be delivered, got %v", err)
+	}
+	if err := send(t, out, `fail`); err == nil || err.Error() != `failed` {
+		t.Fatalf("expected the event to fail, got %v", err)
+	}
+}
+
+func TestOutputTimeout(t *testing.T) {
+	cfg := helperConfig(`silent`)
+	cfg.Timeout = 200 * time.Millisecond
+	out := startOutput(t, cfg)
+	defer out.Stop()
+	if err := send(t, out, `line`); !errors.Is(err, proc.ErrTimeout) {
+		t.Fatalf("expected %v, got %v", proc.ErrTimeout, err)
+	}
+}
+
+func TestInput(t *testing.T) {
+	c := &InputConfig{Config: helperConfig(`input`), Buffer: 10}
+	in, err := c.CreateInput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := in.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer in.Stop()
+	receive := func() plugin.Event {
+		t.Helper()
+		select {
+		case e := <-in.Source():
+			return e
+		case <-time.After(5 * time.Second):
+			t.Fatal("expected an event")
+		}
+		return plugin.Event{}
+	}
+	e := receive()
+	if string(e.Data) != `line` {
+		t.Fatalf("expected line, got %s", e.Data)
+	}
+	e.Ack(errors.New("failed"))
+	if e := receive(); string(e.Data) != `ack: failed` {
+		t.Fatalf("expected the process to receive the ack error, got %s", e.Data)
+	}
+}
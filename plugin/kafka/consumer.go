@@ -0,0 +1,141 @@
+package kafka
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/jbvmio/lfm/plugin"
+)
+
+// Commit modes for the Input Plugin.
+const (
+	CommitAuto   = `auto`
+	CommitManual = `manual`
+)
+
+// Failure policies for the Input Plugin when using manual commits.
+const (
+	FailureHold   = `hold`
+	FailureCommit = `commit`
+)
+
+// revokeTimeout is how long a claim waits for its in-flight Events to be acknowledged when its partition is
+// revoked during a rebalance, allowing their offsets to be committed before another consumer takes over.
+const revokeTimeout = 10 * time.Second
+
+// consumerHandler provides the messages of each claimed partition as Events.
+type consumerHandler struct {
+	processor    *kafkaProcessor
+	manual       bool
+	commitFailed bool
+	maxInFlight  int
+	paused       int32
+	errs         chan error
+	stopChan     chan struct{}
+}
+
+// Setup is run at the beginning of a new session, before ConsumeClaim.
+func (h *consumerHandler) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// Cleanup is run at the end of a session, once all ConsumeClaim goroutines have exited.
+func (h *consumerHandler) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+// ConsumeClaim provides the messages of the claimed partition until the session ends.
+func (h *consumerHandler) ConsumeClaim(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	if !h.manual {
+		for msg := range claim.Messages() {
+			if !h.processor.processMSG(sess.Context(), msg, nil) {
+				return nil
+			}
+			sess.MarkMessage(msg, "")
+		}
+		return nil
+	}
+	return h.consumeManual(sess, claim)
+}
+
+// consumeManual provides the messages of the claimed partition, marking the highest offset for which the
+// Event and every Event before it were done. Events acknowledged without error are done, as are Events
+// acknowledged with an error when failed Events are committed. Otherwise a failed Event holds back the offset
+// of the partition, so it is consumed again once the partition is next claimed, and the partition is paused
+// once maxInFlight Events are tracked behind it.
+func (h *consumerHandler) consumeManual(sess sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	tracker := plugin.NewOffsetTracker(claim.InitialOffset() - 1)
+	acked := make(chan struct{}, 1)
+	var outstanding, held int64
+	paused := false
+	defer func() {
+		if paused {
+			atomic.AddInt32(&h.paused, -1)
+		}
+	}()
+consume:
+	for msg := range claim.Messages() {
+		// Acknowledged Events remain tracked until every Event before them is done, limiting the Events tracked.
+		for tracker.Pending() >= h.maxInFlight {
+			if !paused && atomic.LoadInt64(&held) > 0 {
+				paused = true
+				atomic.AddInt32(&h.paused, 1)
+				h.report(fmt.Errorf("paused topic %s partition %d at offset %d, %d events are held back by a failed event until the partition is next claimed", msg.Topic, msg.Partition, msg.Offset, tracker.Pending()))
+			}
+			select {
+			case <-acked:
+			case <-sess.Context().Done():
+				break consume
+			}
+		}
+		id := tracker.Add(msg.Offset)
+		atomic.AddInt64(&outstanding, 1)
+		topic, partition, offset := msg.Topic, msg.Partition, msg.Offset
+		ack := func(err error) {
+			defer func() {
+				atomic.AddInt64(&outstanding, -1)
+				select {
+				case acked <- struct{}{}:
+				default:
+				}
+			}()
+			if err != nil {
+				if !h.commitFailed {
+					atomic.AddInt64(&held, 1)
+					h.report(fmt.Errorf("event for topic %s partition %d offset %d failed, holding back commits for the partition: %w", topic, partition, offset, err))
+					return
+				}
+				h.report(fmt.Errorf("event for topic %s partition %d offset %d failed, committing: %w", topic, partition, offset, err))
+			}
+			if committed, ok := tracker.Done(id); ok {
+				sess.MarkOffset(topic, partition, committed+1, "")
+			}
+		}
+		if !h.processor.processMSG(sess.Context(), msg, ack) {
+			atomic.AddInt64(&outstanding, -1)
+			break
+		}
+	}
+	timeout := time.NewTimer(revokeTimeout)
+	defer timeout.Stop()
+	for atomic.LoadInt64(&outstanding) > 0 {
+		select {
+		case <-acked:
+		case <-timeout.C:
+			return nil
+		case <-h.stopChan:
+			// Unacknowledged Events are consumed again after a restart.
+			return nil
+		}
+	}
+	return nil
+}
+
+func (h *consumerHandler) report(err error) {
+	select {
+	case h.errs <- err:
+	default:
+	}
+}
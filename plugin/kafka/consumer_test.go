@@ -0,0 +1,160 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/jbvmio/lfm/plugin"
+)
+
+// testSession records the offsets marked during a session.
+type testSession struct {
+	ctx    context.Context
+	lock   sync.Mutex
+	marked []int64
+}
+
+func (s *testSession) Claims() map[string][]int32                  { return nil }
+func (s *testSession) MemberID() string                            { return `test` }
+func (s *testSession) GenerationID() int32                         { return 1 }
+func (s *testSession) Commit()                                     {}
+func (s *testSession) ResetOffset(string, int32, int64, string)    {}
+func (s *testSession) MarkMessage(*sarama.ConsumerMessage, string) {}
+func (s *testSession) Context() context.Context                    { return s.ctx }
+func (s *testSession) MarkOffset(_ string, _ int32, offset int64, _ string) {
+	s.lock.Lock()
+	s.marked = append(s.marked, offset)
+	s.lock.Unlock()
+}
+
+func (s *testSession) last() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if len(s.marked) == 0 {
+		return -1
+	}
+	return s.marked[len(s.marked)-1]
+}
+
+// testClaim provides messages for offsets from its initial offset.
+type testClaim struct {
+	initial int64
+	msgs    chan *sarama.ConsumerMessage
+}
+
+func newTestClaim(initial int64, n int) *testClaim {
+	c := &testClaim{initial: initial, msgs: make(chan *sarama.ConsumerMessage, n)}
+	for i := 0; i < n; i++ {
+		c.msgs <- &sarama.ConsumerMessage{Topic: `logs`, Partition: 0, Offset: initial + int64(i)}
+	}
+	return c
+}
+
+func (c *testClaim) Topic() string                            { return `logs` }
+func (c *testClaim) Partition() int32                         { return 0 }
+func (c *testClaim) InitialOffset() int64                     { return c.initial }
+func (c *testClaim) HighWaterMarkOffset() int64               { return c.initial + int64(cap(c.msgs)) }
+func (c *testClaim) Messages() <-chan *sarama.ConsumerMessage { return c.msgs }
+
+func newTestHandler(maxInFlight int, commitFailed bool) *consumerHandler {
+	stopChan := make(chan struct{})
+	return &consumerHandler{
+		processor:    newKafkaProcessor(make(chan plugin.Event), stopChan),
+		manual:       true,
+		commitFailed: commitFailed,
+		maxInFlight:  maxInFlight,
+		errs:         make(chan error, 10),
+		stopChan:     stopChan,
+	}
+}
+
+func receive(t *testing.T, h *consumerHandler, n int) []plugin.Event {
+	t.Helper()
+	events := make([]plugin.Event, n)
+	for i := range events {
+		select {
+		case events[i] = <-h.processor.dataChan:
+		case <-time.After(time.Second):
+			t.Fatalf("expected %d events, received %d", n, i)
+		}
+	}
+	return events
+}
+
+func TestConsumeManualOutOfOrder(t *testing.T) {
+	h := newTestHandler(10, false)
+	sess := &testSession{ctx: context.Background()}
+	claim := newTestClaim(10, 5)
+	done := make(chan error)
+	go func() { done <- h.consumeManual(sess, claim) }()
+
+	events := receive(t, h, 5)
+	for _, x := range []struct {
+		event  int
+		marked int64
+	}{{2, -1}, {0, 11}, {1, 13}, {4, 13}, {3, 15}} {
+		events[x.event].Ack(nil)
+		if got := sess.last(); got != x.marked {
+			t.Fatalf("expected marked offset %d after acknowledging offset %d, got %d", x.marked, 10+x.event, got)
+		}
+	}
+	close(claim.msgs)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestConsumeManualHoldPausesPartition(t *testing.T) {
+	h := newTestHandler(2, false)
+	ctx, cancel := context.WithCancel(context.Background())
+	sess := &testSession{ctx: ctx}
+	claim := newTestClaim(0, 5)
+	done := make(chan error)
+	go func() { done <- h.consumeManual(sess, claim) }()
+
+	events := receive(t, h, 2)
+	events[0].Ack(errors.New("failed"))
+	events[1].Ack(nil)
+	select {
+	case e := <-h.processor.dataChan:
+		t.Fatalf("expected the partition to be paused, received offset %s", e.Metadata[MetaOffset])
+	case <-time.After(50 * time.Millisecond):
+	}
+	if paused := atomic.LoadInt32(&h.paused); paused != 1 {
+		t.Fatalf("expected 1 paused partition, got %d", paused)
+	}
+	if got := sess.last(); got != -1 {
+		t.Fatalf("expected no marked offset behind a failed event, got %d", got)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if paused := atomic.LoadInt32(&h.paused); paused != 0 {
+		t.Fatalf("expected no paused partitions once the claim ended, got %d", paused)
+	}
+}
+
+func TestConsumeManualCommitFailed(t *testing.T) {
+	h := newTestHandler(1, true)
+	sess := &testSession{ctx: context.Background()}
+	claim := newTestClaim(0, 3)
+	done := make(chan error)
+	go func() { done <- h.consumeManual(sess, claim) }()
+
+	receive(t, h, 1)[0].Ack(errors.New("failed"))
+	receive(t, h, 1)[0].Ack(nil)
+	receive(t, h, 1)[0].Ack(errors.New("failed"))
+	close(claim.msgs)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := sess.last(); got != 3 {
+		t.Fatalf("expected marked offset 3, got %d", got)
+	}
+}
@@ -1,18 +1,23 @@
 package kafka
 
 import (
+	"context"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
-	"os"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/Shopify/sarama"
 	kctl "github.com/jbvmio/kafka"
 	"github.com/jbvmio/lfm/plugin"
 	"gopkg.in/yaml.v2"
 )
 
+// lagInterval is how often consumer lag is recorded.
+const lagInterval = 15 * time.Second
+
 // InputConfig contains configuration details when using the Input Plugin.
 type InputConfig struct {
 	Brokers     []string `yaml:"brokers" json:"brokers"`
@@ -21,6 +26,20 @@ type InputConfig struct {
 	DeleteGroup bool     `yaml:"deleteGroup" json:"deleteGroup"`
 	StartOldest bool     `yaml:"startOldest" json:"startOldest"`
 	Threads     int      `yaml:"threads" json:"threads"`
+	// Commit is auto, committing messages once read, or manual, committing the offset of a message only once its
+	// Event and every earlier Event on the partition were delivered or intentionally discarded, so unconfirmed
+	// messages are consumed again after a restart. Defaults to auto.
+	Commit string `yaml:"commit" json:"commit"`
+	// CommitInterval is how often marked offsets are committed, defaults to 1s.
+	CommitInterval time.Duration `yaml:"commitInterval" json:"commitInterval"`
+	// MaxInFlight limits the unconfirmed Events per partition when using manual commits, defaults to 1000.
+	MaxInFlight int `yaml:"maxInFlight" json:"maxInFlight"`
+	// OnFailure is hold or commit, deciding how Events acknowledged with an error are handled using manual commits.
+	// Events sent to a dead letter output are not failed. hold keeps the offset from advancing past a failed Event,
+	// which is consumed again once the partition is next claimed, and pauses the partition once MaxInFlight Events
+	// are waiting behind it. commit allows the offset to advance past failed Events. Defaults to hold.
+	OnFailure string `yaml:"onFailure" json:"onFailure"`
+	Security  `yaml:",inline"`
 }
 
 // Configure attempts to configure the Config based on the details entered.
@@ -45,19 +64,49 @@ func (c *InputConfig) Configure(details map[string]interface{}) error {
 	if c.Threads == 0 {
 		c.Threads = 1
 	}
+	switch c.Commit {
+	case "":
+		c.Commit = CommitAuto
+	case CommitAuto, CommitManual:
+	default:
+		return fmt.Errorf("invalid commit %q for kctl input, must be %s or %s", c.Commit, CommitAuto, CommitManual)
+	}
+	if c.MaxInFlight == 0 {
+		c.MaxInFlight = defaultBuffer
+	}
+	switch c.OnFailure {
+	case "":
+		c.OnFailure = FailureHold
+	case FailureHold, FailureCommit:
+	default:
+		return fmt.Errorf("invalid onFailure %q for kctl input, must be %s or %s", c.OnFailure, FailureHold, FailureCommit)
+	}
+	if err := c.Security.validate(); err != nil {
+		return fmt.Errorf("invalid security for kctl input: %w", err)
+	}
 	return nil
 }
 
-// CreateInput creates an Input based on the Config.
-func (c *InputConfig) CreateInput() (plugin.Input, error) {
-	hn, err := os.Hostname()
+// consumerConfig returns the client config for the consumer group.
+func (c *InputConfig) consumerConfig() (*sarama.Config, error) {
+	conf, err := newConfig(c.Security)
 	if err != nil {
-		hn = "undiscovered-host"
+		return nil, err
 	}
-	conf := kctl.GetConf(hn + `-` + makeHex(6))
-	conf.Version = useKafkaVersion
 	if c.StartOldest {
-		conf.Consumer.Offsets.Initial = -2
+		conf.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+	if c.CommitInterval > 0 {
+		conf.Consumer.Offsets.AutoCommit.Interval = c.CommitInterval
+	}
+	return conf, nil
+}
+
+// CreateInput creates an Input based on the Config.
+func (c *InputConfig) CreateInput() (plugin.Input, error) {
+	conf, err := c.consumerConfig()
+	if err != nil {
+		return nil, fmt.Errorf("kafka could not configure client: %w", err)
 	}
 	client, err := kctl.NewCustomClient(conf, c.Brokers...)
 	if err != nil {
@@ -74,63 +123,114 @@ func (c *InputConfig) CreateInput() (plugin.Input, error) {
 	if ok := topicsExist(client, topicsList...); !ok {
 		return nil, fmt.Errorf("kafka could not validate input topics")
 	}
-	stopped := new(bool)
-	dataChan := make(chan []byte, defaultBuffer)
-	processor := newKafkaProcessor(dataChan, stopped)
-	consumers := make([]*kctl.ConsumerGroup, c.Threads)
+	dataChan := make(chan plugin.Event, defaultBuffer)
+	errChan := make(chan error, defaultBuffer)
+	stopChan := make(chan struct{})
+	processor := newKafkaProcessor(dataChan, stopChan)
+	consumers := make([]sarama.ConsumerGroup, c.Threads)
 	for i := 0; i < c.Threads; i++ {
-		cfg := kctl.GetConf(hn + `-` + makeHex(6))
-		consumer, err := kctl.NewConsumerGroup(c.Brokers, c.Group, cfg, topicsList...)
+		conf, err := c.consumerConfig()
+		if err == nil {
+			consumers[i], err = sarama.NewConsumerGroup(c.Brokers, c.Group, conf)
+		}
 		if err != nil {
+			for _, x := range consumers[:i] {
+				x.Close()
+			}
+			client.Close()
 			return nil, fmt.Errorf("kafka could not create consumer: %w", err)
 		}
-		consumer.GETALL(processor.processMSG)
-		consumers[i] = consumer
 	}
 	return &Input{
-		client:        client,
+		client:    client,
+		processor: processor,
+		handler: &consumerHandler{
+			processor:    processor,
+			manual:       c.Commit == CommitManual,
+			commitFailed: c.OnFailure == FailureCommit,
+			maxInFlight:  c.MaxInFlight,
+			errs:         errChan,
+			stopChan:     stopChan,
+		},
 		consumers:     consumers,
+		topics:        topicsList,
 		group:         c.Group,
 		deleteGroup:   c.DeleteGroup,
 		data:          dataChan,
-		errs:          make(chan error, defaultBuffer),
-		stopChan:      make(chan struct{}),
+		errs:          errChan,
+		stopChan:      stopChan,
 		cgStoppedChan: make(chan int, c.Threads),
-		stopped:       stopped,
 	}, nil
 }
 
 // Input works with data contained in Kafka Topics as Input.
 type Input struct {
 	client        *kctl.KClient
-	consumers     []*kctl.ConsumerGroup
+	processor     *kafkaProcessor
+	handler       *consumerHandler
+	consumers     []sarama.ConsumerGroup
+	topics        []string
 	group         string
 	deleteGroup   bool
-	data          chan []byte
+	data          chan plugin.Event
 	errs          chan error
 	stopChan      chan struct{}
 	cgStoppedChan chan int
-	stopped       *bool
+	running       int32
+	started       bool
 }
 
 // Start starts the plugin.
 // TODO: Create a "watcher" to restart CG as needed ...
 func (in *Input) Start() error {
+	in.started = true
 	for i := 0; i < len(in.consumers); i++ {
-		go func(id int, stoppedChan chan int, consumer *kctl.ConsumerGroup) {
-			err := consumer.Consume()
+		atomic.AddInt32(&in.running, 1)
+		go func(id int, stoppedChan chan int, consumer sarama.ConsumerGroup) {
+			err := in.consume(consumer)
+			atomic.AddInt32(&in.running, -1)
 			if err != nil {
 				in.errs <- err
 			}
 			stoppedChan <- id
 		}(i, in.cgStoppedChan, in.consumers[i])
 	}
+	go in.monitorLag()
 	return nil
 }
 
+// consume joins the consumer group, consuming a new session after each rebalance until the Input is stopped.
+func (in *Input) consume(consumer sarama.ConsumerGroup) error {
+	for {
+		err := consumer.Consume(context.Background(), in.topics, in.handler)
+		select {
+		case <-in.stopChan:
+			return nil
+		default:
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// monitorLag periodically records the consumer lag for the group until stopped.
+func (in *Input) monitorLag() {
+	ticker := time.NewTicker(lagInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-in.stopChan:
+			return
+		case <-ticker.C:
+			in.processor.recordLag(in.client, in.group)
+		}
+	}
+}
+
 // Stop stops the plugin.
 func (in *Input) Stop() error {
-	*in.stopped = true
+	close(in.stopChan)
 	var err error
 	var errMsg string
 	for i := 0; i < len(in.consumers); i++ {
@@ -141,7 +241,7 @@ func (in *Input) Stop() error {
 	}
 	to := time.NewTimer(time.Second * 15)
 cgStop:
-	for i := 0; i < len(in.consumers); i++ {
+	for i := 0; in.started && i < len(in.consumers); i++ {
 		select {
 		case <-to.C:
 			errMsg += "timed out waiting for consumers to stop: "
@@ -165,8 +265,20 @@ cgStop:
 	return err
 }
 
+// Health returns an error if any of the consumer group threads have stopped, or any partitions are paused
+// by failed Events.
+func (in *Input) Health() error {
+	if running := atomic.LoadInt32(&in.running); int(running) < len(in.consumers) {
+		return fmt.Errorf("%d of %d consumers running for group %s", running, len(in.consumers), in.group)
+	}
+	if paused := atomic.LoadInt32(&in.handler.paused); paused > 0 {
+		return fmt.Errorf("%d partitions paused by failed events for group %s", paused, in.group)
+	}
+	return nil
+}
+
 // Source returns the oncoming data channel for the Input Plugin.
-func (in *Input) Source() <-chan []byte {
+func (in *Input) Source() <-chan plugin.Event {
 	return in.data
 }
 
@@ -1,83 +1,201 @@
 package kafka
 
 import (
+	"context"
 	"fmt"
+	"os"
 	"regexp"
+	"strconv"
 	"sync"
+	"time"
 
+	"github.com/Shopify/sarama"
 	kctl "github.com/jbvmio/kafka"
+	"github.com/jbvmio/lfm/metrics"
+	"github.com/jbvmio/lfm/plugin"
 )
 
+func init() {
+	plugin.RegisterInput(`kafka`, func() plugin.InputConfig { return &InputConfig{} })
+	plugin.RegisterOutput(`kafka`, func() plugin.OutputConfig { return &OutputConfig{} })
+}
+
 var useKafkaVersion = kctl.VER210KafkaVersion
 
+// newConfig returns a client config with a unique client ID for this host using the Security options.
+func newConfig(sec Security) (*sarama.Config, error) {
+	hn, err := os.Hostname()
+	if err != nil {
+		hn = "undiscovered-host"
+	}
+	conf := kctl.GetConf(hn + `-` + makeHex(6))
+	conf.Version = useKafkaVersion
+	if err := sec.apply(conf); err != nil {
+		return nil, err
+	}
+	return conf, nil
+}
+
 type kafkaProducer struct {
-	producer *kctl.Producer
-	topic    string
-	dataChan chan []byte
-	stopChan chan struct{}
+	producer sarama.AsyncProducer
 	errs     chan error
 }
 
-func newKafkaProducer(producer *kctl.Producer, topic string, stopChan chan struct{}, errs chan error) kafkaProducer {
-	return kafkaProducer{
+func newKafkaProducer(producer sarama.AsyncProducer, errs chan error) *kafkaProducer {
+	return &kafkaProducer{
 		producer: producer,
-		topic:    topic,
-		dataChan: make(chan []byte, defaultBuffer),
-		stopChan: stopChan,
 		errs:     errs,
 	}
 }
 
+// produce acknowledges the sent messages with their results until the producer is closed.
 func (p *kafkaProducer) produce(wg *sync.WaitGroup) {
 	go func() {
 		defer wg.Done()
-	produceLoop:
-		for {
+		errs, successes := p.producer.Errors(), p.producer.Successes()
+		for errs != nil || successes != nil {
 			select {
-			case <-p.stopChan:
-				break produceLoop
-			case e := <-p.producer.Errors():
-				p.errs <- fmt.Errorf("producer for topic %s error: %w", p.topic, e.Err)
-			case <-p.producer.Successes():
-				// optionally add debug messages here.
+			case e, ok := <-errs:
+				if !ok {
+					errs = nil
+					continue
+				}
+				metrics.KafkaProduced.WithLabelValues(e.Msg.Topic, metrics.ResultFailed).Inc()
+				err := fmt.Errorf("producer for topic %s error: %w", e.Msg.Topic, e.Err)
+				if ack, ok := e.Msg.Metadata.(func(error)); ok {
+					ack(err)
+				}
+				select {
+				case p.errs <- err:
+				default:
+				}
+			case m, ok := <-successes:
+				if !ok {
+					successes = nil
+					continue
+				}
+				metrics.KafkaProduced.WithLabelValues(m.Topic, metrics.ResultDelivered).Inc()
+				if ack, ok := m.Metadata.(func(error)); ok {
+					ack(nil)
+				}
 			}
 		}
-		fmt.Printf("producer for topic %s stopped.\n", p.topic)
+		fmt.Println("kafka producer stopped.")
 	}()
 }
 
-func (p *kafkaProducer) send(b []byte) {
-	go func() {
-		// incorporate timeout here if needed.
-		p.producer.Input() <- &kctl.Message{
-			Topic: p.topic,
-			Value: b,
-		}
-	}()
+// send produces the msg, calling ack once the message has been acknowledged by the producer,
+// or with an error if stopped first.
+func (p *kafkaProducer) send(msg *sarama.ProducerMessage, ack func(error), stopChan chan struct{}) {
+	msg.Metadata = ack
+	select {
+	case p.producer.Input() <- msg:
+	case <-stopChan:
+		ack(fmt.Errorf("kafka output stopped before producing to topic %s", msg.Topic))
+	}
+}
+
+// close closes the producer once any buffered messages are produced. No messages may be sent afterwards.
+func (p *kafkaProducer) close() {
+	p.producer.AsyncClose()
 }
 
 type kafkaProcessor struct {
-	dataChan chan []byte
-	stopped  *bool
+	dataChan chan plugin.Event
+	stopChan chan struct{}
+	lock     sync.Mutex
+	offsets  map[string]map[int32]int64
 }
 
-func newKafkaProcessor(dataChan chan []byte, stopped *bool) *kafkaProcessor {
+func newKafkaProcessor(dataChan chan plugin.Event, stopChan chan struct{}) *kafkaProcessor {
 	return &kafkaProcessor{
 		dataChan: dataChan,
-		stopped:  stopped,
+		stopChan: stopChan,
+		offsets:  make(map[string]map[int32]int64),
 	}
 }
 
-// ProcessMSG processes a Kafka msg.
-func (p *kafkaProcessor) processMSG(msg *kctl.Message) (bool, error) {
-	switch {
-	case *p.stopped:
-		fmt.Println("IS STOPPED")
-		return false, nil
-	default:
-		p.dataChan <- msg.Value
-		return true, nil
+// consumed records the offset of a consumed msg.
+func (p *kafkaProcessor) consumed(msg *sarama.ConsumerMessage) {
+	p.lock.Lock()
+	if p.offsets[msg.Topic] == nil {
+		p.offsets[msg.Topic] = make(map[int32]int64)
+	}
+	p.offsets[msg.Topic][msg.Partition] = msg.Offset
+	p.lock.Unlock()
+}
+
+// recordLag updates the consumer lag metrics for each consumed topic partition.
+func (p *kafkaProcessor) recordLag(client *kctl.KClient, group string) {
+	p.lock.Lock()
+	offsets := make(map[string]map[int32]int64, len(p.offsets))
+	for topic, parts := range p.offsets {
+		offsets[topic] = make(map[int32]int64, len(parts))
+		for part, offset := range parts {
+			offsets[topic][part] = offset
+		}
+	}
+	p.lock.Unlock()
+	for topic, parts := range offsets {
+		for part, offset := range parts {
+			newest, err := client.GetOffsetNewest(topic, part)
+			if err != nil {
+				continue
+			}
+			lag := newest - (offset + 1)
+			if lag < 0 {
+				lag = 0
+			}
+			metrics.KafkaConsumerLag.WithLabelValues(group, topic, strconv.Itoa(int(part))).Set(float64(lag))
+		}
+	}
+}
+
+// processMSG provides a Kafka msg as an Event with the given ack func, returning false if the
+// Input was stopped or the context done first.
+func (p *kafkaProcessor) processMSG(ctx context.Context, msg *sarama.ConsumerMessage, ack func(error)) bool {
+	select {
+	case <-p.stopChan:
+		return false
+	case <-ctx.Done():
+		return false
+	case p.dataChan <- messageEvent(msg, ack):
+		p.consumed(msg)
+		return true
+	}
+}
+
+// Metadata keys of the Events provided by the Input Plugin, available to Drivers as tmpVars.
+// Each message header is also provided using MetaHeaderPrefix followed by the header key.
+const (
+	MetaTopic        = `kafka.topic`
+	MetaPartition    = `kafka.partition`
+	MetaOffset       = `kafka.offset`
+	MetaKey          = `kafka.key`
+	MetaTimestamp    = `kafka.timestamp`
+	MetaHeaderPrefix = `kafka.header.`
+)
+
+// messageEvent returns an Event for the msg with its metadata.
+func messageEvent(msg *sarama.ConsumerMessage, ack func(error)) plugin.Event {
+	meta := make(map[string]string, 5+len(msg.Headers))
+	meta[MetaTopic] = msg.Topic
+	meta[MetaPartition] = strconv.Itoa(int(msg.Partition))
+	meta[MetaOffset] = strconv.FormatInt(msg.Offset, 10)
+	if msg.Key != nil {
+		meta[MetaKey] = string(msg.Key)
+	}
+	if !msg.Timestamp.IsZero() {
+		meta[MetaTimestamp] = msg.Timestamp.UTC().Format(time.RFC3339Nano)
+	}
+	for _, h := range msg.Headers {
+		if h != nil {
+			meta[MetaHeaderPrefix+string(h.Key)] = string(h.Value)
+		}
 	}
+	e := plugin.NewEvent(msg.Value, ack)
+	e.Metadata = meta
+	return e
 }
 
 // DeleteCG deletes a consumer group.
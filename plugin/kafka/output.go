@@ -2,22 +2,75 @@ package kafka
 
 import (
 	"fmt"
-	"os"
+	"sort"
 	"sync"
+	"time"
 
+	"github.com/Shopify/sarama"
 	kctl "github.com/jbvmio/kafka"
 	"github.com/jbvmio/lfm/plugin"
+	"github.com/tidwall/gjson"
 	"gopkg.in/yaml.v2"
 )
 
 const (
 	defaultBuffer = 1000
+	// tagsKey is the key of the tags object within the data of Events.
+	tagsKey = `tags`
 )
 
+// Compression codecs available to the Output Plugin.
+var compressionCodecs = map[string]sarama.CompressionCodec{
+	`none`:   sarama.CompressionNone,
+	`gzip`:   sarama.CompressionGZIP,
+	`snappy`: sarama.CompressionSnappy,
+	`lz4`:    sarama.CompressionLZ4,
+	`zstd`:   sarama.CompressionZSTD,
+}
+
+// Required acks available to the Output Plugin.
+var requiredAcks = map[string]sarama.RequiredAcks{
+	`none`:   sarama.NoResponse,
+	`leader`: sarama.WaitForLocal,
+	`all`:    sarama.WaitForAll,
+}
+
 // OutputConfig contains configuration details when using the KafkaOutput Plugin.
 type OutputConfig struct {
 	Brokers []string `yaml:"brokers" json:"brokers"`
 	Topics  []string `yaml:"topics" json:"topics"`
+	// Topic routes each Event to a single topic instead of Topics, replacing each {{path}} within Topic with
+	// the value of the JSON path, such as logs-{{tags.app}}.
+	Topic string `yaml:"topic" json:"topic"`
+	// FallbackTopic is used for routed Events without a value for each path or a valid topic name,
+	// and for routed topics which do not exist when MissingTopic is fallback.
+	FallbackTopic string `yaml:"fallbackTopic" json:"fallbackTopic"`
+	// MissingTopic is the policy for routed topics which do not exist, one of error, fallback or create,
+	// defaults to error. Created topics use TopicPartitions and TopicReplication, both defaulting to 1.
+	// Topics created or deleted elsewhere are noticed within 10 seconds.
+	MissingTopic     string `yaml:"missingTopic" json:"missingTopic"`
+	TopicPartitions  int32  `yaml:"topicPartitions" json:"topicPartitions"`
+	TopicReplication int16  `yaml:"topicReplication" json:"topicReplication"`
+	// Key is a JSON path to the value used as the message key, or KeyTag the name of the tag used.
+	// Messages with the same key are produced to the same partition, otherwise partitions are chosen at random.
+	Key    string `yaml:"key" json:"key"`
+	KeyTag string `yaml:"keyTag" json:"keyTag"`
+	// Headers maps the names of tags to the message headers they are produced as, using the tag name if empty.
+	Headers map[string]string `yaml:"headers" json:"headers"`
+	// Compression is one of none, gzip, snappy, lz4 or zstd, defaults to none.
+	Compression string `yaml:"compression" json:"compression"`
+	// RequiredAcks is the acknowledgement required from the brokers, one of none, leader or all.
+	// Defaults to leader, or all when Idempotent.
+	RequiredAcks string `yaml:"requiredAcks" json:"requiredAcks"`
+	Idempotent   bool   `yaml:"idempotent" json:"idempotent"`
+	// Linger is how long messages are buffered before being sent as a batch, unless BatchSize messages
+	// or BatchBytes are buffered first.
+	Linger     time.Duration `yaml:"linger" json:"linger"`
+	BatchSize  int           `yaml:"batchSize" json:"batchSize"`
+	BatchBytes int           `yaml:"batchBytes" json:"batchBytes"`
+	Security   `yaml:",inline"`
+	headerTags []string
+	topicParts []topicPart
 }
 
 // Configure attempts to configure the Config based on the details entered.
@@ -26,73 +79,226 @@ func (c *OutputConfig) Configure(details map[string]interface{}) error {
 	if err != nil {
 		return err
 	}
-	return yaml.Unmarshal(y, c)
+	err = yaml.Unmarshal(y, c)
+	if err != nil {
+		return err
+	}
+	if err := c.configureRouting(); err != nil {
+		return err
+	}
+	if c.Key != "" && c.KeyTag != "" {
+		return fmt.Errorf("key and keyTag cannot both be defined for kctl output")
+	}
+	if c.Compression == "" {
+		c.Compression = `none`
+	}
+	if _, ok := compressionCodecs[c.Compression]; !ok {
+		return fmt.Errorf("invalid compression %q for kctl output, must be one of none, gzip, snappy, lz4 or zstd", c.Compression)
+	}
+	switch {
+	case c.RequiredAcks == "" && c.Idempotent:
+		c.RequiredAcks = `all`
+	case c.RequiredAcks == "":
+		c.RequiredAcks = `leader`
+	}
+	if _, ok := requiredAcks[c.RequiredAcks]; !ok {
+		return fmt.Errorf("invalid requiredAcks %q for kctl output, must be one of none, leader or all", c.RequiredAcks)
+	}
+	if c.Idempotent && c.RequiredAcks != `all` {
+		return fmt.Errorf("idempotent kctl output requires requiredAcks all")
+	}
+	if c.Linger < 0 || c.BatchSize < 0 || c.BatchBytes < 0 {
+		return fmt.Errorf("linger, batchSize and batchBytes cannot be negative for kctl output")
+	}
+	c.headerTags = make([]string, 0, len(c.Headers))
+	for tag, header := range c.Headers {
+		if header == "" {
+			c.Headers[tag] = tag
+		}
+		c.headerTags = append(c.headerTags, tag)
+	}
+	sort.Strings(c.headerTags)
+	if err := c.Security.validate(); err != nil {
+		return fmt.Errorf("invalid security for kctl output: %w", err)
+	}
+	return nil
+}
+
+// configureRouting validates the topics and routing options.
+func (c *OutputConfig) configureRouting() error {
+	switch {
+	case len(c.Topics) == 0 && c.Topic == "":
+		return fmt.Errorf("missing topics or topic defined for kctl output")
+	case len(c.Topics) > 0 && c.Topic != "":
+		return fmt.Errorf("topics and topic cannot both be defined for kctl output")
+	case len(c.Topics) > 0:
+		if c.FallbackTopic != "" || c.MissingTopic != "" {
+			return fmt.Errorf("fallbackTopic and missingTopic require topic for kctl output")
+		}
+		return nil
+	}
+	parts, err := parseTopic(c.Topic)
+	if err != nil {
+		return fmt.Errorf("invalid topic %q for kctl output: %w", c.Topic, err)
+	}
+	c.topicParts = parts
+	if c.FallbackTopic != "" && !topicNameRegex.MatchString(c.FallbackTopic) {
+		return fmt.Errorf("invalid fallbackTopic %q for kctl output", c.FallbackTopic)
+	}
+	switch c.MissingTopic {
+	case "":
+		c.MissingTopic = MissingTopicError
+	case MissingTopicError, MissingTopicCreate:
+	case MissingTopicFallback:
+		if c.FallbackTopic == "" {
+			return fmt.Errorf("missingTopic %s requires fallbackTopic for kctl output", MissingTopicFallback)
+		}
+	default:
+		return fmt.Errorf("invalid missingTopic %q for kctl output, must be one of %s, %s or %s", c.MissingTopic, MissingTopicError, MissingTopicFallback, MissingTopicCreate)
+	}
+	if c.TopicPartitions == 0 {
+		c.TopicPartitions = 1
+	}
+	if c.TopicReplication == 0 {
+		c.TopicReplication = 1
+	}
+	if c.TopicPartitions < 0 || c.TopicReplication < 0 {
+		return fmt.Errorf("topicPartitions and topicReplication cannot be negative for kctl output")
+	}
+	return nil
+}
+
+// producerConfig returns the client config used by the producer.
+func (c *OutputConfig) producerConfig() (*sarama.Config, error) {
+	conf, err := newConfig(c.Security)
+	if err != nil {
+		return nil, err
+	}
+	// Successes are used to acknowledge delivered Events.
+	conf.Producer.Return.Successes = true
+	conf.Producer.Compression = compressionCodecs[c.Compression]
+	conf.Producer.RequiredAcks = requiredAcks[c.RequiredAcks]
+	if c.Idempotent {
+		conf.Producer.Idempotent = true
+		conf.Net.MaxOpenRequests = 1
+	}
+	conf.Producer.Flush.Frequency = c.Linger
+	conf.Producer.Flush.Messages = c.BatchSize
+	conf.Producer.Flush.Bytes = c.BatchBytes
+	return conf, conf.Validate()
 }
 
 // CreateOutput creates an Input based on the Config.
 func (c *OutputConfig) CreateOutput() (plugin.Output, error) {
-	hn, err := os.Hostname()
+	conf, err := c.producerConfig()
 	if err != nil {
-		hn = "undiscovered-host"
+		return nil, fmt.Errorf("kafka could not configure client: %w", err)
 	}
-	conf := kctl.GetConf(hn + `-` + makeHex(6))
-	conf.Version = useKafkaVersion
 	client, err := kctl.NewCustomClient(conf, c.Brokers...)
 	if err != nil {
 		return nil, fmt.Errorf("kafka could not create client: %w", err)
 	}
 	topicsList := filterUnique(c.Topics)
-	if ok := topicsExist(client, topicsList...); !ok {
+	// Routed topics are checked as they are used, only the fallback topic must exist.
+	checkTopics := topicsList
+	var router *topicRouter
+	if c.Topic != "" {
+		router = newTopicRouter(c, client)
+		checkTopics = nil
+		if c.FallbackTopic != "" {
+			checkTopics = []string{c.FallbackTopic}
+		}
+	}
+	if len(checkTopics) > 0 && !topicsExist(client, checkTopics...) {
+		client.Close()
 		return nil, fmt.Errorf("kafka could not validate output topics")
 	}
-	dataChan := make(chan []byte, defaultBuffer)
-	errChan := make(chan error, defaultBuffer)
-	stopChan := make(chan struct{})
-	producers := make([]kafkaProducer, len(topicsList))
-	for i := 0; i < len(topicsList); i++ {
-		P, err := client.NewProducer()
-		if err != nil {
-			return nil, fmt.Errorf("kafka could not create producer: %w", err)
-		}
-		producers[i] = newKafkaProducer(P, topicsList[i], stopChan, errChan)
+	P, err := sarama.NewAsyncProducer(c.Brokers, conf)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("kafka could not create producer: %w", err)
 	}
+	errChan := make(chan error, defaultBuffer)
 	return &Output{
-		client:    client,
-		producers: producers,
-		data:      dataChan,
-		errs:      errChan,
-		stopChan:  stopChan,
-		wg:        sync.WaitGroup{},
+		client:   client,
+		producer: newKafkaProducer(P, errChan),
+		topics:   topicsList,
+		router:   router,
+		message:  c.message,
+		data:     make(chan plugin.Event, defaultBuffer),
+		errs:     errChan,
+		stopChan: make(chan struct{}),
+		wg:       sync.WaitGroup{},
 	}, nil
 }
 
-// Output writes data out to a Kafka topic.
+// message returns a message for the data using the configured key and headers.
+func (c *OutputConfig) message(data []byte) *sarama.ProducerMessage {
+	msg := &sarama.ProducerMessage{
+		Value: sarama.ByteEncoder(data),
+	}
+	if c.Key != "" {
+		if r := gjson.GetBytes(data, c.Key); r.Exists() {
+			msg.Key = sarama.StringEncoder(r.String())
+		}
+	}
+	if c.KeyTag == "" && len(c.headerTags) == 0 {
+		return msg
+	}
+	tags := gjson.GetBytes(data, tagsKey).Map()
+	if r, ok := tags[c.KeyTag]; ok && c.KeyTag != "" {
+		msg.Key = sarama.StringEncoder(r.String())
+	}
+	for _, tag := range c.headerTags {
+		if r, ok := tags[tag]; ok {
+			msg.Headers = append(msg.Headers, sarama.RecordHeader{
+				Key:   []byte(c.Headers[tag]),
+				Value: []byte(r.String()),
+			})
+		}
+	}
+	return msg
+}
+
+// Output writes data out to Kafka topics.
 type Output struct {
-	client    *kctl.KClient
-	producers []kafkaProducer
-	data      chan []byte
-	errs      chan error
-	stopChan  chan struct{}
-	wg        sync.WaitGroup
+	client   *kctl.KClient
+	producer *kafkaProducer
+	topics   []string
+	router   *topicRouter
+	message  func([]byte) *sarama.ProducerMessage
+	data     chan plugin.Event
+	errs     chan error
+	stopChan chan struct{}
+	started  bool
+	wg       sync.WaitGroup
 }
 
 // Start starts the plugin.
 func (out *Output) Start() error {
-	for i := 0; i < len(out.producers); i++ {
-		out.wg.Add(1)
-		out.producers[i].produce(&out.wg)
-	}
+	out.started = true
+	out.wg.Add(1)
+	out.producer.produce(&out.wg)
 	out.wg.Add(1)
 	go func() {
 		defer out.wg.Done()
+		// Closing the producer flushes any buffered messages before the producer stops.
+		defer out.producer.close()
 	produceLoop:
 		for {
 			select {
 			case <-out.stopChan:
 				break produceLoop
-			case b := <-out.data:
-				for i := 0; i < len(out.producers); i++ {
-					out.producers[i].send(b)
+			case e := <-out.data:
+				if out.router != nil {
+					out.route(e)
+					continue
+				}
+				ack := plugin.AckGroup(len(out.topics), e.Ack)
+				for _, topic := range out.topics {
+					msg := out.message(e.Data)
+					msg.Topic = topic
+					out.producer.send(msg, ack, out.stopChan)
 				}
 			}
 		}
@@ -100,16 +306,35 @@ func (out *Output) Start() error {
 	return nil
 }
 
+// route produces the Event to the topic selected by the router, acknowledging it with an error if none could be.
+func (out *Output) route(e plugin.Event) {
+	topic, err := out.router.route(e.Data)
+	if err != nil {
+		e.Ack(err)
+		select {
+		case out.errs <- err:
+		default:
+		}
+		return
+	}
+	msg := out.message(e.Data)
+	msg.Topic = topic
+	out.producer.send(msg, e.Ack, out.stopChan)
+}
+
 // Stop stops the plugin.
 func (out *Output) Stop() error {
 	close(out.stopChan)
+	if !out.started {
+		out.producer.producer.Close()
+	}
 	out.wg.Wait()
 	fmt.Println("all kafka producers stopped.")
-	return nil
+	return out.client.Close()
 }
 
 // Destination returns the channel used for accept data to the intended Plugin destination.
-func (out *Output) Destination() chan<- []byte {
+func (out *Output) Destination() chan<- plugin.Event {
 	return out.data
 }
 
@@ -0,0 +1,204 @@
+package kafka
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/jbvmio/lfm/metrics"
+	"github.com/tidwall/gjson"
+)
+
+// Policies for routed topics which do not exist.
+const (
+	MissingTopicError    = `error`
+	MissingTopicFallback = `fallback`
+	MissingTopicCreate   = `create`
+)
+
+// topicRefresh is the time after which the existing topics are refreshed, forgetting deleted topics.
+// It is also the time a routed topic found not to exist is reported missing before it is checked again,
+// limiting the metadata requests made while Events are routed to topics which do not exist.
+const topicRefresh = 10 * time.Second
+
+var topicNameRegex = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,249}$`)
+
+// topicPart is a literal part of a topic template, or a JSON path replaced by its value.
+type topicPart struct {
+	literal string
+	path    string
+}
+
+// parseTopic splits a topic template into its literal parts and {{path}} references.
+func parseTopic(template string) ([]topicPart, error) {
+	var parts []topicPart
+	for template != "" {
+		i := strings.Index(template, `{{`)
+		if i < 0 {
+			parts = append(parts, topicPart{literal: template})
+			break
+		}
+		if i > 0 {
+			parts = append(parts, topicPart{literal: template[:i]})
+		}
+		j := strings.Index(template[i:], `}}`)
+		if j < 0 {
+			return nil, fmt.Errorf("missing }} in topic template")
+		}
+		path := strings.TrimSpace(template[i+2 : i+j])
+		if path == "" {
+			return nil, fmt.Errorf("empty {{}} in topic template")
+		}
+		parts = append(parts, topicPart{path: path})
+		template = template[i+j+2:]
+	}
+	return parts, nil
+}
+
+// topicClient lists and creates topics.
+type topicClient interface {
+	ListTopics() ([]string, error)
+	AddTopic(name string, partitions int32, replication int16) error
+}
+
+// topicRouter selects the topic of each Event from a topic template, applying the missing topic policy
+// to topics which do not exist. It is used only by the produce loop of the Output.
+type topicRouter struct {
+	parts       []topicPart
+	fallback    string
+	missing     string
+	partitions  int32
+	replication int16
+	client      topicClient
+	known       map[string]bool
+	unknown     map[string]time.Time
+	refreshed   time.Time
+}
+
+func newTopicRouter(c *OutputConfig, client topicClient) *topicRouter {
+	return &topicRouter{
+		parts:       c.topicParts,
+		fallback:    c.FallbackTopic,
+		missing:     c.MissingTopic,
+		partitions:  c.TopicPartitions,
+		replication: c.TopicReplication,
+		client:      client,
+		known:       make(map[string]bool),
+		unknown:     make(map[string]time.Time),
+	}
+}
+
+// route returns the topic for the data. The fallback topic is used if the template cannot be resolved,
+// or if the topic does not exist when using the fallback policy.
+func (r *topicRouter) route(data []byte) (string, error) {
+	topic, err := r.resolve(data)
+	if err != nil {
+		if r.fallback == "" {
+			metrics.KafkaRouted.WithLabelValues(metrics.RouteFailed).Inc()
+			return "", err
+		}
+		metrics.KafkaRouted.WithLabelValues(metrics.RouteFallback).Inc()
+		return r.fallback, nil
+	}
+	exists, err := r.exists(topic)
+	switch {
+	case err != nil:
+		metrics.KafkaRouted.WithLabelValues(metrics.RouteFailed).Inc()
+		return "", err
+	case exists:
+		metrics.KafkaRouted.WithLabelValues(metrics.RouteTopic).Inc()
+		return topic, nil
+	}
+	switch r.missing {
+	case MissingTopicFallback:
+		metrics.KafkaRouted.WithLabelValues(metrics.RouteFallback).Inc()
+		return r.fallback, nil
+	case MissingTopicCreate:
+		if err := r.create(topic); err != nil {
+			metrics.KafkaRouted.WithLabelValues(metrics.RouteFailed).Inc()
+			return "", err
+		}
+		metrics.KafkaRouted.WithLabelValues(metrics.RouteCreated).Inc()
+		return topic, nil
+	}
+	metrics.KafkaRouted.WithLabelValues(metrics.RouteFailed).Inc()
+	return "", fmt.Errorf("routed topic %s does not exist", topic)
+}
+
+// resolve returns the topic from the template, replacing each path with its value within the data.
+func (r *topicRouter) resolve(data []byte) (string, error) {
+	var b strings.Builder
+	for _, p := range r.parts {
+		if p.path == "" {
+			b.WriteString(p.literal)
+			continue
+		}
+		val := gjson.GetBytes(data, p.path).String()
+		if val == "" {
+			return "", fmt.Errorf("no value for %s to route topic", p.path)
+		}
+		b.WriteString(val)
+	}
+	topic := b.String()
+	if !topicNameRegex.MatchString(topic) {
+		return "", fmt.Errorf("invalid routed topic name %q", topic)
+	}
+	return topic, nil
+}
+
+// exists returns true if the topic is known to exist. The existing topics are refreshed once stale, or when the
+// topic is not known unless it was found missing within the topicRefresh. If the refresh fails, topics known to
+// exist are still used until the next refresh.
+func (r *topicRouter) exists(topic string) (bool, error) {
+	now := time.Now()
+	known := r.known[topic]
+	if now.Sub(r.refreshed) < topicRefresh && (known || now.Sub(r.unknown[topic]) < topicRefresh) {
+		return known, nil
+	}
+	if err := r.refresh(now); err != nil {
+		if known {
+			return true, nil
+		}
+		return false, err
+	}
+	if r.known[topic] {
+		return true, nil
+	}
+	r.unknown[topic] = now
+	return false, nil
+}
+
+// refresh replaces the known topics with those existing, forgetting topics found missing before the topicRefresh.
+func (r *topicRouter) refresh(now time.Time) error {
+	r.refreshed = now
+	topics, err := r.client.ListTopics()
+	if err != nil {
+		return fmt.Errorf("kafka could not list topics: %w", err)
+	}
+	r.known = make(map[string]bool, len(topics))
+	for _, t := range topics {
+		r.known[t] = true
+	}
+	for t, at := range r.unknown {
+		if r.known[t] || now.Sub(at) >= topicRefresh {
+			delete(r.unknown, t)
+		}
+	}
+	return nil
+}
+
+// create creates the topic, succeeding if it was created since the existing topics were refreshed.
+func (r *topicRouter) create(topic string) error {
+	err := r.client.AddTopic(topic, r.partitions, r.replication)
+	if e, ok := err.(*sarama.TopicError); ok && e.Err == sarama.ErrTopicAlreadyExists {
+		err = nil
+	}
+	if err != nil {
+		return fmt.Errorf("kafka could not create routed topic %s: %w", topic, err)
+	}
+	r.known[topic] = true
+	delete(r.unknown, topic)
+	return nil
+}
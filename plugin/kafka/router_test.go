@@ -0,0 +1,164 @@
+package kafka
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// testTopics is a topicClient holding the existing topics in memory.
+type testTopics struct {
+	topics  map[string]bool
+	lists   int
+	listErr error
+}
+
+func newTestTopics(topics ...string) *testTopics {
+	c := &testTopics{topics: make(map[string]bool)}
+	for _, t := range topics {
+		c.topics[t] = true
+	}
+	return c
+}
+
+func (c *testTopics) ListTopics() ([]string, error) {
+	c.lists++
+	if c.listErr != nil {
+		return nil, c.listErr
+	}
+	var topics []string
+	for t := range c.topics {
+		topics = append(topics, t)
+	}
+	return topics, nil
+}
+
+func (c *testTopics) AddTopic(name string, _ int32, _ int16) error {
+	if c.topics[name] {
+		return &sarama.TopicError{Err: sarama.ErrTopicAlreadyExists}
+	}
+	c.topics[name] = true
+	return nil
+}
+
+func newTestRouter(t *testing.T, template, fallback, missing string, client *testTopics) *topicRouter {
+	t.Helper()
+	c := &OutputConfig{Topic: template, FallbackTopic: fallback, MissingTopic: missing}
+	if err := c.configureRouting(); err != nil {
+		t.Fatal(err)
+	}
+	return newTopicRouter(c, client)
+}
+
+func TestRouterResolve(t *testing.T) {
+	for _, x := range []struct {
+		template string
+		data     string
+		topic    string
+		err      string
+	}{
+		{template: `logs`, data: `{}`, topic: `logs`},
+		{template: `logs-{{app}}`, data: `{"app":"web"}`, topic: `logs-web`},
+		{template: `{{ env }}.{{tags.app}}-logs`, data: `{"env":"prod","tags":{"app":"web"}}`, topic: `prod.web-logs`},
+		{template: `logs-{{app}}`, data: `{"app":3}`, topic: `logs-3`},
+		{template: `logs-{{app}}`, data: `{}`, err: `no value for app`},
+		{template: `logs-{{app}}`, data: `{"app":"a b"}`, err: `invalid routed topic name`},
+		{template: `{{app}}`, data: `{"app":"` + strings.Repeat(`a`, 250) + `"}`, err: `invalid routed topic name`},
+	} {
+		r := newTestRouter(t, x.template, "", "", newTestTopics())
+		topic, err := r.resolve([]byte(x.data))
+		switch {
+		case x.err != "":
+			if err == nil || !strings.Contains(err.Error(), x.err) {
+				t.Fatalf("%s %s: expected error %q, got %v", x.template, x.data, x.err, err)
+			}
+		case err != nil:
+			t.Fatalf("%s %s: %v", x.template, x.data, err)
+		case topic != x.topic:
+			t.Fatalf("%s %s: expected topic %s, got %s", x.template, x.data, x.topic, topic)
+		}
+	}
+}
+
+func TestRouterRoute(t *testing.T) {
+	for _, x := range []struct {
+		name     string
+		fallback string
+		missing  string
+		data     string
+		topic    string
+		err      string
+		created  bool
+	}{
+		{name: `existing`, data: `{"app":"web"}`, topic: `logs-web`},
+		{name: `unresolved`, data: `{}`, err: `no value for app`},
+		{name: `unresolved fallback`, fallback: `logs`, data: `{}`, topic: `logs`},
+		{name: `missing error`, missing: MissingTopicError, data: `{"app":"db"}`, err: `routed topic logs-db does not exist`},
+		{name: `missing fallback`, fallback: `logs`, missing: MissingTopicFallback, data: `{"app":"db"}`, topic: `logs`},
+		{name: `missing create`, missing: MissingTopicCreate, data: `{"app":"db"}`, topic: `logs-db`, created: true},
+		{name: `existing create`, missing: MissingTopicCreate, data: `{"app":"web"}`, topic: `logs-web`},
+	} {
+		client := newTestTopics(`logs`, `logs-web`)
+		r := newTestRouter(t, `logs-{{app}}`, x.fallback, x.missing, client)
+		topic, err := r.route([]byte(x.data))
+		switch {
+		case x.err != "":
+			if err == nil || !strings.Contains(err.Error(), x.err) {
+				t.Fatalf("%s: expected error %q, got %v", x.name, x.err, err)
+			}
+		case err != nil:
+			t.Fatalf("%s: %v", x.name, err)
+		case topic != x.topic:
+			t.Fatalf("%s: expected topic %s, got %s", x.name, x.topic, topic)
+		}
+		if x.created && !client.topics[x.topic] {
+			t.Fatalf("%s: expected topic %s to be created", x.name, x.topic)
+		}
+	}
+}
+
+func TestRouterMissingTopicCache(t *testing.T) {
+	client := newTestTopics(`logs`)
+	r := newTestRouter(t, `logs-{{app}}`, `logs`, MissingTopicFallback, client)
+	route := func(app, want string, lists int) {
+		t.Helper()
+		topic, err := r.route([]byte(`{"app":"` + app + `"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if topic != want || client.lists != lists {
+			t.Fatalf("%s: expected topic %s after %d list(s), got %s after %d", app, want, lists, topic, client.lists)
+		}
+	}
+	route(`web`, `logs`, 1)
+	// recently found missing.
+	route(`web`, `logs`, 1)
+	// a topic created after another was found missing is found on its first use.
+	client.topics[`logs-db`] = true
+	route(`db`, `logs-db`, 2)
+	route(`db`, `logs-db`, 2)
+	// a topic found missing is checked again once its entry expires.
+	client.topics[`logs-web`] = true
+	route(`web`, `logs`, 2)
+	r.unknown[`logs-web`] = r.unknown[`logs-web`].Add(-topicRefresh)
+	route(`web`, `logs-web`, 3)
+	if _, ok := r.unknown[`logs-web`]; ok {
+		t.Fatal("expected the existing topic to be removed from the missing topics")
+	}
+	// deleted topics are forgotten once the topics are refreshed.
+	delete(client.topics, `logs-db`)
+	route(`db`, `logs-db`, 3)
+	r.refreshed = r.refreshed.Add(-topicRefresh)
+	route(`db`, `logs`, 4)
+	// topics known to exist are still used if the refresh fails.
+	client.listErr = errors.New("unavailable")
+	r.refreshed = time.Time{}
+	route(`web`, `logs-web`, 5)
+	route(`web`, `logs-web`, 5)
+	if _, err := r.route([]byte(`{"app":"api"}`)); err == nil || !strings.Contains(err.Error(), `unavailable`) {
+		t.Fatalf("expected the failed refresh to be returned for an unknown topic, got %v", err)
+	}
+}
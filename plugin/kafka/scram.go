@@ -0,0 +1,128 @@
+package kafka
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// scramClient implements the client side of SCRAM authentication, RFC 5802, for sarama.
+// Usernames and passwords are used as given, without SASLprep normalization.
+type scramClient struct {
+	hash            func() hash.Hash
+	newNonce        func() (string, error)
+	username        string
+	password        string
+	nonce           string
+	clientFirstBare string
+	serverSignature []byte
+	step            int
+	done            bool
+}
+
+func newSCRAMClient(h func() hash.Hash) *scramClient {
+	return &scramClient{hash: h, newNonce: randomNonce}
+}
+
+// randomNonce returns a random client nonce.
+func randomNonce() (string, error) {
+	nonce := make([]byte, 24)
+	if _, err := rand.Read(nonce); err != nil {
+		return "", fmt.Errorf("could not generate scram nonce: %w", err)
+	}
+	return base64.RawStdEncoding.EncodeToString(nonce), nil
+}
+
+// Begin prepares the client for the SCRAM exchange.
+func (c *scramClient) Begin(username, password, authzID string) error {
+	nonce, err := c.newNonce()
+	if err != nil {
+		return err
+	}
+	c.username, c.password, c.nonce = username, password, nonce
+	c.step, c.done = 0, false
+	return nil
+}
+
+// Step processes a server challenge, returning the response to send.
+func (c *scramClient) Step(challenge string) (string, error) {
+	defer func() { c.step++ }()
+	switch c.step {
+	case 0:
+		user := strings.NewReplacer(`=`, `=3D`, `,`, `=2C`).Replace(c.username)
+		c.clientFirstBare = `n=` + user + `,r=` + c.nonce
+		return `n,,` + c.clientFirstBare, nil
+	case 1:
+		return c.clientFinal(challenge)
+	case 2:
+		attrs := scramAttrs(challenge)
+		if e, ok := attrs[`e`]; ok {
+			return "", fmt.Errorf("scram authentication failed: %s", e)
+		}
+		sig, err := base64.StdEncoding.DecodeString(attrs[`v`])
+		if err != nil || !hmac.Equal(sig, c.serverSignature) {
+			return "", fmt.Errorf("scram server signature did not match")
+		}
+		c.done = true
+		return "", nil
+	}
+	return "", fmt.Errorf("unexpected scram challenge")
+}
+
+// clientFinal returns the client proof for the server first message.
+func (c *scramClient) clientFinal(serverFirst string) (string, error) {
+	attrs := scramAttrs(serverFirst)
+	nonce := attrs[`r`]
+	if !strings.HasPrefix(nonce, c.nonce) || len(nonce) == len(c.nonce) {
+		return "", fmt.Errorf("invalid scram server nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs[`s`])
+	if err != nil {
+		return "", fmt.Errorf("invalid scram salt: %w", err)
+	}
+	iterations, err := strconv.Atoi(attrs[`i`])
+	if err != nil || iterations < 1 {
+		return "", fmt.Errorf("invalid scram iteration count %q", attrs[`i`])
+	}
+	salted := pbkdf2.Key([]byte(c.password), salt, iterations, c.hash().Size(), c.hash)
+	clientKey := c.hmac(salted, `Client Key`)
+	h := c.hash()
+	h.Write(clientKey)
+	storedKey := h.Sum(nil)
+	withoutProof := `c=biws,r=` + nonce
+	authMessage := c.clientFirstBare + `,` + serverFirst + `,` + withoutProof
+	proof := c.hmac(storedKey, authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	c.serverSignature = c.hmac(c.hmac(salted, `Server Key`), authMessage)
+	return withoutProof + `,p=` + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+func (c *scramClient) hmac(key []byte, msg string) []byte {
+	mac := hmac.New(c.hash, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+// Done returns true once the server signature was verified.
+func (c *scramClient) Done() bool {
+	return c.done
+}
+
+// scramAttrs parses the attributes of a SCRAM message.
+func scramAttrs(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, part := range strings.Split(msg, `,`) {
+		if i := strings.Index(part, `=`); i > 0 {
+			attrs[part[:i]] = part[i+1:]
+		}
+	}
+	return attrs
+}
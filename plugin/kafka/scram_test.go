@@ -0,0 +1,104 @@
+package kafka
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"hash"
+	"strings"
+	"testing"
+)
+
+// scramVectors are the example exchanges of RFC 5802 and RFC 7677.
+var scramVectors = []struct {
+	name        string
+	hash        func() hash.Hash
+	nonce       string
+	clientFirst string
+	serverFirst string
+	clientFinal string
+	serverFinal string
+}{
+	{
+		name:        `RFC 5802 SCRAM-SHA-1`,
+		hash:        sha1.New,
+		nonce:       `fyko+d2lbbFgONRv9qkxdawL`,
+		clientFirst: `n,,n=user,r=fyko+d2lbbFgONRv9qkxdawL`,
+		serverFirst: `r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,s=QSXCR+Q6sek8bf92,i=4096`,
+		clientFinal: `c=biws,r=fyko+d2lbbFgONRv9qkxdawL3rfcNHYJY1ZVvWVs7j,p=v0X8v3Bz2T0CJGbJQyF0X+HI4Ts=`,
+		serverFinal: `v=rmF9pqV8S7suAoZWja4dJRkFsKQ=`,
+	},
+	{
+		name:        `RFC 7677 SCRAM-SHA-256`,
+		hash:        sha256.New,
+		nonce:       `rOprNGfwEbeRWgbNEkqO`,
+		clientFirst: `n,,n=user,r=rOprNGfwEbeRWgbNEkqO`,
+		serverFirst: `r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096`,
+		clientFinal: `c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ=`,
+		serverFinal: `v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=`,
+	},
+}
+
+func beginSCRAM(t *testing.T, h func() hash.Hash, nonce string) *scramClient {
+	t.Helper()
+	c := newSCRAMClient(h)
+	c.newNonce = func() (string, error) { return nonce, nil }
+	if err := c.Begin(`user`, `pencil`, ""); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSCRAMVectors(t *testing.T) {
+	for _, v := range scramVectors {
+		t.Run(v.name, func(t *testing.T) {
+			c := beginSCRAM(t, v.hash, v.nonce)
+			for _, step := range []struct{ challenge, response string }{
+				{"", v.clientFirst},
+				{v.serverFirst, v.clientFinal},
+				{v.serverFinal, ""},
+			} {
+				resp, err := c.Step(step.challenge)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if resp != step.response {
+					t.Fatalf("expected %q, got %q", step.response, resp)
+				}
+			}
+			if !c.Done() {
+				t.Fatal("expected the exchange to be done")
+			}
+		})
+	}
+}
+
+func TestSCRAMRejects(t *testing.T) {
+	v := scramVectors[1]
+	for _, x := range []struct {
+		name        string
+		serverFirst string
+		serverFinal string
+		err         string
+	}{
+		{`server signature`, v.serverFirst, `v=rmF9pqV8S7suAoZWja4dJRkFsKQ=`, `signature did not match`},
+		{`server error`, v.serverFirst, `e=invalid-proof`, `invalid-proof`},
+		{`server nonce`, `r=someOtherNonce,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096`, "", `invalid scram server nonce`},
+		{`unextended nonce`, `r=rOprNGfwEbeRWgbNEkqO,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096`, "", `invalid scram server nonce`},
+		{`iterations`, `r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=0`, "", `invalid scram iteration count`},
+	} {
+		t.Run(x.name, func(t *testing.T) {
+			c := beginSCRAM(t, v.hash, v.nonce)
+			c.Step("")
+			_, err := c.Step(x.serverFirst)
+			if err == nil {
+				_, err = c.Step(x.serverFinal)
+			}
+			if err == nil || !strings.Contains(err.Error(), x.err) {
+				t.Fatalf("expected error containing %q, got %v", x.err, err)
+			}
+			if c.Done() {
+				t.Fatal("expected the exchange not to be done")
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/Shopify/sarama"
+)
+
+// SASL mechanisms supported by the Plugins.
+const (
+	SASLPlain       = `PLAIN`
+	SASLSCRAMSHA256 = `SCRAM-SHA-256`
+	SASLSCRAMSHA512 = `SCRAM-SHA-512`
+)
+
+// Security contains the TLS and SASL options shared by the Input and Output Plugins.
+// TLS is used when tls is given and SASL authentication when sasl is given.
+type Security struct {
+	TLS  *TLSConfig  `yaml:"tls,omitempty" json:"tls,omitempty"`
+	SASL *SASLConfig `yaml:"sasl,omitempty" json:"sasl,omitempty"`
+}
+
+// TLSConfig configures TLS connections to the brokers. The system CAs are used unless CAFile is given,
+// and CertFile and KeyFile provide a client certificate.
+type TLSConfig struct {
+	CAFile             string `yaml:"caFile" json:"caFile"`
+	CertFile           string `yaml:"certFile" json:"certFile"`
+	KeyFile            string `yaml:"keyFile" json:"keyFile"`
+	InsecureSkipVerify bool   `yaml:"insecureSkipVerify" json:"insecureSkipVerify"`
+}
+
+// SASLConfig configures SASL authentication with the brokers. Mechanism is one of PLAIN, SCRAM-SHA-256
+// or SCRAM-SHA-512, defaults to PLAIN. The password is read from PasswordFile.
+type SASLConfig struct {
+	Mechanism    string `yaml:"mechanism" json:"mechanism"`
+	Username     string `yaml:"username" json:"username"`
+	PasswordFile string `yaml:"passwordFile" json:"passwordFile"`
+}
+
+// validate checks the options, leaving the files to be read when applied.
+func (s *Security) validate() error {
+	if s.TLS != nil && (s.TLS.CertFile == "") != (s.TLS.KeyFile == "") {
+		return fmt.Errorf("tls certFile and keyFile must be given together")
+	}
+	if s.SASL != nil {
+		switch s.SASL.Mechanism {
+		case "":
+			s.SASL.Mechanism = SASLPlain
+		case SASLPlain, SASLSCRAMSHA256, SASLSCRAMSHA512:
+		default:
+			return fmt.Errorf("invalid sasl mechanism %q, must be one of %s, %s or %s", s.SASL.Mechanism, SASLPlain, SASLSCRAMSHA256, SASLSCRAMSHA512)
+		}
+		if s.SASL.Username == "" {
+			return fmt.Errorf("missing sasl username")
+		}
+		if s.SASL.PasswordFile == "" {
+			return fmt.Errorf("missing sasl passwordFile")
+		}
+	}
+	return nil
+}
+
+// apply configures the client config to use the options, reading any certificates and secrets.
+func (s *Security) apply(conf *sarama.Config) error {
+	if s.TLS != nil {
+		tlsConf, err := s.TLS.config()
+		if err != nil {
+			return err
+		}
+		conf.Net.TLS.Enable = true
+		conf.Net.TLS.Config = tlsConf
+	}
+	if s.SASL != nil {
+		password, err := readSecret(s.SASL.PasswordFile)
+		if err != nil {
+			return fmt.Errorf("could not read sasl passwordFile: %w", err)
+		}
+		conf.Net.SASL.Enable = true
+		conf.Net.SASL.Handshake = true
+		conf.Net.SASL.Version = sarama.SASLHandshakeV1
+		conf.Net.SASL.User = s.SASL.Username
+		conf.Net.SASL.Password = password
+		switch s.SASL.Mechanism {
+		case SASLSCRAMSHA256:
+			conf.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+			conf.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return newSCRAMClient(sha256.New) }
+		case SASLSCRAMSHA512:
+			conf.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+			conf.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient { return newSCRAMClient(sha512.New) }
+		default:
+			conf.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		}
+	}
+	return nil
+}
+
+func (c *TLSConfig) config() (*tls.Config, error) {
+	tlsConf := &tls.Config{
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		pem, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read tls caFile: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in tls caFile %s", c.CAFile)
+		}
+		tlsConf.RootCAs = pool
+	}
+	if c.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load tls client certificate: %w", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConf, nil
+}
+
+// readSecret returns the contents of the file without any trailing newline.
+func readSecret(path string) (string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimRight(string(b), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
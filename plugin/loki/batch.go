@@ -0,0 +1,70 @@
+package loki
+
+import (
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/golang/snappy"
+	"github.com/grafana/loki/pkg/logproto"
+)
+
+// batch holds the entries of Events waiting to be pushed to Loki together with their ack funcs,
+// which are called with the result of the push.
+type batch struct {
+	streams   map[string]*logproto.Stream
+	acks      []func(error)
+	bytes     int
+	createdAt time.Time
+}
+
+func newBatch() *batch {
+	return &batch{
+		streams:   make(map[string]*logproto.Stream),
+		createdAt: time.Now(),
+	}
+}
+
+// add adds the entry for the stream with the given labels to the batch.
+func (b *batch) add(labels string, e logproto.Entry, ack func(error)) {
+	b.bytes += len(e.Line)
+	b.acks = append(b.acks, ack)
+	if stream, ok := b.streams[labels]; ok {
+		stream.Entries = append(stream.Entries, e)
+		return
+	}
+	b.streams[labels] = &logproto.Stream{
+		Labels:  labels,
+		Entries: []logproto.Entry{e},
+	}
+}
+
+// sizeBytesAfter returns the size of the batch after adding the line.
+func (b *batch) sizeBytesAfter(line string) int {
+	return b.bytes + len(line)
+}
+
+func (b *batch) age() time.Duration {
+	return time.Since(b.createdAt)
+}
+
+// ack acknowledges each Event within the batch.
+func (b *batch) ack(err error) {
+	for _, ack := range b.acks {
+		ack(err)
+	}
+}
+
+// encode returns the batch as a snappy compressed push request.
+func (b *batch) encode() ([]byte, error) {
+	req := logproto.PushRequest{
+		Streams: make([]logproto.Stream, 0, len(b.streams)),
+	}
+	for _, stream := range b.streams {
+		req.Streams = append(req.Streams, *stream)
+	}
+	buf, err := proto.Marshal(&req)
+	if err != nil {
+		return nil, err
+	}
+	return snappy.Encode(nil, buf), nil
+}
@@ -1,21 +1,38 @@
 package loki
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"sync"
 	"time"
 
 	"github.com/cortexproject/cortex/pkg/util"
-	"github.com/cortexproject/cortex/pkg/util/flagext"
-	"github.com/go-kit/kit/log"
-	lclient "github.com/grafana/loki/pkg/promtail/client"
+	"github.com/grafana/loki/pkg/logproto"
+	"github.com/jbvmio/lfm/metrics"
 	"github.com/jbvmio/lfm/plugin"
 	"github.com/prometheus/common/model"
 	"gopkg.in/yaml.v2"
 )
 
+const (
+	contentType = `application/x-protobuf`
+	userAgent   = `lfm`
+	// maxErrMsgLen limits the response body included in push errors.
+	maxErrMsgLen = 1024
+	// minWaitCheckFrequency is the minimum interval used to check the age of a pending batch.
+	minWaitCheckFrequency = 10 * time.Millisecond
+)
+
+func init() {
+	plugin.RegisterOutput(`loki`, func() plugin.OutputConfig { return &OutputConfig{} })
+}
+
 // OutputConfig contains configuration details when using the StdOutput Plugin.
 type OutputConfig struct {
 	URL        string        `yaml:"url" json:"url"`
@@ -68,37 +85,43 @@ func (c *OutputConfig) CreateOutput() (plugin.Output, error) {
 	if err != nil {
 		return nil, fmt.Errorf("invalid loki url: %w", err)
 	}
-	cfg := lclient.Config{
-		URL: flagext.URLValue{URL: U},
-		BackoffConfig: util.BackoffConfig{
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Output{
+		url: U.String(),
+		backoff: util.BackoffConfig{
 			MaxBackoff: c.MaxBackoff,
 			MaxRetries: c.MaxRetries,
 			MinBackoff: c.MinBackoff,
 		},
-		BatchSize: c.BatchSize,
-		BatchWait: c.BatchWait,
-		Timeout:   c.Timeout,
-	}
-	C, err := lclient.New(cfg, log.NewNopLogger())
-	if err != nil {
-		return nil, fmt.Errorf("could not create loki client: %w", err)
-	}
-	return &Output{
-		loki:     C,
-		data:     make(chan []byte),
-		errs:     make(chan error),
-		stopChan: make(chan struct{}),
-		wg:       sync.WaitGroup{},
+		batchSize: c.BatchSize,
+		batchWait: c.BatchWait,
+		timeout:   c.Timeout,
+		client:    &http.Client{},
+		data:      make(chan plugin.Event),
+		errs:      make(chan error),
+		ctx:       ctx,
+		cancel:    cancel,
+		stopChan:  make(chan struct{}),
+		wg:        sync.WaitGroup{},
 	}, nil
 }
 
-// Output outputs to stdout.
+// Output sends entries to Loki in batches. Each Event is acknowledged once the batch containing it
+// has been pushed to Loki, or with an error if the push failed after all retries.
+// Batches are pushed in order, so the Output stops receiving Events while a batch is retried.
 type Output struct {
-	loki     lclient.Client
-	data     chan []byte
-	errs     chan error
-	stopChan chan struct{}
-	wg       sync.WaitGroup
+	url       string
+	backoff   util.BackoffConfig
+	batchSize int
+	batchWait time.Duration
+	timeout   time.Duration
+	client    *http.Client
+	data      chan plugin.Event
+	errs      chan error
+	ctx       context.Context
+	cancel    context.CancelFunc
+	stopChan  chan struct{}
+	wg        sync.WaitGroup
 }
 
 // Entry is the expected object structure to be received by the Loki Output Plugin.
@@ -113,28 +136,41 @@ func (out *Output) Start() error {
 	out.wg.Add(1)
 	go func() {
 		defer out.wg.Done()
-	outLoop:
+		checkFrequency := out.batchWait / 10
+		if checkFrequency < minWaitCheckFrequency {
+			checkFrequency = minWaitCheckFrequency
+		}
+		maxWait := time.NewTicker(checkFrequency)
+		defer maxWait.Stop()
+		var b *batch
 		for {
 			select {
 			case <-out.stopChan:
-				break outLoop
-			case input := <-out.data:
-				var entry Entry
-				err := json.Unmarshal(input, &entry)
-				switch {
-				case err != nil:
-					out.errs <- fmt.Errorf("invalid entry recieved by loki output: %w", err)
-				case len(entry.Tags) < 1:
-					out.errs <- fmt.Errorf("invalid entry recieved by loki output: no tags defined")
-				default:
-					if entry.TS == (time.Time{}) {
-						entry.TS = time.Now()
-					}
-					ls := createLabelSet(entry.Tags)
-					err := out.loki.Handle(ls, entry.TS, entry.E)
-					if err != nil {
-						out.errs <- fmt.Errorf("error sending to loki: %w", err)
-					}
+				// Any pending batch is pushed before stopping, acknowledging its Events.
+				if b != nil {
+					out.push(b)
+				}
+				return
+			case e := <-out.data:
+				labels, entry, err := out.handle(e.Data)
+				if err != nil {
+					e.Ack(err)
+					metrics.LokiEntries.WithLabelValues(metrics.ResultFailed).Inc()
+					out.report(err)
+					continue
+				}
+				if b != nil && b.sizeBytesAfter(entry.Line) > out.batchSize {
+					out.push(b)
+					b = nil
+				}
+				if b == nil {
+					b = newBatch()
+				}
+				b.add(labels, entry, e.Ack)
+			case <-maxWait.C:
+				if b != nil && b.age() >= out.batchWait {
+					out.push(b)
+					b = nil
 				}
 			}
 		}
@@ -142,16 +178,108 @@ func (out *Output) Start() error {
 	return nil
 }
 
-// Stop stops the plugin.
+// handle returns the stream labels and entry for the input.
+func (out *Output) handle(input []byte) (string, logproto.Entry, error) {
+	var entry Entry
+	err := json.Unmarshal(input, &entry)
+	switch {
+	case err != nil:
+		return "", logproto.Entry{}, fmt.Errorf("invalid entry recieved by loki output: %w", err)
+	case len(entry.Tags) < 1:
+		return "", logproto.Entry{}, fmt.Errorf("invalid entry recieved by loki output: no tags defined")
+	}
+	if entry.TS == (time.Time{}) {
+		entry.TS = time.Now()
+	}
+	ls := createLabelSet(entry.Tags)
+	return ls.String(), logproto.Entry{Timestamp: entry.TS, Line: entry.E}, nil
+}
+
+// push sends the batch to Loki, retrying rate limited, server and connection errors using the backoff,
+// then acknowledges the Events of the batch with the result.
+// Once the Output is stopped the batch is sent without further retries.
+func (out *Output) push(b *batch) {
+	buf, err := b.encode()
+	if err != nil {
+		err = fmt.Errorf("could not encode loki batch: %w", err)
+		metrics.LokiBatches.WithLabelValues(metrics.ResultFailed).Inc()
+		metrics.LokiEntries.WithLabelValues(metrics.ResultFailed).Add(float64(len(b.acks)))
+		b.ack(err)
+		out.report(err)
+		return
+	}
+	backoff := util.NewBackoff(out.ctx, out.backoff)
+	for {
+		var status int
+		status, err = out.send(buf)
+		if err == nil {
+			break
+		}
+		// Only retry 429s, 500s and connection-level errors.
+		if status > 0 && status != 429 && status/100 != 5 {
+			break
+		}
+		backoff.Wait()
+		if !backoff.Ongoing() {
+			break
+		}
+	}
+	result := metrics.ResultDelivered
+	if err != nil {
+		result = metrics.ResultFailed
+		err = fmt.Errorf("error sending to loki: %w", err)
+		out.report(err)
+	}
+	metrics.LokiBatches.WithLabelValues(result).Inc()
+	metrics.LokiEntries.WithLabelValues(result).Add(float64(len(b.acks)))
+	b.ack(err)
+}
+
+// send posts the encoded batch to Loki, returning the response status code or -1 if no response was received.
+func (out *Output) send(buf []byte) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), out.timeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodPost, out.url, bytes.NewReader(buf))
+	if err != nil {
+		return -1, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := out.client.Do(req)
+	if err != nil {
+		return -1, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		scanner := bufio.NewScanner(io.LimitReader(resp.Body, maxErrMsgLen))
+		line := ""
+		if scanner.Scan() {
+			line = scanner.Text()
+		}
+		return resp.StatusCode, fmt.Errorf("server returned HTTP status %s (%d): %s", resp.Status, resp.StatusCode, line)
+	}
+	return resp.StatusCode, nil
+}
+
+// report sends the error to the error channel unless the plugin is stopped.
+func (out *Output) report(err error) {
+	select {
+	case out.errs <- err:
+	case <-out.stopChan:
+	}
+}
+
+// Stop stops the plugin, cancelling any retries and pushing any pending batch to loki once.
 func (out *Output) Stop() error {
+	out.cancel()
 	close(out.stopChan)
-	out.loki.Stop()
 	out.wg.Wait()
 	return nil
 }
 
 // Destination returns the channel used for accept data to the intended Plugin destination.
-func (out *Output) Destination() chan<- []byte {
+func (out *Output) Destination() chan<- plugin.Event {
 	return out.data
 }
 
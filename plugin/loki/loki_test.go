@@ -0,0 +1,52 @@
+package loki
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jbvmio/lfm/plugin"
+)
+
+func TestStopCancelsRetries(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	c := &OutputConfig{}
+	err := c.Configure(map[string]interface{}{
+		`url`:        srv.URL,
+		`batchWait`:  `10ms`,
+		`minBackoff`: `1m`,
+		`maxBackoff`: `1m`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	o, err := c.CreateOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for range o.Errors() {
+		}
+	}()
+	if err := o.Start(); err != nil {
+		t.Fatal(err)
+	}
+	acked := make(chan error, 1)
+	o.Destination() <- plugin.NewEvent([]byte(`{"entry":"line","tags":{"app":"test"}}`), func(err error) { acked <- err })
+	time.Sleep(50 * time.Millisecond)
+
+	stopped := make(chan error)
+	go func() { stopped <- o.Stop() }()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("expected Stop to cancel the push retries")
+	}
+	if err := <-acked; err == nil {
+		t.Fatal("expected the event to be acknowledged with the push error")
+	}
+}
@@ -0,0 +1,30 @@
+package osio
+
+import (
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// readCheckpoint returns the file position saved at the given path, or -1 if no checkpoint exists.
+func readCheckpoint(path string) (int64, error) {
+	b, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		return -1, nil
+	case err != nil:
+		return -1, err
+	}
+	return strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+}
+
+// writeCheckpoint atomically saves the file position to the given path.
+func writeCheckpoint(path string, offset int64) error {
+	tmp := path + `.tmp`
+	err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -13,11 +13,33 @@ import (
 	"github.com/nxadm/tail"
 )
 
+func init() {
+	plugin.RegisterInput(`file`, func() plugin.InputConfig { return &FileInputConfig{} })
+	plugin.RegisterOutput(`file`, func() plugin.OutputConfig { return &FileOutputConfig{} })
+}
+
+// Failure policies for the FileInput Plugin when using a Checkpoint.
+const (
+	FailureHold   = `hold`
+	FailureCommit = `commit`
+)
+
+// defaultMaxInFlight is used when MaxInFlight is not set.
+const defaultMaxInFlight = 1000
+
 // FileInputConfig contains configuration details when using the FileInput Plugin.
+// If a Checkpoint path is defined, the file position is only advanced once the lines read have been delivered,
+// and reading resumes from the saved position on start. At most MaxInFlight lines are read ahead of the saved position.
+// OnFailure is hold or commit, deciding how lines acknowledged with an error are handled. hold keeps the position
+// from advancing past a failed line, which is read again after a restart, while commit allows the position
+// to advance past failed lines. Defaults to hold.
 type FileInputConfig struct {
 	Path           string `yaml:"path" json:"path"`
 	Buffer         int    `yaml:"buffer" json:"buffer"`
 	StartBeginning bool   `yaml:"startBeginning" json:"startBeginning"`
+	Checkpoint     string `yaml:"checkpoint" json:"checkpoint"`
+	MaxInFlight    int    `yaml:"maxInFlight" json:"maxInFlight"`
+	OnFailure      string `yaml:"onFailure" json:"onFailure"`
 }
 
 // Configure attempts to configure the Config based on the details entered.
@@ -34,6 +56,22 @@ func (c *FileInputConfig) Configure(details map[string]interface{}) error {
 	if b, ok := details[`startBeginning`].(bool); ok {
 		c.StartBeginning = b
 	}
+	if cp, ok := details[`checkpoint`].(string); ok {
+		c.Checkpoint = cp
+	}
+	c.MaxInFlight = defaultMaxInFlight
+	if m, ok := details[`maxInFlight`].(int); ok {
+		c.MaxInFlight = m
+	}
+	c.OnFailure = FailureHold
+	if f, ok := details[`onFailure`].(string); ok {
+		switch f {
+		case FailureHold, FailureCommit:
+			c.OnFailure = f
+		default:
+			return fmt.Errorf("invalid onFailure %q for file input, must be %s or %s", f, FailureHold, FailureCommit)
+		}
+	}
 	return nil
 }
 
@@ -45,11 +83,18 @@ func (c *FileInputConfig) CreateInput() (plugin.Input, error) {
 	if c.Buffer == 0 {
 		c.Buffer = 1000
 	}
+	if c.MaxInFlight < 1 {
+		c.MaxInFlight = defaultMaxInFlight
+	}
 	return &FileInput{
 		Path:           c.Path,
 		Buffer:         c.Buffer,
 		StartBeginning: c.StartBeginning,
-		data:           make(chan []byte, c.Buffer),
+		Checkpoint:     c.Checkpoint,
+		MaxInFlight:    c.MaxInFlight,
+		OnFailure:      c.OnFailure,
+		acked:          make(chan struct{}, 1),
+		data:           make(chan plugin.Event, c.Buffer),
 		errs:           make(chan error, c.Buffer),
 		stopChan:       make(chan struct{}),
 		wg:             sync.WaitGroup{},
@@ -61,31 +106,53 @@ type FileInput struct {
 	Path           string `yaml:"path" json:"path"`
 	Buffer         int    `yaml:"buffer" json:"buffer"`
 	StartBeginning bool   `yaml:"startBeginning" json:"startBeginning"`
-	data           chan []byte
+	Checkpoint     string `yaml:"checkpoint" json:"checkpoint"`
+	MaxInFlight    int    `yaml:"maxInFlight" json:"maxInFlight"`
+	OnFailure      string `yaml:"onFailure" json:"onFailure"`
+	data           chan plugin.Event
 	errs           chan error
 	stopChan       chan struct{}
 	stopped        bool
+	tracker        *plugin.OffsetTracker
+	acked          chan struct{}
+	health         error
+	lock           sync.Mutex
 	wg             sync.WaitGroup
 }
 
 // Start starts the plugin.
 func (in *FileInput) Start() error {
-	in.wg.Add(1)
-	w := 2
+	loc := &tail.SeekInfo{Whence: 2}
 	if in.StartBeginning {
-		w = 0
+		loc.Whence = 0
 	}
+	if in.Checkpoint != "" {
+		offset, err := readCheckpoint(in.Checkpoint)
+		switch {
+		case err != nil:
+			return fmt.Errorf("error reading checkpoint %s: %w", in.Checkpoint, err)
+		case offset >= 0:
+			loc = &tail.SeekInfo{Offset: offset, Whence: 0}
+		}
+		in.tracker = plugin.NewOffsetTracker(offset)
+		in.wg.Add(1)
+		go in.saveCheckpoints()
+	}
+	in.wg.Add(1)
 	go func() {
 		defer in.wg.Done()
-		t, err := tail.TailFile(in.Path, tail.Config{Follow: true, Logger: tail.DiscardingLogger, Location: &tail.SeekInfo{Whence: w}})
+		t, err := tail.TailFile(in.Path, tail.Config{Follow: true, Logger: tail.DiscardingLogger, Location: loc})
 		for err != nil {
 			if in.stopped {
 				return
 			}
-			in.errs <- fmt.Errorf("error adding file %s: %v", in.Path, err)
+			err = fmt.Errorf("error adding file %s: %v", in.Path, err)
+			in.setHealth(err)
+			in.errs <- err
 			time.Sleep(time.Second * 5)
-			t, err = tail.TailFile(in.Path, tail.Config{Follow: true})
+			t, err = tail.TailFile(in.Path, tail.Config{Follow: true, Logger: tail.DiscardingLogger, Location: loc})
 		}
+		in.setHealth(nil)
 	fileLoop:
 		for {
 			select {
@@ -94,10 +161,25 @@ func (in *FileInput) Start() error {
 				break fileLoop
 			case line, ok := <-t.Lines:
 				if !ok {
-					in.errs <- fmt.Errorf("file ended: %v", t.Err())
+					err := fmt.Errorf("file ended: %v", t.Err())
+					in.setHealth(err)
+					in.errs <- err
+					break fileLoop
+				}
+				var ack func(error)
+				if in.tracker != nil {
+					if !in.waitInFlight() {
+						t.Stop()
+						break fileLoop
+					}
+					ack = in.trackLine(line.SeekInfo.Offset)
+				}
+				select {
+				case <-in.stopChan:
+					t.Stop()
 					break fileLoop
+				case in.data <- plugin.NewEvent([]byte(line.Text), ack):
 				}
-				in.data <- []byte(line.Text)
 			}
 		}
 
@@ -105,6 +187,77 @@ func (in *FileInput) Start() error {
 	return nil
 }
 
+// waitInFlight waits until fewer than MaxInFlight lines are unconfirmed, returning false if the plugin is stopped.
+// A line held back by a failed line remains unconfirmed until the plugin is restarted.
+func (in *FileInput) waitInFlight() bool {
+	for in.tracker.Pending() >= in.MaxInFlight {
+		select {
+		case <-in.acked:
+		case <-in.stopChan:
+			return false
+		}
+	}
+	return true
+}
+
+// trackLine tracks the line at the given offset, returning its ack func.
+// The line is done once acknowledged without error, or with an error when failed lines are committed.
+func (in *FileInput) trackLine(offset int64) func(error) {
+	id := in.tracker.Add(offset)
+	return func(err error) {
+		defer func() {
+			select {
+			case in.acked <- struct{}{}:
+			default:
+			}
+		}()
+		if err != nil {
+			if in.OnFailure != FailureCommit {
+				in.report(fmt.Errorf("line at offset %d of %s failed, holding back the checkpoint: %w", offset, in.Path, err))
+				return
+			}
+			in.report(fmt.Errorf("line at offset %d of %s failed, committing: %w", offset, in.Path, err))
+		}
+		in.tracker.Done(id)
+	}
+}
+
+// report sends the error to the error channel without blocking the caller.
+func (in *FileInput) report(err error) {
+	select {
+	case in.errs <- err:
+	default:
+	}
+}
+
+// saveCheckpoints periodically writes the delivered file position to the checkpoint file.
+func (in *FileInput) saveCheckpoints() {
+	defer in.wg.Done()
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	saved := in.tracker.Committed()
+	save := func() {
+		offset := in.tracker.Committed()
+		if offset == saved {
+			return
+		}
+		if err := writeCheckpoint(in.Checkpoint, offset); err != nil {
+			in.errs <- fmt.Errorf("error saving checkpoint %s: %w", in.Checkpoint, err)
+			return
+		}
+		saved = offset
+	}
+	for {
+		select {
+		case <-in.stopChan:
+			save()
+			return
+		case <-ticker.C:
+			save()
+		}
+	}
+}
+
 // Stop stops the plugin.
 func (in *FileInput) Stop() error {
 	in.stopped = true
@@ -113,8 +266,21 @@ func (in *FileInput) Stop() error {
 	return nil
 }
 
+// Health returns an error if the file is not being tailed.
+func (in *FileInput) Health() error {
+	in.lock.Lock()
+	defer in.lock.Unlock()
+	return in.health
+}
+
+func (in *FileInput) setHealth(err error) {
+	in.lock.Lock()
+	in.health = err
+	in.lock.Unlock()
+}
+
 // Source returns the oncoming data channel for the Input Plugin.
-func (in *FileInput) Source() <-chan []byte {
+func (in *FileInput) Source() <-chan plugin.Event {
 	return in.data
 }
 
@@ -155,7 +321,7 @@ func (c *FileOutputConfig) CreateOutput() (plugin.Output, error) {
 	return &FileOutput{
 		Path:     c.Path,
 		Buffer:   c.Buffer,
-		data:     make(chan []byte, c.Buffer),
+		data:     make(chan plugin.Event, c.Buffer),
 		errs:     make(chan error, c.Buffer),
 		stopChan: make(chan struct{}),
 		wg:       sync.WaitGroup{},
@@ -166,7 +332,7 @@ func (c *FileOutputConfig) CreateOutput() (plugin.Output, error) {
 type FileOutput struct {
 	Path     string `yaml:"path" json:"path"`
 	Buffer   int    `yaml:"buffer" json:"buffer"`
-	data     chan []byte
+	data     chan plugin.Event
 	errs     chan error
 	stopChan chan struct{}
 	stopped  bool
@@ -194,11 +360,12 @@ func (out *FileOutput) Start() error {
 			select {
 			case <-out.stopChan:
 				break fileLoop
-			case b := <-out.data:
-				_, err := f.Write(b)
-				if !bytes.HasSuffix(b, []byte{10}) {
-					f.Write([]byte{10})
+			case e := <-out.data:
+				_, err := f.Write(e.Data)
+				if err == nil && !bytes.HasSuffix(e.Data, []byte{10}) {
+					_, err = f.Write([]byte{10})
 				}
+				e.Ack(err)
 				if err != nil {
 					out.errs <- err
 				}
@@ -216,7 +383,7 @@ func (out *FileOutput) Stop() error {
 }
 
 // Destination returns the channel used for accept data to the intended Plugin destination.
-func (out *FileOutput) Destination() chan<- []byte {
+func (out *FileOutput) Destination() chan<- plugin.Event {
 	return out.data
 }
 
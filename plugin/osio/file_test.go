@@ -0,0 +1,49 @@
+package osio
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/jbvmio/lfm/plugin"
+)
+
+func TestFileInputOnFailure(t *testing.T) {
+	for _, x := range []struct {
+		policy    string
+		committed int64
+	}{
+		{policy: FailureHold, committed: 10},
+		{policy: FailureCommit, committed: 30},
+	} {
+		in := &FileInput{
+			Path:      `test.log`,
+			OnFailure: x.policy,
+			tracker:   plugin.NewOffsetTracker(0),
+			acked:     make(chan struct{}, 1),
+			errs:      make(chan error, 10),
+		}
+		acks := []func(error){in.trackLine(10), in.trackLine(20), in.trackLine(30)}
+		acks[0](nil)
+		acks[1](errors.New("failed"))
+		acks[2](nil)
+		if got := in.tracker.Committed(); got != x.committed {
+			t.Fatalf("%s: expected committed offset %d, got %d", x.policy, x.committed, got)
+		}
+		if len(in.errs) != 1 {
+			t.Fatalf("%s: expected the failed line to be reported", x.policy)
+		}
+	}
+}
+
+func TestFileInputConfigOnFailure(t *testing.T) {
+	c := &FileInputConfig{}
+	if err := c.Configure(map[string]interface{}{`path`: `test.log`}); err != nil {
+		t.Fatal(err)
+	}
+	if c.OnFailure != FailureHold {
+		t.Fatalf("expected default onFailure %s, got %s", FailureHold, c.OnFailure)
+	}
+	if err := c.Configure(map[string]interface{}{`path`: `test.log`, `onFailure`: `drop`}); err == nil {
+		t.Fatal("expected an invalid onFailure to be rejected")
+	}
+}
@@ -8,6 +8,10 @@ import (
 	"github.com/jbvmio/lfm/plugin"
 )
 
+func init() {
+	plugin.RegisterOutput(`stdout`, func() plugin.OutputConfig { return &StdOutputConfig{} })
+}
+
 // StdOutputConfig contains configuration details when using the StdOutput Plugin.
 type StdOutputConfig struct {
 }
@@ -20,7 +24,7 @@ func (c *StdOutputConfig) Configure(details map[string]interface{}) error {
 // CreateOutput creates an Input based on the Config.
 func (c *StdOutputConfig) CreateOutput() (plugin.Output, error) {
 	return &StdOutput{
-		data:     make(chan []byte),
+		data:     make(chan plugin.Event),
 		errs:     make(chan error),
 		stopChan: make(chan struct{}),
 		wg:       sync.WaitGroup{},
@@ -29,7 +33,7 @@ func (c *StdOutputConfig) CreateOutput() (plugin.Output, error) {
 
 // StdOutput outputs to stdout.
 type StdOutput struct {
-	data     chan []byte
+	data     chan plugin.Event
 	errs     chan error
 	stopChan chan struct{}
 	wg       sync.WaitGroup
@@ -45,8 +49,9 @@ func (out *StdOutput) Start() error {
 			select {
 			case <-out.stopChan:
 				break outLoop
-			case input := <-out.data:
-				fmt.Fprintf(os.Stdout, "%s\n", input)
+			case e := <-out.data:
+				_, err := fmt.Fprintf(os.Stdout, "%s\n", e.Data)
+				e.Ack(err)
 			}
 		}
 	}()
@@ -61,7 +66,7 @@ func (out *StdOutput) Stop() error {
 }
 
 // Destination returns the channel used for accept data to the intended Plugin destination.
-func (out *StdOutput) Destination() chan<- []byte {
+func (out *StdOutput) Destination() chan<- plugin.Event {
 	return out.data
 }
 
@@ -1,6 +1,7 @@
 package plugin
 
-// TypeID are used to assign IDs to available Plugins.
+// TypeID are used to assign IDs to the built-in Plugins.
+// Plugins are created by their registered name, see RegisterInput and RegisterOutput.
 type TypeID int
 
 // Available PluginTypes:
@@ -33,6 +34,7 @@ type Plugin interface {
 	// Start should initialize and start the Plugin, assigning defaults and return any errors if misconfigured.
 	Start() error
 	// Stop should stop the Plugin, returning any errors.
+	// Stop may be called without calling Start, releasing any resources acquired when the Plugin was created.
 	Stop() error
 	// Errors channel is used to send or receive errors while the Plugin is running.
 	Errors() <-chan error
@@ -41,11 +43,30 @@ type Plugin interface {
 // Input works with sources of data.
 type Input interface {
 	Plugin
-	Source() <-chan []byte
+	// Source returns the channel of Events provided by the Input.
+	// Each Event is acknowledged once it has been delivered to all Outputs or intentionally discarded.
+	Source() <-chan Event
 }
 
 // Output works with storing of data.
 type Output interface {
 	Plugin
-	Destination() chan<- []byte
+	// Destination returns the channel used to send Events to the Output.
+	// The Output must acknowledge each Event once delivered, or with an error if delivery failed.
+	Destination() chan<- Event
+}
+
+// Checker is optionally implemented by Plugins able to report their health.
+// Plugins which do not implement Checker are considered healthy while running.
+type Checker interface {
+	// Health returns an error describing why the Plugin is unhealthy, or nil if healthy.
+	Health() error
+}
+
+// Health returns the health of the Plugin if it implements Checker, otherwise nil.
+func Health(x Plugin) error {
+	if c, ok := x.(Checker); ok {
+		return c.Health()
+	}
+	return nil
 }
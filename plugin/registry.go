@@ -0,0 +1,112 @@
+package plugin
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Config represents configuration details for a Input or Output Plugin.
+type Config interface {
+	Configure(map[string]interface{}) error
+}
+
+// InputConfig is a Config for an Input Plugin.
+type InputConfig interface {
+	Config
+	CreateInput() (Input, error)
+}
+
+// OutputConfig is a Config for an Output Plugin.
+type OutputConfig interface {
+	Config
+	CreateOutput() (Output, error)
+}
+
+// InputFactory returns a new, unconfigured InputConfig.
+type InputFactory func() InputConfig
+
+// OutputFactory returns a new, unconfigured OutputConfig.
+type OutputFactory func() OutputConfig
+
+var (
+	registryLock sync.RWMutex
+	inputs       = make(map[string]InputFactory)
+	outputs      = make(map[string]OutputFactory)
+)
+
+// RegisterInput makes an Input Plugin available by the name used for plugin in the configuration.
+// It is intended to be called from the init function of the package providing the Plugin
+// and panics if the name is already registered or the factory is nil.
+func RegisterInput(name string, factory InputFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if factory == nil {
+		panic("plugin: RegisterInput factory is nil for " + name)
+	}
+	if _, dup := inputs[name]; dup {
+		panic("plugin: RegisterInput called twice for " + name)
+	}
+	inputs[name] = factory
+}
+
+// RegisterOutput makes an Output Plugin available by the name used for plugin in the configuration.
+// It is intended to be called from the init function of the package providing the Plugin
+// and panics if the name is already registered or the factory is nil.
+func RegisterOutput(name string, factory OutputFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	if factory == nil {
+		panic("plugin: RegisterOutput factory is nil for " + name)
+	}
+	if _, dup := outputs[name]; dup {
+		panic("plugin: RegisterOutput called twice for " + name)
+	}
+	outputs[name] = factory
+}
+
+// NewInputConfig returns a new InputConfig for the named Input Plugin.
+func NewInputConfig(name string) (InputConfig, error) {
+	registryLock.RLock()
+	factory, ok := inputs[name]
+	registryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no defined input plugin named %s available", name)
+	}
+	return factory(), nil
+}
+
+// NewOutputConfig returns a new OutputConfig for the named Output Plugin.
+func NewOutputConfig(name string) (OutputConfig, error) {
+	registryLock.RLock()
+	factory, ok := outputs[name]
+	registryLock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("no defined output plugin named %s available", name)
+	}
+	return factory(), nil
+}
+
+// Inputs returns the sorted names of all registered Input Plugins.
+func Inputs() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(inputs))
+	for name := range inputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Outputs returns the sorted names of all registered Output Plugins.
+func Outputs() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	names := make([]string, 0, len(outputs))
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,268 @@
+package queue
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jbvmio/lfm/plugin"
+)
+
+// Output persists Events in a Queue before delivering them to the underlying Output.
+// Events are acknowledged once written to the Queue and removed from the Queue once delivered.
+// Events the underlying Output fails to deliver are kept in the Queue and retried using a backoff until delivered.
+// Records dropped from a full Queue are reported as errors along with the errors of the underlying Output.
+type Output struct {
+	queue    *Queue
+	out      plugin.Output
+	data     chan plugin.Event
+	errs     chan error
+	stopChan chan struct{}
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewOutput returns an Output using the given Queue in front of the given Output.
+func NewOutput(q *Queue, out plugin.Output) *Output {
+	return &Output{
+		queue:    q,
+		out:      out,
+		data:     make(chan plugin.Event),
+		errs:     make(chan error),
+		stopChan: make(chan struct{}),
+		done:     make(chan struct{}),
+		wg:       sync.WaitGroup{},
+	}
+}
+
+// Start starts the plugin.
+func (o *Output) Start() error {
+	if err := o.out.Start(); err != nil {
+		return err
+	}
+	go forwardErrors(o.out.Errors(), o.errs, o.stopChan, o.done)
+	o.wg.Add(2)
+	go func() {
+		defer o.wg.Done()
+		for {
+			select {
+			case <-o.stopChan:
+				return
+			case e := <-o.data:
+				err := o.queue.Put(encodeEvent(e))
+				if err == ErrClosed {
+					// not acknowledged, allowing the Event to be replayed.
+					return
+				}
+				e.Ack(err)
+				reportDropped(o.queue, o.errs, o.stopChan)
+			}
+		}
+	}()
+	go func() {
+		defer o.wg.Done()
+		s := &sender{queue: o.queue, dest: o.out.Destination(), stopChan: o.stopChan}
+		s.run()
+	}()
+	return nil
+}
+
+// Stop stops the plugin, keeping any undelivered Events in the Queue.
+func (o *Output) Stop() error {
+	close(o.stopChan)
+	o.queue.Close()
+	o.wg.Wait()
+	defer close(o.done)
+	return o.out.Stop()
+}
+
+// Destination returns the channel used for accept data to the intended Plugin destination.
+func (o *Output) Destination() chan<- plugin.Event {
+	return o.data
+}
+
+// Errors returns the error channel for the Queue and the underlying Output.
+func (o *Output) Errors() <-chan error {
+	return o.errs
+}
+
+// Health returns the health of the underlying Output.
+func (o *Output) Health() error {
+	return plugin.Health(o.out)
+}
+
+// forwardErrors sends errors received from the underlying plugin to the given error channel until done is closed.
+// Errors received once stopped are discarded, allowing the underlying plugin to stop.
+func forwardErrors(from <-chan error, to chan<- error, stopChan, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case err := <-from:
+			select {
+			case to <- err:
+			case <-stopChan:
+			}
+		}
+	}
+}
+
+// reportDropped sends an error to the error channel if records were dropped from the Queue to make space.
+func reportDropped(q *Queue, errs chan<- error, stopChan <-chan struct{}) {
+	n := q.takeDropped()
+	if n == 0 {
+		return
+	}
+	select {
+	case errs <- fmt.Errorf("queue %s is full, dropped %d unacknowledged record(s)", q.dir, n):
+	case <-stopChan:
+	}
+}
+
+// sender sends Entries of a Queue as Events to a destination. Entries are acknowledged in the Queue
+// once their Event is acknowledged without error, otherwise they are sent again after a backoff.
+type sender struct {
+	queue    *Queue
+	dest     chan<- plugin.Event
+	stopChan chan struct{}
+}
+
+// run sends each Entry read from the Queue until stopped.
+func (s *sender) run() {
+	for {
+		entry, err := s.queue.Get(s.stopChan)
+		if err != nil {
+			return
+		}
+		if !s.send(entry, 0) {
+			return
+		}
+	}
+}
+
+// send sends the Entry to the destination, returning false if stopped first.
+// The Entry is acknowledged in the Queue once its Event is acknowledged without error, otherwise it is retried.
+func (s *sender) send(entry Entry, n int) bool {
+	event, err := decodeEvent(entry.Data, func(err error) {
+		if err != nil {
+			s.retry(entry, n)
+			return
+		}
+		s.queue.Ack(entry.ID)
+	})
+	if err != nil {
+		// the record can never be decoded, remove it from the Queue.
+		s.queue.Ack(entry.ID)
+		return true
+	}
+	select {
+	case <-s.stopChan:
+		return false
+	case s.dest <- event:
+		return true
+	}
+}
+
+// retry sends the Entry again after a backoff. Entries which are not acknowledged before
+// the sender is stopped remain in the Queue.
+func (s *sender) retry(entry Entry, n int) {
+	backoff := s.queue.cfg.MinBackoff
+	for i := 0; i < n && backoff < s.queue.cfg.MaxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > s.queue.cfg.MaxBackoff {
+		backoff = s.queue.cfg.MaxBackoff
+	}
+	go func() {
+		timer := time.NewTimer(backoff)
+		defer timer.Stop()
+		select {
+		case <-timer.C:
+			s.send(entry, n+1)
+		case <-s.stopChan:
+		}
+	}()
+}
+
+// Input persists Events received from the underlying Input in a Queue before providing them to the Pipeline.
+// Events from the underlying Input are written to the Queue with their Metadata and acknowledged once written.
+// Events are removed from the Queue once processed, while Events acknowledged with an error are kept in the Queue
+// and provided again using a backoff. A dead letter output may be used to remove Events which can never be processed.
+// Records dropped from a full Queue are reported as errors along with the errors of the underlying Input.
+type Input struct {
+	queue    *Queue
+	in       plugin.Input
+	data     chan plugin.Event
+	errs     chan error
+	stopChan chan struct{}
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewInput returns an Input using the given Queue behind the given Input.
+func NewInput(q *Queue, in plugin.Input) *Input {
+	return &Input{
+		queue:    q,
+		in:       in,
+		data:     make(chan plugin.Event),
+		errs:     make(chan error),
+		stopChan: make(chan struct{}),
+		done:     make(chan struct{}),
+		wg:       sync.WaitGroup{},
+	}
+}
+
+// Start starts the plugin.
+func (i *Input) Start() error {
+	if err := i.in.Start(); err != nil {
+		return err
+	}
+	go forwardErrors(i.in.Errors(), i.errs, i.stopChan, i.done)
+	i.wg.Add(2)
+	go func() {
+		defer i.wg.Done()
+		for {
+			select {
+			case <-i.stopChan:
+				return
+			case e := <-i.in.Source():
+				err := i.queue.Put(encodeEvent(e))
+				if err == ErrClosed {
+					return
+				}
+				e.Ack(err)
+				reportDropped(i.queue, i.errs, i.stopChan)
+			}
+		}
+	}()
+	go func() {
+		defer i.wg.Done()
+		s := &sender{queue: i.queue, dest: i.data, stopChan: i.stopChan}
+		s.run()
+	}()
+	return nil
+}
+
+// Stop stops the plugin, keeping any unprocessed Events in the Queue.
+func (i *Input) Stop() error {
+	close(i.stopChan)
+	i.queue.Close()
+	i.wg.Wait()
+	defer close(i.done)
+	return i.in.Stop()
+}
+
+// Source returns the oncoming data channel for the Input Plugin.
+func (i *Input) Source() <-chan plugin.Event {
+	return i.data
+}
+
+// Errors returns the error channel for the Queue and the underlying Input.
+func (i *Input) Errors() <-chan error {
+	return i.errs
+}
+
+// Health returns the health of the underlying Input.
+func (i *Input) Health() error {
+	return plugin.Health(i.in)
+}
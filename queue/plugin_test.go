@@ -0,0 +1,225 @@
+package queue
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jbvmio/lfm/plugin"
+)
+
+// failingOutput fails to deliver each Event a number of times before delivering it.
+type failingOutput struct {
+	fails     int
+	attempts  map[string]int
+	data      chan plugin.Event
+	delivered chan []byte
+	errs      chan error
+}
+
+func newFailingOutput(fails int) *failingOutput {
+	return &failingOutput{
+		fails:     fails,
+		attempts:  make(map[string]int),
+		data:      make(chan plugin.Event),
+		delivered: make(chan []byte, 10),
+		errs:      make(chan error),
+	}
+}
+
+func (out *failingOutput) Start() error {
+	go func() {
+		for e := range out.data {
+			out.attempts[string(e.Data)]++
+			if out.attempts[string(e.Data)] <= out.fails {
+				e.Ack(errors.New("delivery failed"))
+				continue
+			}
+			out.delivered <- e.Data
+			e.Ack(nil)
+		}
+	}()
+	return nil
+}
+
+func (out *failingOutput) Stop() error                      { return nil }
+func (out *failingOutput) Destination() chan<- plugin.Event { return out.data }
+func (out *failingOutput) Errors() <-chan error             { return out.errs }
+
+func TestOutputRetriesUntilDelivered(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q := openQueue(t, dir, Config{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+	out := newFailingOutput(3)
+	o := NewOutput(q, out)
+	if err := o.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer o.Stop()
+
+	acked := make(chan error, 1)
+	o.Destination() <- plugin.NewEvent(record(0), func(err error) { acked <- err })
+	if err := <-acked; err != nil {
+		t.Fatalf("expected event to be acknowledged once queued, got %v", err)
+	}
+	select {
+	case data := <-out.delivered:
+		if string(data) != string(record(0)) {
+			t.Fatalf("expected %s, got %s", record(0), data)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("event was not delivered")
+	}
+	deadline := time.Now().Add(time.Second)
+	for q.Len() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected delivered record to be removed from the queue, %d remain", q.Len())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestOutputKeepsUndelivered(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := Config{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond}
+	q := openQueue(t, dir, cfg)
+	o := NewOutput(q, newFailingOutput(1<<30))
+	if err := o.Start(); err != nil {
+		t.Fatal(err)
+	}
+	acked := make(chan error, 1)
+	o.Destination() <- plugin.NewEvent(record(0), func(err error) { acked <- err })
+	<-acked
+	time.Sleep(20 * time.Millisecond)
+	if err := o.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	q = openQueue(t, dir, cfg)
+	defer q.Close()
+	if got := q.Len(); got != 1 {
+		t.Fatalf("expected the undelivered record to remain queued, got %d", got)
+	}
+}
+
+// sliceInput provides each of its Events once started.
+type sliceInput struct {
+	events []plugin.Event
+	data   chan plugin.Event
+	errs   chan error
+}
+
+func (in *sliceInput) Start() error {
+	go func() {
+		for _, e := range in.events {
+			in.data <- e
+		}
+	}()
+	return nil
+}
+
+func (in *sliceInput) Stop() error                 { return nil }
+func (in *sliceInput) Source() <-chan plugin.Event { return in.data }
+func (in *sliceInput) Errors() <-chan error        { return in.errs }
+
+func TestInputKeepsMetadata(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q := openQueue(t, dir, Config{})
+	e := plugin.NewEvent(record(0), nil)
+	e.Metadata = map[string]string{`topic`: `logs`, `partition`: `3`, `key`: ``}
+	in := NewInput(q, &sliceInput{
+		events: []plugin.Event{e, plugin.NewEvent(record(1), nil)},
+		data:   make(chan plugin.Event),
+		errs:   make(chan error),
+	})
+	if err := in.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer in.Stop()
+
+	got := <-in.Source()
+	if string(got.Data) != string(record(0)) {
+		t.Fatalf("expected %s, got %s", record(0), got.Data)
+	}
+	if len(got.Metadata) != len(e.Metadata) {
+		t.Fatalf("expected metadata %v, got %v", e.Metadata, got.Metadata)
+	}
+	for k, v := range e.Metadata {
+		if x, ok := got.Metadata[k]; !ok || x != v {
+			t.Fatalf("expected metadata %v, got %v", e.Metadata, got.Metadata)
+		}
+	}
+	got = <-in.Source()
+	if string(got.Data) != string(record(1)) || got.Metadata != nil {
+		t.Fatalf("expected %s without metadata, got %s with %v", record(1), got.Data, got.Metadata)
+	}
+}
+
+func TestInputKeepsFailed(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q := openQueue(t, dir, Config{MinBackoff: time.Millisecond, MaxBackoff: 5 * time.Millisecond})
+	in := NewInput(q, &sliceInput{
+		events: []plugin.Event{plugin.NewEvent(record(0), nil)},
+		data:   make(chan plugin.Event),
+		errs:   make(chan error),
+	})
+	if err := in.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer in.Stop()
+
+	for n := 0; n < 3; n++ {
+		select {
+		case e := <-in.Source():
+			if string(e.Data) != string(record(0)) {
+				t.Fatalf("expected %s, got %s", record(0), e.Data)
+			}
+			if n < 2 {
+				e.Ack(errors.New("processing failed"))
+				if got := q.Len(); got != 1 {
+					t.Fatalf("expected the failed record to remain queued, got %d", got)
+				}
+				continue
+			}
+			e.Ack(nil)
+		case <-time.After(time.Second):
+			t.Fatalf("expected the failed record to be provided again, received it %d time(s)", n)
+		}
+	}
+	if got := q.Len(); got != 0 {
+		t.Fatalf("expected the processed record to be removed from the queue, %d remain", got)
+	}
+}
+
+func TestOutputReportsDropped(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	// encoded Events are a byte larger than their record.
+	q := openQueue(t, dir, Config{MaxSize: 2 * (recordSize + 1), SegmentSize: recordSize + 1, FullPolicy: PolicyDropOldest, MinBackoff: time.Hour})
+	o := NewOutput(q, newFailingOutput(1<<30))
+	if err := o.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer o.Stop()
+
+	for i := 0; i < 3; i++ {
+		acked := make(chan error, 1)
+		o.Destination() <- plugin.NewEvent(record(i), func(err error) { acked <- err })
+		if err := <-acked; err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case err := <-o.Errors():
+		if !strings.Contains(err.Error(), "dropped 1 unacknowledged record") {
+			t.Fatalf("expected the dropped record to be reported, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected the dropped record to be reported")
+	}
+}
@@ -0,0 +1,639 @@
+package queue
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jbvmio/lfm/metrics"
+	"github.com/jbvmio/lfm/plugin"
+)
+
+// Full Queue Policies:
+const (
+	PolicyBlock      = `block`
+	PolicyDropOldest = `dropOldest`
+)
+
+// Fsync Policies:
+const (
+	FsyncAlways   = `always`
+	FsyncInterval = `interval`
+	FsyncNever    = `never`
+)
+
+// Queue defaults.
+const (
+	DefaultMaxSize       = 1 << 30
+	DefaultSegmentSize   = 64 << 20
+	DefaultFsyncInterval = time.Second
+	DefaultMinBackoff    = time.Second
+	DefaultMaxBackoff    = 30 * time.Second
+)
+
+const (
+	segmentExt   = `.seg`
+	cursorFile   = `cursor`
+	headerLength = 8
+)
+
+// Errors returned by a Queue.
+var (
+	ErrClosed   = errors.New("queue closed")
+	ErrTooLarge = errors.New("record exceeds queue max size")
+)
+
+// Config contains configuration details for a disk Queue.
+// MinBackoff and MaxBackoff bound the delay before retrying Events which failed to be delivered or processed.
+// Input enables an additional Queue between the Inputs and the Pipeline.
+type Config struct {
+	Path          string        `yaml:"path" json:"path"`
+	MaxSize       ByteSize      `yaml:"maxSize" json:"maxSize"`
+	SegmentSize   ByteSize      `yaml:"segmentSize" json:"segmentSize"`
+	FullPolicy    string        `yaml:"fullPolicy" json:"fullPolicy"`
+	Fsync         string        `yaml:"fsync" json:"fsync"`
+	FsyncInterval time.Duration `yaml:"fsyncInterval" json:"fsyncInterval"`
+	MinBackoff    time.Duration `yaml:"minBackoff" json:"minBackoff"`
+	MaxBackoff    time.Duration `yaml:"maxBackoff" json:"maxBackoff"`
+	Input         bool          `yaml:"input" json:"input"`
+}
+
+// Validate assigns any defaults and returns an error if the Config is invalid.
+func (c *Config) Validate() error {
+	if c.Path == "" {
+		return fmt.Errorf("missing path for queue")
+	}
+	if c.MaxSize == 0 {
+		c.MaxSize = DefaultMaxSize
+	}
+	if c.SegmentSize == 0 {
+		c.SegmentSize = DefaultSegmentSize
+	}
+	if c.SegmentSize > c.MaxSize {
+		c.SegmentSize = c.MaxSize
+	}
+	if c.FsyncInterval == 0 {
+		c.FsyncInterval = DefaultFsyncInterval
+	}
+	if c.MinBackoff == 0 {
+		c.MinBackoff = DefaultMinBackoff
+	}
+	if c.MaxBackoff == 0 {
+		c.MaxBackoff = DefaultMaxBackoff
+	}
+	switch c.FullPolicy {
+	case "":
+		c.FullPolicy = PolicyBlock
+	case PolicyBlock, PolicyDropOldest:
+	default:
+		return fmt.Errorf("invalid queue fullPolicy: %s", c.FullPolicy)
+	}
+	switch c.Fsync {
+	case "":
+		c.Fsync = FsyncInterval
+	case FsyncAlways, FsyncInterval, FsyncNever:
+	default:
+		return fmt.Errorf("invalid queue fsync: %s", c.Fsync)
+	}
+	return nil
+}
+
+// ByteSize is a size in bytes which can be configured using a KB, MB or GB suffix.
+type ByteSize int64
+
+// UnmarshalYAML parses a ByteSize from either a number of bytes or a string such as 512MB.
+func (b *ByteSize) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var n int64
+	if err := unmarshal(&n); err == nil {
+		*b = ByteSize(n)
+		return nil
+	}
+	var s string
+	if err := unmarshal(&s); err != nil {
+		return err
+	}
+	s = strings.ToUpper(strings.TrimSpace(s))
+	mult := int64(1)
+	for _, u := range []struct {
+		suffix string
+		mult   int64
+	}{{`GB`, 1 << 30}, {`MB`, 1 << 20}, {`KB`, 1 << 10}, {`B`, 1}} {
+		if strings.HasSuffix(s, u.suffix) {
+			s = strings.TrimSpace(strings.TrimSuffix(s, u.suffix))
+			mult = u.mult
+			break
+		}
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid size: %s", s)
+	}
+	*b = ByteSize(n * mult)
+	return nil
+}
+
+// Entry is a record read from a Queue.
+type Entry struct {
+	ID   uint64
+	Data []byte
+}
+
+type segment struct {
+	base  uint64
+	count uint64
+	size  int64
+	path  string
+}
+
+// Queue is a write-ahead, segment based queue stored on disk.
+// Records are removed once acknowledged and any unacknowledged records are read again after a restart.
+type Queue struct {
+	cfg         Config
+	dir         string
+	lock        sync.Mutex
+	segments    []*segment
+	w           *os.File
+	r           *os.File
+	rBase       uint64
+	rOff        int64
+	readSeq     uint64
+	writeSeq    uint64
+	acked       uint64
+	savedCursor uint64
+	size        int64
+	dropped     uint64
+	dirty       bool
+	tracker     *plugin.OffsetTracker
+	readable    chan struct{}
+	space       chan struct{}
+	closed      chan struct{}
+	wg          sync.WaitGroup
+}
+
+// Open opens or creates a Queue in the given directory, recovering any existing records.
+func Open(dir string, cfg Config) (*Queue, error) {
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("could not create queue directory: %w", err)
+	}
+	q := &Queue{
+		cfg:      cfg,
+		dir:      dir,
+		readable: make(chan struct{}, 1),
+		space:    make(chan struct{}),
+		closed:   make(chan struct{}),
+	}
+	if err := q.recover(); err != nil {
+		return nil, fmt.Errorf("could not recover queue %s: %w", dir, err)
+	}
+	q.wg.Add(1)
+	go q.sync()
+	return q, nil
+}
+
+// recover loads existing segments and the saved cursor.
+func (q *Queue) recover() error {
+	files, err := filepath.Glob(filepath.Join(q.dir, `*`+segmentExt))
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		base, err := strconv.ParseUint(strings.TrimSuffix(filepath.Base(f), segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		q.segments = append(q.segments, &segment{base: base, path: f})
+	}
+	sort.Slice(q.segments, func(i, j int) bool {
+		return q.segments[i].base < q.segments[j].base
+	})
+	for i, seg := range q.segments {
+		count, size, err := scanSegment(seg.path, -1)
+		if err != nil {
+			return err
+		}
+		if i == len(q.segments)-1 {
+			// discard any partially written record at the end of the last segment.
+			if err := os.Truncate(seg.path, size); err != nil {
+				return err
+			}
+		}
+		seg.count, seg.size = count, size
+		q.size += size
+	}
+	cursor, err := q.readCursor()
+	if err != nil {
+		return err
+	}
+	switch len(q.segments) {
+	case 0:
+		q.writeSeq = cursor
+	default:
+		last := q.segments[len(q.segments)-1]
+		q.writeSeq = last.base + last.count
+		if cursor < q.segments[0].base {
+			cursor = q.segments[0].base
+		}
+	}
+	if cursor > q.writeSeq {
+		cursor = q.writeSeq
+	}
+	q.acked, q.savedCursor, q.readSeq = cursor, cursor, cursor
+	q.tracker = plugin.NewOffsetTracker(int64(cursor))
+	q.removeAcked()
+	if len(q.segments) > 0 {
+		last := q.segments[len(q.segments)-1]
+		q.w, err = os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scanSegment reads up to max records from the segment, returning the number of valid records and their total size.
+// A negative max reads all records.
+func scanSegment(path string, max int64) (uint64, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+	var count uint64
+	var off int64
+	header := make([]byte, headerLength)
+	for max < 0 || int64(count) < max {
+		if _, err := f.ReadAt(header, off); err != nil {
+			break
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		data := make([]byte, length)
+		if _, err := f.ReadAt(data, off+headerLength); err != nil {
+			break
+		}
+		if crc32.ChecksumIEEE(data) != binary.BigEndian.Uint32(header[4:]) {
+			break
+		}
+		count++
+		off += headerLength + length
+	}
+	return count, off, nil
+}
+
+func (q *Queue) readCursor() (uint64, error) {
+	b, err := ioutil.ReadFile(filepath.Join(q.dir, cursorFile))
+	switch {
+	case os.IsNotExist(err):
+		return 0, nil
+	case err != nil:
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+}
+
+func (q *Queue) writeCursor(cursor uint64) error {
+	path := filepath.Join(q.dir, cursorFile)
+	err := ioutil.WriteFile(path+`.tmp`, []byte(strconv.FormatUint(cursor, 10)+"\n"), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+`.tmp`, path)
+}
+
+func (q *Queue) segmentFor(seq uint64) *segment {
+	for _, seg := range q.segments {
+		if seq >= seg.base && seq < seg.base+seg.count {
+			return seg
+		}
+	}
+	return nil
+}
+
+// Put appends the data to the Queue. If the Queue is full, Put either blocks until space is available
+// or removes the oldest segment, depending on the configured FullPolicy.
+func (q *Queue) Put(b []byte) error {
+	length := int64(len(b)) + headerLength
+	if length > int64(q.cfg.MaxSize) {
+		return ErrTooLarge
+	}
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for q.size+length > int64(q.cfg.MaxSize) {
+		select {
+		case <-q.closed:
+			return ErrClosed
+		default:
+		}
+		if q.acked == q.writeSeq && q.size > 0 {
+			// all records are acknowledged, rotate the write segment so it can be removed.
+			if err := q.rotate(); err != nil {
+				return err
+			}
+			q.removeAcked()
+			continue
+		}
+		if q.cfg.FullPolicy == PolicyDropOldest {
+			if err := q.dropOldest(); err != nil {
+				return err
+			}
+			continue
+		}
+		space := q.space
+		q.lock.Unlock()
+		select {
+		case <-space:
+		case <-q.closed:
+		}
+		q.lock.Lock()
+	}
+	select {
+	case <-q.closed:
+		return ErrClosed
+	default:
+	}
+	if q.w == nil || q.segments[len(q.segments)-1].size+length > int64(q.cfg.SegmentSize) {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+	rec := make([]byte, length)
+	binary.BigEndian.PutUint32(rec[:4], uint32(len(b)))
+	binary.BigEndian.PutUint32(rec[4:8], crc32.ChecksumIEEE(b))
+	copy(rec[headerLength:], b)
+	if _, err := q.w.Write(rec); err != nil {
+		return fmt.Errorf("could not write to queue: %w", err)
+	}
+	if q.cfg.Fsync == FsyncAlways {
+		if err := q.w.Sync(); err != nil {
+			return fmt.Errorf("could not sync queue: %w", err)
+		}
+	} else {
+		q.dirty = true
+	}
+	seg := q.segments[len(q.segments)-1]
+	seg.count++
+	seg.size += length
+	q.size += length
+	q.writeSeq++
+	notify(q.readable)
+	return nil
+}
+
+// rotate closes the current write segment and starts a new one.
+func (q *Queue) rotate() error {
+	if q.w != nil {
+		if err := q.w.Sync(); err != nil {
+			return err
+		}
+		q.w.Close()
+	}
+	path := filepath.Join(q.dir, fmt.Sprintf("%020d%s", q.writeSeq, segmentExt))
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("could not create queue segment: %w", err)
+	}
+	q.w = f
+	q.dirty = false
+	q.segments = append(q.segments, &segment{base: q.writeSeq, path: path})
+	return nil
+}
+
+// dropOldest removes the oldest segment regardless of whether its records have been acknowledged.
+// The records not yet acknowledged are counted as dropped.
+func (q *Queue) dropOldest() error {
+	if len(q.segments) == 1 {
+		if err := q.rotate(); err != nil {
+			return err
+		}
+	}
+	seg := q.segments[0]
+	next := seg.base + seg.count
+	if q.acked < next {
+		from := q.acked
+		if from < seg.base {
+			from = seg.base
+		}
+		q.dropped += next - from
+		metrics.QueueDropped.WithLabelValues(q.dir).Add(float64(next - from))
+		q.acked = next
+	}
+	if q.readSeq < next {
+		q.readSeq = next
+		q.closeReader()
+	}
+	return q.removeSegment()
+}
+
+// removeAcked removes any segments whose records have all been acknowledged.
+func (q *Queue) removeAcked() {
+	for len(q.segments) > 1 && q.segments[0].base+q.segments[0].count <= q.acked {
+		q.removeSegment()
+	}
+}
+
+func (q *Queue) removeSegment() error {
+	seg := q.segments[0]
+	if q.r != nil && q.rBase == seg.base {
+		q.closeReader()
+	}
+	q.segments = q.segments[1:]
+	q.size -= seg.size
+	q.wakeSpace()
+	if err := os.Remove(seg.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("could not remove queue segment: %w", err)
+	}
+	return nil
+}
+
+// wakeSpace wakes all Puts waiting for space.
+func (q *Queue) wakeSpace() {
+	close(q.space)
+	q.space = make(chan struct{})
+}
+
+func (q *Queue) closeReader() {
+	if q.r != nil {
+		q.r.Close()
+		q.r = nil
+	}
+	q.rOff = 0
+}
+
+// Get returns the next unread Entry, blocking until one is available, the stop channel is closed or the Queue is closed.
+// Each Entry must be acknowledged using Ack once it has been delivered.
+func (q *Queue) Get(stop <-chan struct{}) (Entry, error) {
+	for {
+		q.lock.Lock()
+		if q.readSeq < q.writeSeq {
+			data, err := q.read()
+			if err != nil {
+				q.lock.Unlock()
+				return Entry{}, err
+			}
+			id := q.tracker.Add(int64(q.readSeq + 1))
+			q.readSeq++
+			q.lock.Unlock()
+			return Entry{ID: id, Data: data}, nil
+		}
+		q.lock.Unlock()
+		select {
+		case <-q.readable:
+		case <-stop:
+			return Entry{}, ErrClosed
+		case <-q.closed:
+			return Entry{}, ErrClosed
+		}
+	}
+}
+
+// read reads the record at readSeq.
+func (q *Queue) read() ([]byte, error) {
+	seg := q.segmentFor(q.readSeq)
+	if seg == nil {
+		return nil, fmt.Errorf("missing queue segment for record %d", q.readSeq)
+	}
+	if q.r == nil || q.rBase != seg.base {
+		q.closeReader()
+		f, err := os.Open(seg.path)
+		if err != nil {
+			return nil, fmt.Errorf("could not open queue segment: %w", err)
+		}
+		q.r, q.rBase = f, seg.base
+		if q.readSeq > seg.base {
+			_, off, err := scanSegment(seg.path, int64(q.readSeq-seg.base))
+			if err != nil {
+				return nil, err
+			}
+			q.rOff = off
+		}
+	}
+	header := make([]byte, headerLength)
+	if _, err := q.r.ReadAt(header, q.rOff); err != nil {
+		return nil, fmt.Errorf("could not read queue record: %w", err)
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[:4]))
+	if _, err := q.r.ReadAt(data, q.rOff+headerLength); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("could not read queue record: %w", err)
+	}
+	q.rOff += headerLength + int64(len(data))
+	return data, nil
+}
+
+// Ack acknowledges the Entry with the given ID, removing it from the Queue once all previous Entries are acknowledged.
+func (q *Queue) Ack(id uint64) {
+	committed, advanced := q.tracker.Done(id)
+	if !advanced {
+		return
+	}
+	q.lock.Lock()
+	if uint64(committed) > q.acked {
+		q.acked = uint64(committed)
+	}
+	q.removeAcked()
+	if q.acked == q.writeSeq {
+		// the write segment can only be removed by a Put, wake any waiting for space.
+		q.wakeSpace()
+	}
+	q.lock.Unlock()
+}
+
+// takeDropped returns the number of records dropped since it was last called.
+func (q *Queue) takeDropped() uint64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	n := q.dropped
+	q.dropped = 0
+	return n
+}
+
+// Len returns the number of unacknowledged records in the Queue.
+func (q *Queue) Len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return int(q.writeSeq - q.acked)
+}
+
+// Size returns the size in bytes used by the Queue on disk.
+func (q *Queue) Size() int64 {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return q.size
+}
+
+// sync periodically flushes written records and saves the acknowledged cursor.
+func (q *Queue) sync() {
+	defer q.wg.Done()
+	ticker := time.NewTicker(q.cfg.FsyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-q.closed:
+			return
+		case <-ticker.C:
+			q.lock.Lock()
+			q.flush()
+			q.lock.Unlock()
+		}
+	}
+}
+
+func (q *Queue) flush() error {
+	if q.dirty && q.cfg.Fsync != FsyncNever && q.w != nil {
+		if err := q.w.Sync(); err != nil {
+			return err
+		}
+		q.dirty = false
+	}
+	if q.acked != q.savedCursor {
+		if err := q.writeCursor(q.acked); err != nil {
+			return err
+		}
+		q.savedCursor = q.acked
+	}
+	return nil
+}
+
+// Close flushes and closes the Queue. Any unacknowledged records are kept on disk.
+func (q *Queue) Close() error {
+	q.lock.Lock()
+	select {
+	case <-q.closed:
+		q.lock.Unlock()
+		return nil
+	default:
+	}
+	close(q.closed)
+	q.lock.Unlock()
+	q.wg.Wait()
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.w != nil && q.cfg.Fsync != FsyncNever {
+		q.dirty = true
+	}
+	err := q.flush()
+	q.closeReader()
+	if q.w != nil {
+		q.w.Close()
+		q.w = nil
+	}
+	return err
+}
+
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,237 @@
+package queue
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// recordSize is the size on disk of each record used by the tests.
+const recordSize = 10 + headerLength
+
+func openQueue(t *testing.T, dir string, cfg Config) *Queue {
+	t.Helper()
+	cfg.Path = dir
+	q, err := Open(dir, cfg)
+	if err != nil {
+		t.Fatalf("open queue: %v", err)
+	}
+	return q
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "lfm-queue")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func record(i int) []byte {
+	return []byte(fmt.Sprintf("record-%03d", i))
+}
+
+func put(t *testing.T, q *Queue, from, to int) {
+	t.Helper()
+	for i := from; i < to; i++ {
+		if err := q.Put(record(i)); err != nil {
+			t.Fatalf("put %d: %v", i, err)
+		}
+	}
+}
+
+func get(t *testing.T, q *Queue, n int) []Entry {
+	t.Helper()
+	entries := make([]Entry, n)
+	for i := range entries {
+		e, err := q.Get(nil)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		entries[i] = e
+	}
+	return entries
+}
+
+func segments(t *testing.T, dir string) int {
+	t.Helper()
+	files, err := filepath.Glob(filepath.Join(dir, `*`+segmentExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(files)
+}
+
+func putWithin(q *Queue, data []byte, d time.Duration) error {
+	errs := make(chan error, 1)
+	go func() { errs <- q.Put(data) }()
+	select {
+	case err := <-errs:
+		return err
+	case <-time.After(d):
+		return fmt.Errorf("put blocked for %v", d)
+	}
+}
+
+func TestPutReclaimsAcknowledgedSegment(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q := openQueue(t, dir, Config{MaxSize: 5 * recordSize})
+	defer q.Close()
+
+	put(t, q, 0, 5)
+	for _, e := range get(t, q, 5) {
+		q.Ack(e.ID)
+	}
+	if err := putWithin(q, record(5), time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if got := q.Size(); got != recordSize {
+		t.Fatalf("expected size %d, got %d", recordSize, got)
+	}
+	if got := segments(t, dir); got != 1 {
+		t.Fatalf("expected 1 segment, got %d", got)
+	}
+}
+
+func TestPutWaitsForAcknowledgement(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q := openQueue(t, dir, Config{MaxSize: 5 * recordSize})
+	defer q.Close()
+
+	put(t, q, 0, 5)
+	entries := get(t, q, 5)
+	errs := make(chan error, 1)
+	go func() { errs <- q.Put(record(5)) }()
+	select {
+	case err := <-errs:
+		t.Fatalf("expected put to wait for space, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	for _, e := range entries {
+		q.Ack(e.ID)
+	}
+	select {
+	case err := <-errs:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("put blocked after all records were acknowledged")
+	}
+}
+
+func TestSegmentRotationAndRemoval(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q := openQueue(t, dir, Config{MaxSize: 10 * recordSize, SegmentSize: 2 * recordSize})
+	defer q.Close()
+
+	put(t, q, 0, 6)
+	if got := segments(t, dir); got != 3 {
+		t.Fatalf("expected 3 segments, got %d", got)
+	}
+	entries := get(t, q, 6)
+	q.Ack(entries[0].ID)
+	if got := segments(t, dir); got != 3 {
+		t.Fatalf("expected 3 segments with a partially acknowledged segment, got %d", got)
+	}
+	q.Ack(entries[1].ID)
+	if got := segments(t, dir); got != 2 {
+		t.Fatalf("expected 2 segments, got %d", got)
+	}
+	if got := q.Size(); got != 4*recordSize {
+		t.Fatalf("expected size %d, got %d", 4*recordSize, got)
+	}
+	// the write segment is kept until a Put requires space.
+	for _, e := range entries[2:] {
+		q.Ack(e.ID)
+	}
+	if got := segments(t, dir); got != 1 {
+		t.Fatalf("expected 1 segment, got %d", got)
+	}
+	if got := q.Len(); got != 0 {
+		t.Fatalf("expected no unacknowledged records, got %d", got)
+	}
+}
+
+func TestOutOfOrderAck(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q := openQueue(t, dir, Config{MaxSize: 10 * recordSize, SegmentSize: 2 * recordSize})
+	defer q.Close()
+
+	put(t, q, 0, 4)
+	entries := get(t, q, 4)
+	q.Ack(entries[1].ID)
+	q.Ack(entries[3].ID)
+	if got := q.Len(); got != 4 {
+		t.Fatalf("expected 4 unacknowledged records, got %d", got)
+	}
+	if got := segments(t, dir); got != 2 {
+		t.Fatalf("expected 2 segments, got %d", got)
+	}
+	q.Ack(entries[0].ID)
+	if got := q.Len(); got != 2 {
+		t.Fatalf("expected 2 unacknowledged records, got %d", got)
+	}
+	if got := segments(t, dir); got != 1 {
+		t.Fatalf("expected 1 segment, got %d", got)
+	}
+	q.Ack(entries[2].ID)
+	if got := q.Len(); got != 0 {
+		t.Fatalf("expected no unacknowledged records, got %d", got)
+	}
+}
+
+func TestRecoverUnacknowledged(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	cfg := Config{MaxSize: 10 * recordSize, SegmentSize: 2 * recordSize}
+	q := openQueue(t, dir, cfg)
+	put(t, q, 0, 5)
+	entries := get(t, q, 3)
+	q.Ack(entries[0].ID)
+	q.Ack(entries[2].ID)
+	if err := q.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	q = openQueue(t, dir, cfg)
+	defer q.Close()
+	if got := q.Len(); got != 4 {
+		t.Fatalf("expected 4 unacknowledged records after recovery, got %d", got)
+	}
+	for i, e := range get(t, q, 4) {
+		if want := string(record(i + 1)); string(e.Data) != want {
+			t.Fatalf("expected %s, got %s", want, e.Data)
+		}
+	}
+	put(t, q, 5, 6)
+	if e := get(t, q, 1)[0]; string(e.Data) != string(record(5)) {
+		t.Fatalf("expected %s, got %s", record(5), e.Data)
+	}
+}
+
+func TestDropOldest(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	q := openQueue(t, dir, Config{MaxSize: 4 * recordSize, SegmentSize: 2 * recordSize, FullPolicy: PolicyDropOldest})
+	defer q.Close()
+
+	put(t, q, 0, 6)
+	if got := q.Len(); got != 4 {
+		t.Fatalf("expected 4 records, got %d", got)
+	}
+	if got := q.takeDropped(); got != 2 {
+		t.Fatalf("expected 2 dropped records, got %d", got)
+	}
+	if e := get(t, q, 1)[0]; string(e.Data) != string(record(2)) {
+		t.Fatalf("expected %s, got %s", record(2), e.Data)
+	}
+}
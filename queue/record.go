@@ -0,0 +1,83 @@
+package queue
+
+import (
+	"encoding/binary"
+	"errors"
+	"sort"
+
+	"github.com/jbvmio/lfm/plugin"
+)
+
+var errInvalidRecord = errors.New("invalid queue record")
+
+// encodeEvent returns the record persisting the Data and Metadata of the Event.
+// The record holds the number of Metadata entries, each length prefixed key and value, followed by the Data.
+func encodeEvent(e plugin.Event) []byte {
+	keys := make([]string, 0, len(e.Metadata))
+	size := binary.MaxVarintLen64 + len(e.Data)
+	for k, v := range e.Metadata {
+		keys = append(keys, k)
+		size += 2*binary.MaxVarintLen64 + len(k) + len(v)
+	}
+	sort.Strings(keys)
+	b := make([]byte, 0, size)
+	b = appendUvarint(b, uint64(len(keys)))
+	for _, k := range keys {
+		b = appendString(b, k)
+		b = appendString(b, e.Metadata[k])
+	}
+	return append(b, e.Data...)
+}
+
+// decodeEvent returns the Event persisted in the record.
+func decodeEvent(b []byte, ack func(error)) (plugin.Event, error) {
+	n, b, err := readUvarint(b)
+	if err != nil {
+		return plugin.Event{}, err
+	}
+	var meta map[string]string
+	if n > 0 {
+		meta = make(map[string]string, n)
+	}
+	for i := uint64(0); i < n; i++ {
+		var k, v string
+		if k, b, err = readString(b); err != nil {
+			return plugin.Event{}, err
+		}
+		if v, b, err = readString(b); err != nil {
+			return plugin.Event{}, err
+		}
+		meta[k] = v
+	}
+	e := plugin.NewEvent(b, ack)
+	e.Metadata = meta
+	return e, nil
+}
+
+func appendUvarint(b []byte, x uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], x)]...)
+}
+
+func appendString(b []byte, s string) []byte {
+	return append(appendUvarint(b, uint64(len(s))), s...)
+}
+
+func readUvarint(b []byte) (uint64, []byte, error) {
+	x, n := binary.Uvarint(b)
+	if n <= 0 {
+		return 0, nil, errInvalidRecord
+	}
+	return x, b[n:], nil
+}
+
+func readString(b []byte) (string, []byte, error) {
+	length, b, err := readUvarint(b)
+	if err != nil {
+		return "", nil, err
+	}
+	if length > uint64(len(b)) {
+		return "", nil, errInvalidRecord
+	}
+	return string(b[:length]), b[length:], nil
+}
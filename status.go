@@ -0,0 +1,159 @@
+package lfm
+
+import (
+	"fmt"
+	"sort"
+	"sync/atomic"
+
+	"github.com/jbvmio/lfm/pipeline"
+	"github.com/jbvmio/lfm/plugin"
+)
+
+// PipelineStatus describes the layout and current state of a Pipeline.
+// A Pipeline is ready when it is running, all Inputs and Outputs are healthy and no Stage is stalled.
+type PipelineStatus struct {
+	Name       string         `json:"name"`
+	Running    bool           `json:"running"`
+	Ready      bool           `json:"ready"`
+	Inputs     []PluginStatus `json:"inputs"`
+	Outputs    []PluginStatus `json:"outputs"`
+	DeadLetter *PluginStatus  `json:"deadLetter,omitempty"`
+	Stages     []StageStatus  `json:"stages"`
+}
+
+// PluginStatus describes the current state of an Input or Output.
+// Breaker and QueueDepth are only reported for Outputs.
+type PluginStatus struct {
+	Plugin     string `json:"plugin"`
+	Healthy    bool   `json:"healthy"`
+	Error      string `json:"error,omitempty"`
+	Breaker    string `json:"breaker,omitempty"`
+	QueueDepth int    `json:"queueDepth,omitempty"`
+}
+
+// StageStatus describes the layout and current state of a Stage.
+type StageStatus struct {
+	Stage int `json:"stage"`
+	pipeline.StageStatus
+	Steps []StepStatus `json:"steps"`
+}
+
+// StepStatus describes the Drivers used within a Step.
+type StepStatus struct {
+	Step    int      `json:"step"`
+	Drivers []string `json:"drivers"`
+}
+
+// Running returns true if the Pipeline has been started and not stopped.
+func (p *Pipeline) Running() bool {
+	return atomic.LoadInt32(&p.running) == 1
+}
+
+// Status returns the current PipelineStatus.
+func (p *Pipeline) Status() PipelineStatus {
+	ps := PipelineStatus{
+		Name:    p.Name,
+		Running: p.Running(),
+		Inputs:  make([]PluginStatus, len(p.Inputs)),
+		Outputs: make([]PluginStatus, len(p.Outputs)),
+	}
+	ps.Ready = ps.Running
+	for n, x := range p.Inputs {
+		ps.Inputs[n] = pluginStatus(configName(p.Config.Sources, n), x)
+		ps.Ready = ps.Ready && ps.Inputs[n].Healthy
+	}
+	for n, x := range p.Outputs {
+		ps.Outputs[n] = pluginStatus(configName(p.Config.Destinations, n), x)
+		if n < len(p.deliverers) {
+			ps.Outputs[n].Breaker = `closed`
+			ps.Outputs[n].QueueDepth = len(p.deliverers[n].queue)
+			if p.deliverers[n].breakerOpen() {
+				ps.Outputs[n].Breaker = `open`
+				ps.Outputs[n].Healthy = false
+				if ps.Outputs[n].Error == "" {
+					ps.Outputs[n].Error = ErrCircuitOpen.Error()
+				}
+			}
+		}
+		ps.Ready = ps.Ready && ps.Outputs[n].Healthy
+	}
+	if p.DeadLetter != nil {
+		dl := pluginStatus(fmt.Sprint(p.Config.DeadLetter[`plugin`]), p.DeadLetter)
+		ps.DeadLetter = &dl
+	}
+	layout := p.Config.StageOrder()
+	ps.Stages = make([]StageStatus, len(p.P.Stages))
+	for n, s := range p.P.Stages {
+		ps.Stages[n] = StageStatus{
+			Stage:       n,
+			StageStatus: s.Status(),
+		}
+		if n < len(layout) {
+			ps.Stages[n].Steps = stepStatus(layout[n].Steps)
+		}
+		ps.Ready = ps.Ready && !ps.Stages[n].Stalled
+	}
+	return ps
+}
+
+// Status returns the current PipelineStatus for each Pipeline.
+func (P *Pipelines) Status() []PipelineStatus {
+	P.lock.RLock()
+	defer P.lock.RUnlock()
+	status := make([]PipelineStatus, len(P.pls))
+	for i := 0; i < len(P.pls); i++ {
+		status[i] = P.pls[i].Status()
+	}
+	return status
+}
+
+// Running returns true if all Pipelines are running.
+func (P *Pipelines) Running() bool {
+	P.lock.RLock()
+	defer P.lock.RUnlock()
+	for i := 0; i < len(P.pls); i++ {
+		if !P.pls[i].Running() {
+			return false
+		}
+	}
+	return len(P.pls) > 0
+}
+
+func pluginStatus(name string, x plugin.Plugin) PluginStatus {
+	ps := PluginStatus{
+		Plugin:  name,
+		Healthy: true,
+	}
+	if err := plugin.Health(x); err != nil {
+		ps.Healthy = false
+		ps.Error = err.Error()
+	}
+	return ps
+}
+
+// stepStatus returns the Steps in processing order, numbered by position as used by the Stage.
+func stepStatus(steps []Step) []StepStatus {
+	ordered := make([]Step, len(steps))
+	copy(ordered, steps)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].Step < ordered[j].Step
+	})
+	status := make([]StepStatus, len(ordered))
+	for i, s := range ordered {
+		status[i] = StepStatus{
+			Step:    i,
+			Drivers: make([]string, len(s.Workflow)),
+		}
+		for n, wf := range s.Workflow {
+			status[i].Drivers[n] = fmt.Sprint(wf[`driver`])
+		}
+	}
+	return status
+}
+
+func configName(details []map[string]interface{}, n int) string {
+	if n < len(details) {
+		return fmt.Sprint(details[n][`plugin`])
+	}
+	return ""
+}
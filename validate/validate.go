@@ -0,0 +1,538 @@
+// Package validate checks lfm configuration files, including any includes and stageFiles, against the fields
+// of the registered Plugins and Drivers, reporting every error found with its file, line and column.
+package validate
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jbvmio/lfm"
+	"github.com/jbvmio/lfm/driver"
+	"github.com/jbvmio/lfm/internal/loader"
+	"github.com/jbvmio/lfm/pipeline"
+	"github.com/jbvmio/lfm/plugin"
+	"github.com/jbvmio/lfm/queue"
+	yaml2 "gopkg.in/yaml.v2"
+	"gopkg.in/yaml.v3"
+)
+
+// Error is a configuration error found at a location within a file.
+type Error struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+}
+
+// File validates the configuration file at path and every file it includes or references, returning all errors found.
+func File(path string) []Error {
+	return check(loader.Load(path))
+}
+
+// Bytes validates the configuration contents, using name as the file name when reporting errors
+// and resolving relative includes and stageFiles.
+func Bytes(name string, b []byte) []Error {
+	return check(loader.LoadBytes(name, b))
+}
+
+func check(doc *loader.Document, loadErrs []*loader.Error) []Error {
+	c := &checker{doc: doc, invalid: make(map[*yaml.Node]bool)}
+	for _, e := range loadErrs {
+		c.errs = append(c.errs, Error(*e))
+	}
+	eachKey(doc.Root, func(k, v *yaml.Node) {
+		c.config(k.Value, v)
+	})
+	order := make(map[string]int, len(doc.Files))
+	for n, f := range doc.Files {
+		order[f] = n
+	}
+	sort.SliceStable(c.errs, func(i, j int) bool {
+		a, b := c.errs[i], c.errs[j]
+		if a.File != b.File {
+			return order[a.File] < order[b.File]
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+	return c.errs
+}
+
+// checker collects the Errors found while validating a Document.
+// Invalid values are recorded and omitted when decoding, so the remaining fields are still checked.
+type checker struct {
+	doc     *loader.Document
+	errs    []Error
+	invalid map[*yaml.Node]bool
+}
+
+func (c *checker) errorf(n *yaml.Node, format string, args ...interface{}) {
+	c.errs = append(c.errs, Error{File: c.doc.File(n), Line: n.Line, Column: n.Column, Msg: fmt.Sprintf(format, args...)})
+}
+
+// config checks the Config of the named pipeline.
+func (c *checker) config(name string, n *yaml.Node) {
+	if !c.kind(n, yaml.MappingNode, "pipeline "+name) {
+		return
+	}
+	var outputs bool
+	var deadLetter *yaml.Node
+	var deliveries [][2]*yaml.Node
+	eachKey(n, func(k, v *yaml.Node) {
+		switch k.Value {
+		case `sources`:
+			c.each(v, `sources`, func(x *yaml.Node) {
+				c.plugin(x, `input`, nil)
+			})
+		case `destinations`:
+			c.each(v, `destinations`, func(x *yaml.Node) {
+				outputs = true
+				if d := c.plugin(x, `output`, []string{`delivery`}); d != nil {
+					deliveries = append(deliveries, [2]*yaml.Node{x, d})
+				}
+			})
+		case `deadLetter`:
+			deadLetter = v
+			c.plugin(v, `output`, nil)
+		case `queue`:
+			if c.kind(v, yaml.MappingNode, `queue`) {
+				c.fields(v, reflect.TypeOf(queue.Config{}), nil, `queue`)
+				var q queue.Config
+				if c.decode(v, &q) {
+					if err := q.Validate(); err != nil {
+						c.errorf(v, "%v", err)
+					}
+				}
+			}
+		case `processors`:
+			c.processors(v)
+		default:
+			c.errorf(k, "unknown key %q in pipeline %s", k.Value, name)
+		}
+	})
+	if !outputs {
+		c.errorf(n, "pipeline %s has no destinations", name)
+	}
+	for _, d := range deliveries {
+		var details map[string]interface{}
+		if !c.decode(d[0], &details) {
+			continue
+		}
+		del, err := lfm.DeliveryFromConfig(details)
+		if err != nil {
+			c.errorf(d[1], "%v", err)
+			continue
+		}
+		if del.Policy == lfm.PolicyDeadLetter && deadLetter == nil {
+			c.errorf(d[1], "deadLetter delivery policy requires a deadLetter output")
+		}
+	}
+}
+
+// plugin checks the details of an input or output Plugin, returning the node of the delivery key if present.
+// Any extra keys are allowed in addition to the fields of the Plugin Config.
+func (c *checker) plugin(n *yaml.Node, kind string, extra []string) (delivery *yaml.Node) {
+	if !c.kind(n, yaml.MappingNode, kind) {
+		return nil
+	}
+	nameNode := value(n, `plugin`)
+	if nameNode == nil || nameNode.Kind != yaml.ScalarNode || nameNode.Value == "" {
+		c.errorf(n, "missing or invalid plugin for %s", kind)
+		return nil
+	}
+	name := nameNode.Value
+	var cfg plugin.Config
+	var err error
+	var available []string
+	switch kind {
+	case `input`:
+		cfg, err = plugin.NewInputConfig(name)
+		available = plugin.Inputs()
+	default:
+		cfg, err = plugin.NewOutputConfig(name)
+		available = plugin.Outputs()
+	}
+	if err != nil {
+		c.errorf(nameNode, "unknown %s plugin %q, available: %s", kind, name, strings.Join(available, `, `))
+		return nil
+	}
+	allowed := map[string]bool{`plugin`: true}
+	for _, k := range extra {
+		allowed[k] = true
+	}
+	c.fields(n, reflect.TypeOf(cfg), allowed, kind+" plugin "+name)
+	if d := value(n, `delivery`); d != nil && allowed[`delivery`] {
+		c.schema(d, reflect.TypeOf(lfm.Delivery{}))
+		delivery = d
+	}
+	c.configure(n, cfg)
+	return delivery
+}
+
+// processors checks the Stages of a pipeline.
+func (c *checker) processors(n *yaml.Node) {
+	seen := make(map[int]bool)
+	c.each(n, `processors`, func(x *yaml.Node) {
+		if c.doc.Unexpanded(x) || !c.kind(x, yaml.MappingNode, `stage`) {
+			return
+		}
+		var stage lfm.Stage
+		var steps, stageFile *yaml.Node
+		eachKey(x, func(k, v *yaml.Node) {
+			switch k.Value {
+			case `steps`:
+				steps = v
+			case `stageFile`:
+				stageFile = v
+				c.schema(v, reflect.TypeOf(""))
+			case `stage`, `workers`, `queue`, `ordering`, `orderKey`:
+				c.schema(v, fieldType(reflect.TypeOf(stage), k.Value))
+			default:
+				c.errorf(k, "unknown key %q in stage", k.Value)
+			}
+		})
+		var opts lfm.Stage
+		if c.decodeWithout(x, &opts, `steps`) {
+			if s := value(x, `stage`); s == nil || !c.invalid[s] {
+				if seen[opts.Stage] {
+					c.errorf(x, "duplicate stage number %d", opts.Stage)
+				}
+				seen[opts.Stage] = true
+			}
+			if err := opts.ConfigureStage(&pipeline.Stage{}); err != nil {
+				c.errorf(x, "%v", err)
+			}
+		}
+		// The steps of a stageFile are added to the stage when loading.
+		switch {
+		case steps != nil:
+			c.steps(steps)
+		case stageFile == nil:
+			c.errorf(x, "stage %d has no steps or stageFile", opts.Stage)
+		}
+	})
+}
+
+// steps checks the Steps of a Stage.
+func (c *checker) steps(n *yaml.Node) {
+	seen := make(map[int]bool)
+	c.each(n, `steps`, func(x *yaml.Node) {
+		if c.doc.Unexpanded(x) || !c.kind(x, yaml.MappingNode, `step`) {
+			return
+		}
+		var workflow bool
+		eachKey(x, func(k, v *yaml.Node) {
+			switch k.Value {
+			case `step`:
+				var step int
+				if c.schema(v, reflect.TypeOf(step)) && c.decode(v, &step) {
+					if seen[step] {
+						c.errorf(v, "duplicate step number %d", step)
+					}
+					seen[step] = true
+				}
+			case `workflow`:
+				workflow = true
+				switch v.Kind {
+				case yaml.SequenceNode:
+					if len(v.Content) < 1 {
+						c.errorf(v, "empty workflow")
+					}
+					for _, wf := range v.Content {
+						c.driver(resolve(wf))
+					}
+				default:
+					c.driver(v)
+				}
+			default:
+				c.errorf(k, "unknown key %q in step", k.Value)
+			}
+		})
+		if !workflow {
+			c.errorf(x, "step has no workflow")
+		}
+	})
+}
+
+// driver checks the details of a workflow against the Config of its Driver.
+func (c *checker) driver(n *yaml.Node) {
+	if !c.kind(n, yaml.MappingNode, `workflow`) {
+		return
+	}
+	nameNode := value(n, `driver`)
+	if nameNode == nil || nameNode.Kind != yaml.ScalarNode || nameNode.Value == "" {
+		c.errorf(n, "missing or invalid driver for workflow")
+		return
+	}
+	cfg, err := driver.NewConfig(nameNode.Value)
+	if err != nil {
+		c.errorf(nameNode, "unknown driver %q, available: %s", nameNode.Value, strings.Join(driver.Drivers(), `, `))
+		return
+	}
+	if cfg == nil {
+		return
+	}
+	c.fields(n, reflect.TypeOf(cfg), map[string]bool{`driver`: true}, "driver "+nameNode.Value)
+	c.configure(n, cfg)
+}
+
+// configure applies the valid details to the Config, calling Validate if implemented, recording any error.
+func (c *checker) configure(n *yaml.Node, cfg interface {
+	Configure(map[string]interface{}) error
+}) {
+	var details map[string]interface{}
+	if !c.decode(n, &details) {
+		return
+	}
+	if err := cfg.Configure(details); err != nil {
+		c.errorf(n, "%v", err)
+		return
+	}
+	if v, ok := cfg.(interface{ Validate() error }); ok {
+		if err := v.Validate(); err != nil {
+			c.errorf(n, "%v", err)
+		}
+	}
+}
+
+// fields checks the keys and values of the mapping against the fields of the struct type t.
+// Keys in allowed are accepted without checking. Returns false if any values were invalid,
+// unknown keys are reported but otherwise ignored as when loading the configuration.
+func (c *checker) fields(n *yaml.Node, t reflect.Type, allowed map[string]bool, what string) bool {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	known := structFields(t)
+	ok := true
+	eachKey(n, func(k, v *yaml.Node) {
+		if allowed[k.Value] {
+			return
+		}
+		ft, there := known[k.Value]
+		if !there {
+			c.errorf(k, "unknown key %q for %s", k.Value, what)
+			return
+		}
+		ok = c.schema(v, ft) && ok
+	})
+	return ok
+}
+
+var unmarshalerType = reflect.TypeOf((*yaml2.Unmarshaler)(nil)).Elem()
+
+// schema checks the node against the type t, returning false if any errors were found.
+// Invalid values are recorded, allowing the rest of the node to be decoded.
+func (c *checker) schema(n *yaml.Node, t reflect.Type) bool {
+	n = resolve(n)
+	if c.doc.Unexpanded(n) {
+		return c.invalidate(n)
+	}
+	if n.Kind == yaml.ScalarNode && n.Tag == `!!null` {
+		return true
+	}
+	if reflect.PtrTo(t).Implements(unmarshalerType) {
+		return c.decode(n, reflect.New(t).Interface()) || c.invalidate(n)
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return c.schema(n, t.Elem())
+	case reflect.Interface:
+		return true
+	case reflect.Struct:
+		if !c.kind(n, yaml.MappingNode, t.Name()) {
+			return c.invalidate(n)
+		}
+		return c.fields(n, t, nil, t.String())
+	case reflect.Map:
+		if !c.kind(n, yaml.MappingNode, `map`) {
+			return c.invalidate(n)
+		}
+		ok := true
+		eachKey(n, func(_, v *yaml.Node) {
+			ok = c.schema(v, t.Elem()) && ok
+		})
+		return ok
+	case reflect.Slice, reflect.Array:
+		if !c.kind(n, yaml.SequenceNode, `list`) {
+			return c.invalidate(n)
+		}
+		ok := true
+		for _, x := range n.Content {
+			ok = c.schema(x, t.Elem()) && ok
+		}
+		return ok
+	}
+	if !c.kind(n, yaml.ScalarNode, t.String()) {
+		return c.invalidate(n)
+	}
+	return c.decode(n, reflect.New(t).Interface()) || c.invalidate(n)
+}
+
+// invalidate records the node as invalid, returning false.
+func (c *checker) invalidate(n *yaml.Node) bool {
+	c.invalid[n] = true
+	return false
+}
+
+// prune returns a copy of the node without any values recorded as invalid.
+func (c *checker) prune(n *yaml.Node) *yaml.Node {
+	n = resolve(n)
+	switch n.Kind {
+	case yaml.MappingNode:
+		cp := *n
+		cp.Content = nil
+		eachKey(n, func(k, v *yaml.Node) {
+			if !c.invalid[v] {
+				cp.Content = append(cp.Content, k, c.prune(v))
+			}
+		})
+		return &cp
+	case yaml.SequenceNode:
+		cp := *n
+		cp.Content = nil
+		for _, x := range n.Content {
+			if x = resolve(x); !c.invalid[x] {
+				cp.Content = append(cp.Content, c.prune(x))
+			}
+		}
+		return &cp
+	}
+	return n
+}
+
+// decode decodes the node into x as done when loading the configuration, recording any error.
+func (c *checker) decode(n *yaml.Node, x interface{}) bool {
+	return c.decodeWithout(n, x)
+}
+
+// decodeWithout decodes the node into x, ignoring the given keys of a mapping and any invalid values.
+func (c *checker) decodeWithout(n *yaml.Node, x interface{}, skip ...string) bool {
+	n = c.prune(n)
+	if len(skip) > 0 && n.Kind == yaml.MappingNode {
+		cp := *n
+		cp.Content = nil
+		eachKey(n, func(k, v *yaml.Node) {
+			for _, s := range skip {
+				if k.Value == s {
+					return
+				}
+			}
+			cp.Content = append(cp.Content, k, v)
+		})
+		n = &cp
+	}
+	b, err := yaml.Marshal(n)
+	if err == nil {
+		err = yaml2.Unmarshal(b, x)
+	}
+	if err != nil {
+		c.errorf(n, "%s", decodeMsg(err))
+		return false
+	}
+	return true
+}
+
+var decodeRegex = regexp.MustCompile(`line \d+: `)
+
+func decodeMsg(err error) string {
+	msg := strings.TrimPrefix(err.Error(), "yaml: unmarshal errors:\n")
+	msg = decodeRegex.ReplaceAllString(msg, "")
+	return strings.TrimSpace(strings.TrimPrefix(msg, "yaml: "))
+}
+
+func (c *checker) kind(n *yaml.Node, kind yaml.Kind, what string) bool {
+	if n.Kind == kind {
+		return true
+	}
+	var want string
+	switch kind {
+	case yaml.MappingNode:
+		want = `a map`
+	case yaml.SequenceNode:
+		want = `a list`
+	default:
+		want = `a value`
+	}
+	c.errorf(n, "expected %s for %s", want, what)
+	return false
+}
+
+// each calls fn with each item of a sequence.
+func (c *checker) each(n *yaml.Node, what string, fn func(*yaml.Node)) {
+	n = resolve(n)
+	if !c.kind(n, yaml.SequenceNode, what) {
+		return
+	}
+	for _, x := range n.Content {
+		fn(resolve(x))
+	}
+}
+
+// eachKey calls fn with each key and value of a mapping.
+func eachKey(n *yaml.Node, fn func(k, v *yaml.Node)) {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		fn(n.Content[i], resolve(n.Content[i+1]))
+	}
+}
+
+// value returns the value of the key in a mapping, or nil if not found.
+func value(n *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return resolve(n.Content[i+1])
+		}
+	}
+	return nil
+}
+
+func resolve(n *yaml.Node) *yaml.Node {
+	for n.Kind == yaml.AliasNode && n.Alias != nil {
+		n = n.Alias
+	}
+	return n
+}
+
+// structFields returns the types of the fields of t by their yaml key, including inlined structs.
+func structFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get(`yaml`)
+		if tag == `-` || (f.PkgPath != "" && !f.Anonymous) {
+			continue
+		}
+		parts := strings.Split(tag, `,`)
+		inline := false
+		for _, p := range parts[1:] {
+			inline = inline || p == `inline`
+		}
+		if inline && f.Type.Kind() == reflect.Struct {
+			for k, v := range structFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		key := parts[0]
+		if key == "" {
+			key = strings.ToLower(f.Name)
+		}
+		fields[key] = f.Type
+	}
+	return fields
+}
+
+func fieldType(t reflect.Type, key string) reflect.Type {
+	if ft, ok := structFields(t)[key]; ok {
+		return ft
+	}
+	return reflect.TypeOf((*interface{})(nil)).Elem()
+}
@@ -0,0 +1,82 @@
+package validate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBytesReportsAfterTypeErrors(t *testing.T) {
+	errs := Bytes("bad.yaml", []byte(`
+bad:
+  sources:
+  - plugin: file
+    path: /tmp/in.txt
+  destinations:
+  - plugin: stdout
+    delivery:
+      timeout: abc
+      policy: sideways
+  processors:
+  - stage: 1
+    workers: many
+    ordering: sideways
+    steps:
+    - step: 1
+      workflow:
+      - driver: json
+        method: extract
+        unknownOption: true
+        fieldActions:
+        - path: a
+          action: notAnAction
+        - path: b
+          conditions: 5
+    - step: 1
+      workflow:
+      - method: extract
+`))
+	want := []string{
+		"bad.yaml:9:7: invalid delivery policy: sideways",
+		"bad.yaml:9:16: cannot unmarshal !!str `abc` into time.Duration",
+		"bad.yaml:12:5: stage 1: invalid ordering: sideways",
+		"bad.yaml:13:14: cannot unmarshal !!str `many` into int",
+		"bad.yaml:18:9: invalid action for path a: notAnAction",
+		`bad.yaml:20:9: unknown key "unknownOption" for driver json`,
+		"bad.yaml:25:23: expected a list for list",
+		"bad.yaml:26:13: duplicate step number 1",
+		"bad.yaml:28:9: missing or invalid driver for workflow",
+	}
+	got := make([]string, len(errs))
+	for i, e := range errs {
+		got[i] = e.Error()
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("expected errors:\n%s\ngot:\n%s", strings.Join(want, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestBytesValid(t *testing.T) {
+	errs := Bytes("good.yaml", []byte(`
+good:
+  sources:
+  - plugin: file
+    path: /tmp/in.txt
+  destinations:
+  - plugin: stdout
+  processors:
+  - stage: 1
+    workers: 2
+    ordering: ordered
+    steps:
+    - step: 1
+      workflow:
+      - driver: json
+        method: extract
+        fieldActions:
+        - path: .
+          action: addField(src)
+`))
+	for _, e := range errs {
+		t.Error(e)
+	}
+}